@@ -0,0 +1,87 @@
+// IP address handling shared by the library and providers: parsing, address families, network containment
+// and canonical formatting, built on net/netip.
+
+package addresses
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Address families, named like corev1.IPFamily.
+const (
+	IPv4 = "IPv4"
+	IPv6 = "IPv6"
+)
+
+// Parse an address. IPv4-mapped IPv6 addresses are converted to IPv4; addresses with a zone are rejected.
+func Parse(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid address '%s'", s)
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("invalid address '%s': zones are not supported", s)
+	}
+	return addr.Unmap(), nil
+}
+
+// Returns the address in canonical form, for example with IPv6 zeros compressed, so addresses can be compared as strings.
+func Canonical(s string) (string, error) {
+	addr, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// Returns the family of the address, IPv4 or IPv6.
+func Family(addr netip.Addr) string {
+	if addr.Unmap().Is4() {
+		return IPv4
+	}
+	return IPv6
+}
+
+// Returns the family of the address, or "" if it is not valid.
+func FamilyOf(s string) string {
+	addr, err := Parse(s)
+	if err != nil {
+		return ""
+	}
+	return Family(addr)
+}
+
+// Parse a network in CIDR notation. The prefix is masked, so "10.0.0.1/8" becomes "10.0.0.0/8".
+func ParsePrefix(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network '%s'", s)
+	}
+	return prefix.Masked(), nil
+}
+
+// Parse a list of networks in CIDR notation.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		prefix, err := ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Returns true if the address is in any of the networks.
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package lbutil
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// Everything that goes into an IpAddress requested for a Service.
+type AddressOptions struct {
+
+	// Stored in the spec. Ref is free-form, for example a reference to the network in IPAM.
+	Ref         string
+	Description string
+
+	// The address pool, stored in the vip-pool annotation of the IpAddress.
+	Pool string
+
+	// The address asked for, stored in the requested-ip annotation of the IpAddress for IPAM backends that honor it.
+	RequestedIP string
+
+	// See SetLease and SetQuarantine.
+	LeaseDuration    time.Duration
+	QuarantinePeriod time.Duration
+
+	// Added to the IpAddress.
+	Labels      map[string]string
+	Annotations map[string]string
+
+	// If false, the IpAddress has no owner reference to the Service and is not garbage collected with it.
+	OwnerReference bool
+
+	// Mark the owner reference as the controller reference.
+	Controller bool
+
+	// Set blockOwnerDeletion on the owner reference, so foreground deletion of the Service waits for the IpAddress.
+	// With the OwnerReferencesPermissionEnforcement admission plugin, this needs permission to update services/finalizers.
+	BlockOwnerDeletion bool
+}
+
+// Returns the options for the IpAddress of the Service, as configured in the Clients and on the Service.
+func (c *Clients) AddressOptions(service *corev1.Service) AddressOptions {
+	opts := AddressOptions{
+		Description:        c.addressDescription(service),
+		Pool:               c.poolFor(service),
+		RequestedIP:        service.Annotations[AnnNxRequestedIP],
+		LeaseDuration:      c.LeaseDuration,
+		QuarantinePeriod:   c.QuarantinePeriod,
+		OwnerReference:     c.releasePolicy(service) != ReleasePolicyRetain,
+		Controller:         c.ControllerOwnerReference,
+		BlockOwnerDeletion: c.BlockOwnerDeletion,
+	}
+
+	if c.Propagation != nil {
+		c.Propagation.apply(service, &opts)
+	}
+
+	if c.CustomizeAddress != nil {
+		c.CustomizeAddress(service, &opts)
+	}
+
+	return opts
+}
+
+// Build the IpAddress with the name for the Service.
+func NewIpAddress(service *corev1.Service, name string, opts AddressOptions) *ipamv1.IpAddress {
+	address := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   service.Namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: ipamv1.IpAddressSpec{
+			Ref:         opts.Ref,
+			Description: opts.Description,
+		},
+	}
+
+	for k, v := range opts.Labels {
+		address.Labels[k] = v
+	}
+	for k, v := range opts.Annotations {
+		address.Annotations[k] = v
+	}
+
+	if opts.OwnerReference {
+		ref := metav1.OwnerReference{
+			Name:       service.GetName(),
+			Kind:       "Service",
+			APIVersion: "v1",
+			UID:        service.GetUID(),
+		}
+		if opts.Controller {
+			ref.Controller = &opts.Controller
+		}
+		if opts.BlockOwnerDeletion {
+			ref.BlockOwnerDeletion = &opts.BlockOwnerDeletion
+		}
+		address.OwnerReferences = []metav1.OwnerReference{ref}
+	} else {
+		// Without an owner reference, the Service is found by its namespace and name (see ReleasePolicyRetain).
+		address.Labels[LabelNxServiceNamespace] = service.Namespace
+		address.Labels[LabelNxServiceName] = service.Name
+	}
+	SetAddressOwnerLabel(address, service)
+
+	if opts.Pool != "" {
+		address.Annotations[AnnNxVIPPool] = opts.Pool
+	}
+	if opts.RequestedIP != "" {
+		address.Annotations[AnnNxRequestedIP] = opts.RequestedIP
+	}
+	if opts.LeaseDuration != 0 {
+		SetLease(address, opts.LeaseDuration)
+	}
+	if opts.QuarantinePeriod != 0 {
+		SetQuarantine(address, opts.QuarantinePeriod)
+	}
+
+	return address
+}
@@ -0,0 +1,430 @@
+// Hands out addresses from networks in memory, without a cluster: the allocation logic of SimIPAM and
+// fake.IPAM, for controllers and tests that need a simple IPAM of their own.
+
+package allocator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+var (
+
+	// Returned by Allocate if all addresses are in use.
+	ErrExhausted = errors.New("no free addresses")
+
+	// Returned by AllocateAddress if the address belongs to another owner or was released recently.
+	ErrInUse = errors.New("address is in use")
+)
+
+// The allocations of an Allocator, for storing them, for example as JSON in a ConfigMap (see Restore).
+type State struct {
+
+	// Addresses and their owners.
+	Allocations map[string]string `json:"allocations"`
+
+	// Released addresses that are not handed out again yet, and when they were released.
+	Released map[string]time.Time `json:"released,omitempty"`
+}
+
+// Hands out the addresses of its networks to owners, which are free-form keys like "namespace/name".
+// Each owner has at most one address. Addresses are handed out lowest first; the network and broadcast
+// addresses of IPv4 networks and the first address of IPv6 networks are not used. Safe for concurrent use.
+type Allocator struct {
+
+	// If not zero, only the first Capacity usable addresses of each network are handed out.
+	Capacity int
+
+	// How long a released address is not handed out again.
+	ReuseDelay time.Duration
+
+	lock        sync.Mutex
+	networks    []netip.Prefix
+	allocations map[netip.Addr]string
+	owners      map[string]netip.Addr
+	released    map[netip.Addr]time.Time
+}
+
+// Create an Allocator for the networks in CIDR notation. Duplicates are ignored.
+func New(cidrs ...string) (*Allocator, error) {
+	prefixes, err := addresses.ParsePrefixes(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Allocator{
+		allocations: map[netip.Addr]string{},
+		owners:      map[string]netip.Addr{},
+		released:    map[netip.Addr]time.Time{},
+	}
+	for _, prefix := range prefixes {
+		if !contains(a.networks, prefix) {
+			a.networks = append(a.networks, prefix)
+		}
+	}
+	return a, nil
+}
+
+// Returns the networks of the Allocator.
+func (a *Allocator) Networks() []netip.Prefix {
+	return append([]netip.Prefix(nil), a.networks...)
+}
+
+// Returns true if the address can be handed out by the Allocator, whether it is free or not.
+func (a *Allocator) Contains(s string) bool {
+	addr, err := addresses.Parse(s)
+	if err != nil {
+		return false
+	}
+	for _, network := range a.networks {
+		if a.usable(network, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hand out the lowest free address of the first network that has one. If the owner has an address
+// already, it is returned.
+func (a *Allocator) Allocate(owner string) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if addr, ok := a.owners[owner]; ok {
+		return addr.String(), nil
+	}
+
+	for _, network := range a.networks {
+		if addr, ok := a.next(network); ok {
+			a.take(owner, addr)
+			return addr.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w in %v", ErrExhausted, a.networks)
+}
+
+// Hand out the lowest free address of the network, which must be one of the networks of the Allocator.
+// If the owner has an address already, it is returned.
+func (a *Allocator) AllocateFrom(cidr, owner string) (string, error) {
+	network, err := addresses.ParsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	if !contains(a.networks, network) {
+		return "", fmt.Errorf("unknown network %s", network)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if addr, ok := a.owners[owner]; ok {
+		return addr.String(), nil
+	}
+
+	addr, ok := a.next(network)
+	if !ok {
+		return "", fmt.Errorf("%w in %s", ErrExhausted, network)
+	}
+	a.take(owner, addr)
+	return addr.String(), nil
+}
+
+// Hand out a specific address, for example a requested one or one that was assigned before the Allocator
+// knew about it. Fails if the address is not in the networks, belongs to another owner or the owner has
+// another address.
+func (a *Allocator) AllocateAddress(owner, s string) error {
+	addr, err := addresses.Parse(s)
+	if err != nil {
+		return err
+	}
+	if !a.Contains(s) {
+		return fmt.Errorf("address %s is not in %v", addr, a.networks)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if current, ok := a.owners[owner]; ok {
+		if current == addr {
+			return nil
+		}
+		return fmt.Errorf("'%s' has address %s already", owner, current)
+	}
+	if other, ok := a.allocations[addr]; ok {
+		return fmt.Errorf("%w: %s belongs to '%s'", ErrInUse, addr, other)
+	}
+	if a.recentlyReleased(addr, time.Now()) {
+		return fmt.Errorf("%w: %s was released recently", ErrInUse, addr)
+	}
+
+	a.take(owner, addr)
+	return nil
+}
+
+// Release the address of the owner. Returns the address, or "" if the owner has none.
+func (a *Allocator) Release(owner string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	addr, ok := a.owners[owner]
+	if !ok {
+		return ""
+	}
+
+	delete(a.owners, owner)
+	delete(a.allocations, addr)
+	if a.ReuseDelay > 0 {
+		a.released[addr] = time.Now()
+	}
+	return addr.String()
+}
+
+// Returns the address of the owner, or "".
+func (a *Allocator) Address(owner string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if addr, ok := a.owners[owner]; ok {
+		return addr.String()
+	}
+	return ""
+}
+
+// Returns the owner of the address, or "".
+func (a *Allocator) Owner(s string) string {
+	addr, err := addresses.Parse(s)
+	if err != nil {
+		return ""
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.allocations[addr]
+}
+
+// Returns the allocated addresses and their owners.
+func (a *Allocator) Allocations() map[string]string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	allocations := make(map[string]string, len(a.allocations))
+	for addr, owner := range a.allocations {
+		allocations[addr.String()] = owner
+	}
+	return allocations
+}
+
+// Returns the released addresses that are not handed out again yet, and when they were released.
+func (a *Allocator) Released() map[string]time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+	released := map[string]time.Time{}
+	for addr, at := range a.released {
+		if a.recentlyReleased(addr, now) {
+			released[addr.String()] = at
+		}
+	}
+	return released
+}
+
+// Returns the number of addresses that can be handed out, in all networks. Capped at math.MaxInt.
+func (a *Allocator) Size() int {
+	size := 0
+	for _, network := range a.networks {
+		n := a.size(network)
+		if n > math.MaxInt-size {
+			return math.MaxInt
+		}
+		size += n
+	}
+	return size
+}
+
+// Returns the number of addresses that can be handed out now.
+func (a *Allocator) Free() int {
+	size := a.Size()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+	for addr := range a.allocations {
+		if a.inNetworks(addr) {
+			size--
+		}
+	}
+	for addr := range a.released {
+		if a.recentlyReleased(addr, now) && a.inNetworks(addr) {
+			size--
+		}
+	}
+	return size
+}
+
+// Returns the allocations, for storing them.
+func (a *Allocator) State() State {
+	state := State{Allocations: a.Allocations(), Released: a.Released()}
+	if len(state.Released) == 0 {
+		state.Released = nil
+	}
+	return state
+}
+
+// Replace the allocations with the stored ones. Allocations outside the networks are kept, so they
+// are not lost if the networks change. Nothing is changed if the state is invalid.
+func (a *Allocator) Restore(state State) error {
+	allocations := map[netip.Addr]string{}
+	owners := map[string]netip.Addr{}
+	for s, owner := range state.Allocations {
+		addr, err := addresses.Parse(s)
+		if err != nil {
+			return err
+		}
+		if other, ok := owners[owner]; ok {
+			return fmt.Errorf("'%s' has two addresses, %s and %s", owner, other, addr)
+		}
+		allocations[addr] = owner
+		owners[owner] = addr
+	}
+
+	released := map[netip.Addr]time.Time{}
+	for s, at := range state.Released {
+		addr, err := addresses.Parse(s)
+		if err != nil {
+			return err
+		}
+		released[addr] = at
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.allocations = allocations
+	a.owners = owners
+	a.released = released
+	return nil
+}
+
+// Returns the lowest address of the network that can be handed out. Must be called with the lock held.
+func (a *Allocator) next(network netip.Prefix) (netip.Addr, bool) {
+	now := time.Now()
+	n := 0
+	for addr := network.Addr(); network.Contains(addr); addr = addr.Next() {
+		if !usable(network, addr) {
+			continue
+		}
+		if a.Capacity > 0 && n == a.Capacity {
+			break
+		}
+		n++
+
+		if _, ok := a.allocations[addr]; ok {
+			continue
+		}
+		if a.recentlyReleased(addr, now) {
+			continue
+		}
+		delete(a.released, addr)
+		return addr, true
+	}
+	return netip.Addr{}, false
+}
+
+// Record the address as the owner's. Must be called with the lock held.
+func (a *Allocator) take(owner string, addr netip.Addr) {
+	a.allocations[addr] = owner
+	a.owners[owner] = addr
+	delete(a.released, addr)
+}
+
+// Must be called with the lock held.
+func (a *Allocator) recentlyReleased(addr netip.Addr, now time.Time) bool {
+	at, ok := a.released[addr]
+	return ok && now.Sub(at) < a.ReuseDelay
+}
+
+func (a *Allocator) inNetworks(addr netip.Addr) bool {
+	for _, network := range a.networks {
+		if a.usable(network, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the address of the network can be handed out, considering the Capacity.
+func (a *Allocator) usable(network netip.Prefix, addr netip.Addr) bool {
+	if !network.Contains(addr) || !usable(network, addr) {
+		return false
+	}
+	if a.Capacity == 0 {
+		return true
+	}
+	return offset(network, addr) <= uint64(a.Capacity)
+}
+
+// Returns the number of addresses of the network that can be handed out.
+func (a *Allocator) size(network netip.Prefix) int {
+	hostBits := network.Addr().BitLen() - network.Bits()
+
+	n := math.MaxInt
+	if hostBits < 62 {
+		n = 1 << hostBits
+		if network.Addr().Is4() && hostBits > 1 {
+			n -= 2
+		} else if network.Addr().Is6() && hostBits > 1 {
+			n--
+		}
+	}
+
+	if a.Capacity > 0 && a.Capacity < n {
+		return a.Capacity
+	}
+	return n
+}
+
+// Returns false for the network and broadcast addresses of IPv4 networks and the first address of IPv6
+// networks (the subnet-router anycast address). Point-to-point networks use all addresses.
+func usable(network netip.Prefix, addr netip.Addr) bool {
+	hostBits := addr.BitLen() - network.Bits()
+	if hostBits <= 1 {
+		return true
+	}
+	if addr == network.Addr() {
+		return false
+	}
+	if addr.Is4() && !network.Contains(addr.Next()) {
+		return false
+	}
+	return true
+}
+
+// Returns the position of the address in the network, counting from 1 for the first usable address.
+// Only the low 64 bits are considered.
+func offset(network netip.Prefix, addr netip.Addr) uint64 {
+	a, b := addr.As16(), network.Addr().As16()
+	var x, y uint64
+	for i := 8; i < 16; i++ {
+		x = x<<8 | uint64(a[i])
+		y = y<<8 | uint64(b[i])
+	}
+	return x - y
+}
+
+func contains(prefixes []netip.Prefix, prefix netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Typed access to the lbutil annotations on Services.
+
+package annotations
+
+import (
+	"fmt"
+	"net"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/addresses"
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+// Returns true if the Service requests a VIP. The no-vip annotation takes precedence.
+func IsRequested(service *corev1.Service) bool {
+	return service.Annotations[lbutil.AnnNxReqVIP] != "" && !IsExcluded(service)
+}
+
+// Returns true if the Service opted out of getting a VIP. A VIP it already has is kept.
+func IsExcluded(service *corev1.Service) bool {
+	return service.Annotations[lbutil.AnnNxNoVIP] != ""
+}
+
+// Returns the address requested for the VIP or nil if none was requested.
+func GetRequestedIP(service *corev1.Service) (net.IP, error) {
+	return parseIP(service, lbutil.AnnNxRequestedIP)
+}
+
+// Returns the address pool requested for the VIP or "" for the default pool.
+func GetPool(service *corev1.Service) string {
+	return service.Annotations[lbutil.AnnNxVIPPool]
+}
+
+// Returns the provider requested for the Service or "" if any provider may claim it.
+func GetProvider(service *corev1.Service) string {
+	return service.Annotations[lbutil.AnnNxVIPProvider]
+}
+
+// Returns the provider that claimed the Service or "" if it is not claimed.
+func GetActiveProvider(service *corev1.Service) string {
+	return service.Annotations[lbutil.AnnNxVIPActiveProvider]
+}
+
+// Returns the VIP assigned to the Service or nil if none is assigned.
+func GetAssignedVIP(service *corev1.Service) (net.IP, error) {
+	return parseIP(service, lbutil.AnnNxAssignedVIP)
+}
+
+// Store the VIP assigned to the Service. A nil VIP removes it. Modifies the Service, which must not come from a cache.
+func SetAssignedVIP(service *corev1.Service, vip net.IP) {
+	if vip == nil {
+		set(service, lbutil.AnnNxAssignedVIP, "")
+		return
+	}
+	set(service, lbutil.AnnNxAssignedVIP, vip.String())
+}
+
+// Set the provider that claimed the Service. Modifies the Service, which must not come from a cache.
+func SetActiveProvider(service *corev1.Service, provider string) {
+	set(service, lbutil.AnnNxVIPActiveProvider, provider)
+}
+
+// Check all registered annotations on the Service (see Register) and the combinations of annotations,
+// and return every problem found.
+func Validate(service *corev1.Service) error {
+	errs := validateRegistered(service)
+
+	if IsExcluded(service) && service.Annotations[lbutil.AnnNxReqVIP] != "" {
+		errs = append(errs, fmt.Errorf("%s: conflicts with %s", lbutil.AnnNxNoVIP, lbutil.AnnNxReqVIP))
+	}
+
+	// The health check as Config builds it. Problems of single annotations were reported by their validators.
+	config, _ := Upstream(service)
+	_ = applyHealthCheck(service, &config.HealthCheck)
+	errs = appendErrors(errs, checkHealthCheck(config.HealthCheck))
+
+	_, err := portconfig.Parse(service)
+	errs = appendErrors(errs, err)
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func parseIP(service *corev1.Service, key string) (net.IP, error) {
+	v := service.Annotations[key]
+	if v == "" {
+		return nil, nil
+	}
+	addr, err := addresses.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid address '%s'", key, sanitize.Value(v))
+	}
+	return net.IP(addr.AsSlice()), nil
+}
+
+func set(service *corev1.Service, key, value string) {
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	service.Annotations[key] = value
+}
@@ -0,0 +1,107 @@
+package annotations
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+// The upstream annotation for source ranges, used if spec.loadBalancerSourceRanges is not set.
+const AnnSourceRanges = "service.beta.kubernetes.io/load-balancer-source-ranges"
+
+// Returns the loadbalancer settings of the Service for providers: the upstream annotations (see Upstream),
+// overridden by the lbutil annotations, and the settings from the Service spec. Health check values that are
+// not set are filled in from DefaultHealthCheck. The annotations are decoded to CurrentVersion first (see
+// Decode). All problems are returned at once.
+func Config(service *corev1.Service) (LBConfig, error) {
+	service, _, err := Decode(service)
+	if err != nil {
+		return LBConfig{}, err
+	}
+
+	var errs []error
+
+	config, err := Upstream(service)
+	errs = appendErrors(errs, err)
+
+	errs = append(errs, applyLimits(service, &config)...)
+	errs = append(errs, applyHealthCheck(service, &config.HealthCheck)...)
+	config.HealthCheck = config.HealthCheck.WithDefaults()
+
+	config.SourceRanges, err = SourceRanges(service)
+	errs = appendErrors(errs, err)
+
+	config.SessionAffinity, config.SessionAffinityTimeout = SessionAffinity(service)
+
+	return config, utilerrors.NewAggregate(errs)
+}
+
+// Returns the networks allowed to connect to the loadbalancer, from spec.loadBalancerSourceRanges or the
+// upstream annotation. Nil if all are allowed.
+func SourceRanges(service *corev1.Service) ([]netip.Prefix, error) {
+	key, ranges := "spec.loadBalancerSourceRanges", service.Spec.LoadBalancerSourceRanges
+	if len(ranges) == 0 {
+		if v := strings.TrimSpace(service.Annotations[AnnSourceRanges]); v != "" {
+			key, ranges = AnnSourceRanges, strings.Split(v, ",")
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+
+	prefixes, err := addresses.ParsePrefixes(ranges)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return prefixes, nil
+}
+
+// The session affinity timeout if a ClientIP Service does not set one, like in kube-proxy.
+const DefaultSessionAffinityTimeout = 3 * time.Hour
+
+// Returns true and the timeout if spec.sessionAffinity of the Service is ClientIP.
+func SessionAffinity(service *corev1.Service) (bool, time.Duration) {
+	if service.Spec.SessionAffinity != corev1.ServiceAffinityClientIP {
+		return false, 0
+	}
+
+	config := service.Spec.SessionAffinityConfig
+	if config == nil || config.ClientIP == nil || config.ClientIP.TimeoutSeconds == nil {
+		return true, DefaultSessionAffinityTimeout
+	}
+	return true, time.Duration(*config.ClientIP.TimeoutSeconds) * time.Second
+}
+
+// Returns true if the loadbalancer settings or the port configuration of the Service changed, so the provider
+// must configure the loadbalancer again. Use it in the update handler of the Service informer.
+func ConfigChanged(old, new *corev1.Service) bool {
+	oldConfig, _ := Config(old)
+	newConfig, _ := Config(new)
+	if !reflect.DeepEqual(oldConfig, newConfig) {
+		return true
+	}
+
+	oldPorts, _ := portconfig.Parse(old)
+	newPorts, _ := portconfig.Parse(new)
+	return !reflect.DeepEqual(oldPorts, newPorts)
+}
+
+// Append the error, or the errors of an aggregate.
+func appendErrors(errs []error, err error) []error {
+	if err == nil {
+		return errs
+	}
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		return append(errs, agg.Errors()...)
+	}
+	return append(errs, err)
+}
@@ -0,0 +1,146 @@
+package annotations
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	lbv1alpha1 "github.com/plusserver/k8s-lbutil/apis/lbutil/v1alpha1"
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+// The annotations that have a field in LBConfigSpec.
+var LBConfigKeys = []string{
+	portconfig.AnnNxPorts,
+	portconfig.AnnNxPortProtocols,
+	portconfig.AnnNxBackendProtocols,
+	portconfig.AnnNxProxyProtocol,
+	portconfig.AnnNxProxyProtocolPorts,
+	AnnNxIdleTimeout,
+	AnnNxMaxConnections,
+	AnnSourceRanges,
+	AnnNxHealthCheckPath,
+	AnnNxHealthCheckPort,
+	AnnNxHealthCheckInterval,
+	AnnNxHealthCheckTimeout,
+	AnnNxHealthCheckHealthyThreshold,
+	AnnNxHealthCheckUnhealthyThreshold,
+	lbutil.AnnNxBackendWeights,
+}
+
+// Returns the LBConfig settings for the annotations, the inverse of LBConfigToAnnotations. Other annotations
+// are ignored. All problems are returned at once.
+func AnnotationsToLBConfig(annotations map[string]string) (lbv1alpha1.LBConfigSpec, error) {
+	var spec lbv1alpha1.LBConfigSpec
+	var errs []error
+
+	list := func(key string) []string {
+		var l []string
+		for _, e := range strings.Split(annotations[key], ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				l = append(l, e)
+			}
+		}
+		return l
+	}
+	pairs := func(key string) map[string]string {
+		var m map[string]string
+		for _, e := range list(key) {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+				errs = append(errs, fmt.Errorf("%s: invalid entry '%s', expected port=value", key, sanitize.Value(e)))
+				continue
+			}
+			if m == nil {
+				m = map[string]string{}
+			}
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		return m
+	}
+	duration := func(key string) *metav1.Duration {
+		v, ok := annotations[key]
+		if !ok {
+			return nil
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid duration '%s'", key, sanitize.Value(v)))
+			return nil
+		}
+		return &metav1.Duration{Duration: d}
+	}
+	count := func(key string) int32 {
+		v, ok := annotations[key]
+		if !ok {
+			return 0
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+		if err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("%s: invalid count '%s'", key, sanitize.Value(v)))
+			return 0
+		}
+		return int32(n)
+	}
+
+	spec.Ports = list(portconfig.AnnNxPorts)
+	spec.PortProtocols = pairs(portconfig.AnnNxPortProtocols)
+	spec.BackendProtocols = pairs(portconfig.AnnNxBackendProtocols)
+	spec.ProxyProtocol = strings.TrimSpace(annotations[portconfig.AnnNxProxyProtocol])
+	spec.ProxyProtocolPorts = list(portconfig.AnnNxProxyProtocolPorts)
+	spec.IdleTimeout = duration(AnnNxIdleTimeout)
+	spec.MaxConnections = count(AnnNxMaxConnections)
+	spec.SourceRanges = list(AnnSourceRanges)
+
+	hc := lbv1alpha1.LBConfigHealthCheck{
+		Path:               strings.TrimSpace(annotations[AnnNxHealthCheckPath]),
+		Port:               strings.TrimSpace(annotations[AnnNxHealthCheckPort]),
+		Interval:           duration(AnnNxHealthCheckInterval),
+		Timeout:            duration(AnnNxHealthCheckTimeout),
+		HealthyThreshold:   count(AnnNxHealthCheckHealthyThreshold),
+		UnhealthyThreshold: count(AnnNxHealthCheckUnhealthyThreshold),
+	}
+	if hc != (lbv1alpha1.LBConfigHealthCheck{}) {
+		spec.HealthCheck = &hc
+	}
+
+	weights, err := lbutil.BackendWeights(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for node, weight := range weights {
+		if spec.BackendWeights == nil {
+			spec.BackendWeights = map[string]int32{}
+		}
+		spec.BackendWeights[node] = int32(weight)
+	}
+
+	return spec, utilerrors.NewAggregate(errs)
+}
+
+// Returns true if the Service has any of the annotations that have a field in LBConfigSpec.
+func HasLBConfigAnnotations(service *corev1.Service) bool {
+	for _, key := range LBConfigKeys {
+		if _, ok := service.Annotations[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the Service without the annotations that have a field in LBConfigSpec.
+func WithoutLBConfigAnnotations(service *corev1.Service) *corev1.Service {
+	stripped := service.DeepCopy()
+	for _, key := range LBConfigKeys {
+		delete(stripped.Annotations, key)
+	}
+	return stripped
+}
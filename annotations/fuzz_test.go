@@ -0,0 +1,82 @@
+package annotations
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func FuzzParseDuration(f *testing.F) {
+	f.Add("30", int64(time.Second))
+	f.Add("-1", int64(time.Minute))
+	f.Add("9223372036854775807", int64(time.Hour))
+	f.Add("1e3", int64(time.Millisecond))
+
+	f.Fuzz(func(t *testing.T, v string, unit int64) {
+		if unit <= 0 {
+			return
+		}
+
+		var d time.Duration
+		if err := parseDuration(v, time.Duration(unit), &d); err != nil {
+			return
+		}
+		if d < 0 {
+			t.Errorf("parsed '%s' to negative duration %s", v, d)
+		}
+	})
+}
+
+// The keys of all registered and upstream annotations, sorted.
+func fuzzKeys() []string {
+	var keys []string
+	for _, a := range Registered() {
+		keys = append(keys, a.Key)
+	}
+	for key := range upstreamMappers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set any annotation to any value; parsing must never panic.
+func FuzzAnnotations(f *testing.F) {
+	keys := fuzzKeys()
+
+	for i := range keys {
+		f.Add(uint(i), "")
+		f.Add(uint(i), "10.0.0.1")
+		f.Add(uint(i), "http,80=tcp")
+		f.Add(uint(i), "-5")
+	}
+
+	f.Fuzz(func(t *testing.T, index uint, value string) {
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "fuzz",
+				Annotations: map[string]string{keys[index%uint(len(keys))]: value},
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeLoadBalancer,
+				Ports: []corev1.ServicePort{
+					{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+				},
+			},
+		}
+
+		_, _ = Config(service)
+		_, _ = Upstream(service)
+		_ = Validate(service)
+		_, _ = GetRequestedIP(service)
+		_, _ = GetAssignedVIP(service)
+		_, _ = SourceRanges(service)
+		_, _ = SessionAffinity(service)
+		_ = Defaults(service)
+		_ = Deprecations(service)
+	})
+}
@@ -0,0 +1,142 @@
+package annotations
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+)
+
+// Health check annotations. Durations are Go durations like "5s". They override the upstream annotations.
+const (
+	AnnNxHealthCheckPath               = "nexinto.com/health-check-path"
+	AnnNxHealthCheckPort               = "nexinto.com/health-check-port"
+	AnnNxHealthCheckInterval           = "nexinto.com/health-check-interval"
+	AnnNxHealthCheckTimeout            = "nexinto.com/health-check-timeout"
+	AnnNxHealthCheckHealthyThreshold   = "nexinto.com/health-check-healthy-threshold"
+	AnnNxHealthCheckUnhealthyThreshold = "nexinto.com/health-check-unhealthy-threshold"
+)
+
+// The health check settings for the values a Service does not set.
+var DefaultHealthCheck = HealthCheck{
+	Interval:           10 * time.Second,
+	Timeout:            5 * time.Second,
+	HealthyThreshold:   2,
+	UnhealthyThreshold: 3,
+}
+
+// Returns the health check with the zero values replaced by DefaultHealthCheck.
+func (h HealthCheck) WithDefaults() HealthCheck {
+	if h.Interval == 0 {
+		h.Interval = DefaultHealthCheck.Interval
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultHealthCheck.Timeout
+	}
+	if h.HealthyThreshold == 0 {
+		h.HealthyThreshold = DefaultHealthCheck.HealthyThreshold
+	}
+	if h.UnhealthyThreshold == 0 {
+		h.UnhealthyThreshold = DefaultHealthCheck.UnhealthyThreshold
+	}
+	if h.Path != "" && h.Protocol == "" {
+		h.Protocol = "HTTP"
+	}
+	return h
+}
+
+// Apply the health check annotations of the Service to the health check. All problems are returned at once.
+func applyHealthCheck(service *corev1.Service, h *HealthCheck) []error {
+	var errs []error
+
+	if v, ok := service.Annotations[AnnNxHealthCheckPath]; ok {
+		if v = strings.TrimSpace(v); !strings.HasPrefix(v, "/") {
+			errs = append(errs, fmt.Errorf("%s: invalid path '%s', must start with '/'", AnnNxHealthCheckPath, sanitize.Value(v)))
+		} else {
+			h.Path = v
+		}
+	}
+
+	if v, ok := service.Annotations[AnnNxHealthCheckPort]; ok {
+		port, err := healthCheckPort(service, strings.TrimSpace(v))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", AnnNxHealthCheckPort, err))
+		} else {
+			h.Port = port
+		}
+	}
+
+	// In a fixed order, so errors are reported in a stable order.
+	durations := []struct {
+		key string
+		d   *time.Duration
+	}{
+		{AnnNxHealthCheckInterval, &h.Interval},
+		{AnnNxHealthCheckTimeout, &h.Timeout},
+	}
+	for _, e := range durations {
+		if v, ok := service.Annotations[e.key]; ok {
+			parsed, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil || parsed <= 0 {
+				errs = append(errs, fmt.Errorf("%s: invalid duration '%s'", e.key, sanitize.Value(v)))
+				continue
+			}
+			*e.d = parsed
+		}
+	}
+
+	counts := []struct {
+		key   string
+		count *int
+	}{
+		{AnnNxHealthCheckHealthyThreshold, &h.HealthyThreshold},
+		{AnnNxHealthCheckUnhealthyThreshold, &h.UnhealthyThreshold},
+	}
+	for _, e := range counts {
+		if v, ok := service.Annotations[e.key]; ok {
+			if err := parseCount(strings.TrimSpace(v), e.count); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", e.key, err))
+			}
+		}
+	}
+
+	if err := checkHealthCheck(*h); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// Check the settings of the health check against each other, with the defaults applied, as the loadbalancer
+// gets them.
+func checkHealthCheck(h HealthCheck) error {
+	if effective := h.WithDefaults(); effective.Timeout > effective.Interval {
+		return fmt.Errorf("%s: timeout %s is longer than the interval %s", AnnNxHealthCheckTimeout, effective.Timeout, effective.Interval)
+	}
+	return nil
+}
+
+// Parse a health check port: a port number or the name of a Service port, which is resolved to its NodePort,
+// the port the backends listen on.
+func healthCheckPort(service *corev1.Service, v string) (int32, error) {
+	if port, err := strconv.ParseInt(v, 10, 32); err == nil {
+		if port < 1 || port > 65535 {
+			return 0, fmt.Errorf("invalid port '%s'", sanitize.Value(v))
+		}
+		return int32(port), nil
+	}
+
+	for _, p := range service.Spec.Ports {
+		if p.Name == v {
+			if p.NodePort == 0 {
+				return 0, fmt.Errorf("port '%s' has no NodePort", sanitize.Value(v))
+			}
+			return p.NodePort, nil
+		}
+	}
+	return 0, fmt.Errorf("service has no port '%s'", sanitize.Value(v))
+}
@@ -0,0 +1,221 @@
+package annotations
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	lbv1alpha1 "github.com/plusserver/k8s-lbutil/apis/lbutil/v1alpha1"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+const (
+
+	// Set this to the name of an LBConfig in the namespace of the Service to use its settings. Annotations on the
+	// Service override them.
+	AnnNxLBConfig = "nexinto.com/lb-config"
+
+	// Name of the Service informer index that maps LBConfigs (namespace/name) to the Services referencing them.
+	IndexLBConfig = "nexinto.com/lb-config"
+)
+
+// Returns the settings of the LBConfig as the annotations they correspond to.
+func LBConfigToAnnotations(spec *lbv1alpha1.LBConfigSpec) map[string]string {
+	a := map[string]string{}
+
+	setList(a, portconfig.AnnNxPorts, spec.Ports)
+	setPairs(a, portconfig.AnnNxPortProtocols, spec.PortProtocols)
+	setPairs(a, portconfig.AnnNxBackendProtocols, spec.BackendProtocols)
+	if spec.ProxyProtocol != "" {
+		a[portconfig.AnnNxProxyProtocol] = spec.ProxyProtocol
+	}
+	setList(a, portconfig.AnnNxProxyProtocolPorts, spec.ProxyProtocolPorts)
+
+	setDuration(a, AnnNxIdleTimeout, spec.IdleTimeout)
+	setCount(a, AnnNxMaxConnections, spec.MaxConnections)
+	setList(a, AnnSourceRanges, spec.SourceRanges)
+
+	if hc := spec.HealthCheck; hc != nil {
+		if hc.Path != "" {
+			a[AnnNxHealthCheckPath] = hc.Path
+		}
+		if hc.Port != "" {
+			a[AnnNxHealthCheckPort] = hc.Port
+		}
+		setDuration(a, AnnNxHealthCheckInterval, hc.Interval)
+		setDuration(a, AnnNxHealthCheckTimeout, hc.Timeout)
+		setCount(a, AnnNxHealthCheckHealthyThreshold, hc.HealthyThreshold)
+		setCount(a, AnnNxHealthCheckUnhealthyThreshold, hc.UnhealthyThreshold)
+	}
+
+	if len(spec.BackendWeights) > 0 {
+		weights := map[string]string{}
+		for node, weight := range spec.BackendWeights {
+			weights[node] = strconv.Itoa(int(weight))
+		}
+		setPairs(a, lbutil.AnnNxBackendWeights, weights)
+	}
+
+	return a
+}
+
+// Returns a copy of the Service with the settings of the LBConfig added as annotations, unless the Service
+// has the annotation already. The result can be passed to Config, Validate and portconfig.Parse.
+func Merge(service *corev1.Service, config *lbv1alpha1.LBConfig) *corev1.Service {
+	merged := service.DeepCopy()
+	for key, value := range LBConfigToAnnotations(&config.Spec) {
+		if _, ok := merged.Annotations[key]; !ok {
+			set(merged, key, value)
+		}
+	}
+	return merged
+}
+
+// Resolves the LBConfig referenced by Services. The LBConfig CRD (deploy/crds/lbconfig.yaml) must be installed.
+// Services are not reconciled when their LBConfig changes unless the LBConfig informer has LBConfigHandlers.
+type Resolver struct {
+	Dynamic dynamic.Interface
+
+	// Reads the LBConfigs, for example
+	// dynamicinformer.NewDynamicSharedInformerFactory(...).ForResource(lbv1alpha1.LBConfigResource).Lister().
+	// Optional; without it, the LBConfig is read from the apiserver for every Service.
+	Lister cache.GenericLister
+}
+
+// Returns the Service with the settings of its LBConfig merged in (see Merge), or the Service itself if it
+// does not reference one. The Service is not modified.
+func (r *Resolver) Resolve(service *corev1.Service) (*corev1.Service, error) {
+	name := service.Annotations[AnnNxLBConfig]
+	if name == "" {
+		return service, nil
+	}
+
+	u, err := r.get(service.Namespace, name)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("lbconfig '%s/%s' of service '%s/%s' does not exist", service.Namespace, name, service.Namespace, service.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting lbconfig '%s/%s' of service '%s/%s': %w", service.Namespace, name, service.Namespace, service.Name, err)
+	}
+
+	config := &lbv1alpha1.LBConfig{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, config); err != nil {
+		return nil, fmt.Errorf("invalid lbconfig '%s/%s': %w", service.Namespace, name, err)
+	}
+
+	return Merge(service, config), nil
+}
+
+// Returns the LBConfig from the Lister, or from the apiserver if there is none.
+func (r *Resolver) get(namespace, name string) (*unstructured.Unstructured, error) {
+	if r.Lister == nil {
+		return r.Dynamic.Resource(lbv1alpha1.LBConfigResource).Namespace(namespace).Get(name, metav1.GetOptions{})
+	}
+
+	obj, err := r.Lister.ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("expected an Unstructured, got %T", obj)
+	}
+	return u, nil
+}
+
+// Index function for Service informers; indexes Services by the LBConfig they reference.
+func ServiceLBConfigIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, fmt.Errorf("expected a Service, got %T", obj)
+	}
+	name := service.Annotations[AnnNxLBConfig]
+	if name == "" {
+		return nil, nil
+	}
+	return []string{service.Namespace + "/" + name}, nil
+}
+
+// Event handlers for an LBConfig informer that add the Services referencing a changed LBConfig to the queue, so
+// the change is applied. services is the indexer of the Service informer, with the IndexLBConfig index
+// (see ServiceLBConfigIndexFunc). Periodic resyncs of the LBConfig informer add the Services with low priority
+// (see lbutil.EnqueueLowPriority).
+func LBConfigHandlers(services cache.Indexer, queue workqueue.Interface) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}, resync bool) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Errorf("cannot get the key of lbconfig: %s", err.Error())
+			return
+		}
+		objs, err := services.ByIndex(IndexLBConfig, key)
+		if err != nil {
+			log.Errorf("cannot look up the services of lbconfig '%s': %s", key, err.Error())
+			return
+		}
+		for _, o := range objs {
+			if service, ok := o.(*corev1.Service); ok {
+				key := service.Namespace + "/" + service.Name
+				if resync {
+					lbutil.EnqueueLowPriority(queue, key)
+				} else {
+					queue.Add(key)
+				}
+			}
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { enqueue(obj, false) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			o, ok1 := oldObj.(metav1.Object)
+			n, ok2 := newObj.(metav1.Object)
+			enqueue(newObj, ok1 && ok2 && lbutil.IsResync(o, n))
+		},
+		DeleteFunc: func(obj interface{}) { enqueue(obj, false) },
+	}
+}
+
+func setList(a map[string]string, key string, list []string) {
+	if len(list) > 0 {
+		a[key] = strings.Join(list, ",")
+	}
+}
+
+// Set a list of key=value pairs, sorted by key.
+func setPairs(a map[string]string, key string, pairs map[string]string) {
+	if len(pairs) == 0 {
+		return
+	}
+	list := make([]string, 0, len(pairs))
+	for k, v := range pairs {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	a[key] = strings.Join(list, ",")
+}
+
+func setDuration(a map[string]string, key string, d *metav1.Duration) {
+	if d != nil && d.Duration != 0 {
+		a[key] = d.Duration.String()
+	}
+}
+
+func setCount(a map[string]string, key string, n int32) {
+	if n != 0 {
+		a[key] = strconv.Itoa(int(n))
+	}
+}
@@ -0,0 +1,46 @@
+package annotations
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+)
+
+const (
+
+	// Close connections that are idle for this Go duration, like "5m". Overrides the upstream annotations.
+	AnnNxIdleTimeout = "nexinto.com/idle-timeout"
+
+	// The most connections the loadbalancer accepts for the Service at once.
+	AnnNxMaxConnections = "nexinto.com/max-connections"
+)
+
+// Apply the connection annotations of the Service to the config. All problems are returned at once.
+func applyLimits(service *corev1.Service, config *LBConfig) []error {
+	var errs []error
+
+	if v, ok := service.Annotations[AnnNxIdleTimeout]; ok {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration '%s'", AnnNxIdleTimeout, sanitize.Value(v)))
+		} else {
+			config.IdleTimeout = d
+		}
+	}
+
+	if v, ok := service.Annotations[AnnNxMaxConnections]; ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("%s: invalid count '%s'", AnnNxMaxConnections, sanitize.Value(v)))
+		} else {
+			config.MaxConnections = n
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,407 @@
+package annotations
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/addresses"
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+// The type of the value of an annotation. Values are checked against their type before the validator runs.
+type Type string
+
+const (
+
+	// Any value, including "", enables the setting.
+	TypeFlag Type = "flag"
+
+	TypeString   Type = "string"
+	TypeBool     Type = "bool"
+	TypeInt      Type = "int"
+	TypeDuration Type = "duration"
+	TypeAddress  Type = "address"
+
+	// A comma-separated list.
+	TypeList Type = "list"
+)
+
+// A Service annotation understood by lbutil or its providers.
+type Annotation struct {
+	Key  string
+	Type Type
+
+	// Set by Default on Services that don't have the annotation but have another registered one. No default if empty.
+	Default string
+
+	// Checks a value of the right type. The Service is passed for values that refer to it, like port names.
+	// Optional.
+	Validate func(service *corev1.Service, value string) error
+
+	// If not empty, the annotation is deprecated and this explains what to use instead.
+	Deprecated string
+
+	// A short explanation, for documentation.
+	Description string
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Annotation{}
+)
+
+// Add an annotation to the registry, so Validate and Default handle it. Panics if the key is already registered.
+func Register(annotation Annotation) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registry[annotation.Key]; ok {
+		panic(fmt.Sprintf("annotation '%s' is already registered", annotation.Key))
+	}
+	registry[annotation.Key] = annotation
+}
+
+// Returns the registered annotation with the key.
+func Lookup(key string) (Annotation, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	annotation, ok := registry[key]
+	return annotation, ok
+}
+
+// Returns all registered annotations, sorted by key.
+func Registered() []Annotation {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	list := make([]Annotation, 0, len(registry))
+	for _, annotation := range registry {
+		list = append(list, annotation)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	return list
+}
+
+// Check the value of the annotation against its type and validator.
+func (a Annotation) check(service *corev1.Service, value string) error {
+	if value == "" && a.Type != TypeFlag {
+		// Empty values are unset, for example an assigned VIP that was reset.
+		return nil
+	}
+
+	if err := checkType(a.Type, value); err != nil {
+		return fmt.Errorf("%s: %w", a.Key, err)
+	}
+	if a.Validate != nil {
+		if err := a.Validate(service, value); err != nil {
+			return fmt.Errorf("%s: %w", a.Key, err)
+		}
+	}
+	return nil
+}
+
+func checkType(t Type, value string) error {
+	v := strings.TrimSpace(value)
+
+	var err error
+	switch t {
+	case TypeBool:
+		_, err = strconv.ParseBool(v)
+	case TypeInt:
+		_, err = strconv.Atoi(v)
+	case TypeDuration:
+		_, err = time.ParseDuration(v)
+	case TypeAddress:
+		_, err = addresses.Parse(v)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s '%s'", t, sanitize.Value(value))
+	}
+	return nil
+}
+
+// Returns the problems with the registered annotations of the Service, one per annotation, sorted by key.
+func validateRegistered(service *corev1.Service) []error {
+	var errs []error
+	for _, annotation := range Registered() {
+		if value, ok := service.Annotations[annotation.Key]; ok {
+			if err := annotation.check(service, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// Returns the default values of the registered annotations the Service does not have. Services without any
+// registered annotation are not handled by lbutil and get no defaults.
+func Defaults(service *corev1.Service) map[string]string {
+	defaults := map[string]string{}
+	if !hasRegistered(service) {
+		return defaults
+	}
+	for _, annotation := range Registered() {
+		if annotation.Default == "" {
+			continue
+		}
+		if _, ok := service.Annotations[annotation.Key]; !ok {
+			defaults[annotation.Key] = annotation.Default
+		}
+	}
+	return defaults
+}
+
+// Returns true if the Service has a registered annotation.
+func hasRegistered(service *corev1.Service) bool {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	for key := range service.Annotations {
+		if _, ok := registry[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the default values of the registered annotations the Service does not have. Returns true if the Service
+// was modified. Modifies the Service, which must not come from a cache.
+func Default(service *corev1.Service) bool {
+	defaults := Defaults(service)
+	for key, value := range defaults {
+		set(service, key, value)
+	}
+	return len(defaults) > 0
+}
+
+// Returns a warning for every deprecated annotation of the Service, sorted by key.
+func Deprecations(service *corev1.Service) []string {
+	var warnings []string
+	for _, annotation := range Registered() {
+		if _, ok := service.Annotations[annotation.Key]; ok && annotation.Deprecated != "" {
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated: %s", annotation.Key, annotation.Deprecated))
+		}
+	}
+	return warnings
+}
+
+// Validators shared by several annotations.
+
+func positiveDuration(_ *corev1.Service, v string) error {
+	if d, _ := time.ParseDuration(strings.TrimSpace(v)); d <= 0 {
+		return fmt.Errorf("invalid duration '%s'", sanitize.Value(v))
+	}
+	return nil
+}
+
+func positiveCount(_ *corev1.Service, v string) error {
+	var count int
+	return parseCount(strings.TrimSpace(v), &count)
+}
+
+func providerName(_ *corev1.Service, v string) error {
+	for _, msg := range validation.IsQualifiedName(v) {
+		return fmt.Errorf("invalid provider name '%s': %s", sanitize.Value(v), msg)
+	}
+	return nil
+}
+
+func init() {
+	for _, a := range []Annotation{
+		{Key: lbutil.AnnNxReqVIP, Type: TypeFlag, Description: "Request a VIP."},
+		{Key: lbutil.AnnNxNoVIP, Type: TypeFlag, Description: "Never assign a VIP; a VIP the Service already has is kept."},
+		{Key: lbutil.AnnNxPaused, Type: TypeFlag, Description: "Leave the Service alone; the value can explain why."},
+		{Key: lbutil.AnnNxPinVIP, Type: TypeFlag, Description: "Keep the VIP if IPAM changes the address."},
+		{Key: lbutil.AnnNxReassign, Type: TypeFlag, Description: "Release the VIP and request a new one."},
+
+		{Key: lbutil.AnnNxVIPProvider, Type: TypeString, Validate: providerName, Description: "The provider that may claim the Service."},
+		{Key: lbutil.AnnNxVIPActiveProvider, Type: TypeString, Validate: providerName, Description: "The provider that claimed the Service."},
+
+		{Key: lbutil.AnnNxRequestedIP, Type: TypeAddress, Description: "The address to request for the VIP."},
+		{Key: lbutil.AnnNxAssignedVIP, Type: TypeAddress, Description: "The VIP assigned to the Service."},
+		{Key: lbutil.AnnNxAssignedSecondaryVIP, Type: TypeAddress, Description: "The VIP of the second family of a dual-stack Service."},
+
+		{
+			Key:  lbutil.AnnNxVIPPool,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				for _, msg := range validation.IsDNS1123Label(v) {
+					return fmt.Errorf("invalid pool name '%s': %s", sanitize.Value(v), msg)
+				}
+				return nil
+			},
+			Description: "The address pool to allocate the VIP from.",
+		},
+		{
+			Key:     lbutil.AnnNxLBConfigVersion,
+			Type:    TypeInt,
+			Default: strconv.Itoa(CurrentVersion),
+			Validate: func(service *corev1.Service, _ string) error {
+				version, err := GetVersion(service)
+				if err == nil && version > CurrentVersion {
+					return fmt.Errorf("unsupported version %d", version)
+				}
+				if err != nil {
+					return fmt.Errorf("invalid version '%s'", sanitize.Value(service.Annotations[lbutil.AnnNxLBConfigVersion]))
+				}
+				return nil
+			},
+			Description: "The annotation schema version of the Service.",
+		},
+		{
+			Key:  AnnNxLBConfig,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				for _, msg := range validation.IsDNS1123Subdomain(v) {
+					return fmt.Errorf("invalid name '%s': %s", sanitize.Value(v), msg)
+				}
+				return nil
+			},
+			Description: "The LBConfig with the settings of the Service.",
+		},
+		{
+			Key:  lbutil.AnnNxReleasePolicy,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				switch lbutil.ReleasePolicy(v) {
+				case lbutil.ReleasePolicyDelete, lbutil.ReleasePolicyRetain:
+					return nil
+				}
+				return fmt.Errorf("invalid release policy '%s'", sanitize.Value(v))
+			},
+			Description: "What happens to the IpAddress when the Service is deleted.",
+		},
+		{
+			Key:  lbutil.AnnNxPublishMode,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				_, err := lbutil.ParsePublishMode(v)
+				return err
+			},
+			Description: "Where the VIP is published besides the assigned-vip annotation.",
+		},
+		{
+			Key:  lbutil.AnnNxIPFamilyPolicy,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				switch lbutil.IPFamilyPolicy(v) {
+				case lbutil.IPFamilyPolicySingleStack, lbutil.IPFamilyPolicyPreferDualStack, lbutil.IPFamilyPolicyRequireDualStack:
+					return nil
+				}
+				return fmt.Errorf("invalid policy '%s'", sanitize.Value(v))
+			},
+			Description: "SingleStack, PreferDualStack or RequireDualStack.",
+		},
+		{
+			Key:  lbutil.AnnNxIPFamilies,
+			Type: TypeList,
+			Validate: func(service *corev1.Service, _ string) error {
+				_, _, err := lbutil.ServiceFamilies(service)
+				if err != nil && !strings.HasPrefix(err.Error(), lbutil.AnnNxIPFamilies+": ") {
+					// A problem of the policy, reported by its validator.
+					return nil
+				}
+				return unwrapKey(err, lbutil.AnnNxIPFamilies)
+			},
+			Description: "The address families of the Service, the primary one first.",
+		},
+		{
+			Key:  lbutil.AnnNxBackendWeights,
+			Type: TypeList,
+			Validate: func(service *corev1.Service, _ string) error {
+				_, err := lbutil.BackendWeights(service)
+				// The error names the annotation.
+				return unwrapKey(err, lbutil.AnnNxBackendWeights)
+			},
+			Description: "Weights of the backends, as node=weight pairs.",
+		},
+
+		// The port annotations are checked together by portconfig.Parse (see Validate).
+		{Key: portconfig.AnnNxPorts, Type: TypeList, Description: "The Service ports to expose."},
+		{Key: portconfig.AnnNxPortProtocols, Type: TypeList, Description: "Loadbalancer protocols, as port=protocol pairs."},
+		{Key: portconfig.AnnNxBackendProtocols, Type: TypeList, Description: "Backend protocols, as port=protocol pairs."},
+		{Key: portconfig.AnnNxProxyProtocolPorts, Type: TypeList, Description: "The ports that send the proxy protocol."},
+		{
+			Key:  portconfig.AnnNxProxyProtocol,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				_, err := portconfig.ParseProxyProtocol(v)
+				return err
+			},
+			Description: "The proxy protocol version, v1 or v2, or none.",
+		},
+
+		{
+			Key:  AnnNxHealthCheckPath,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				if !strings.HasPrefix(strings.TrimSpace(v), "/") {
+					return fmt.Errorf("invalid path '%s', must start with '/'", sanitize.Value(v))
+				}
+				return nil
+			},
+			Description: "The path of HTTP health checks.",
+		},
+		{
+			Key:  AnnNxHealthCheckPort,
+			Type: TypeString,
+			Validate: func(service *corev1.Service, v string) error {
+				_, err := healthCheckPort(service, strings.TrimSpace(v))
+				return err
+			},
+			Description: "The port of the health check, a number or the name of a Service port.",
+		},
+		{Key: AnnNxHealthCheckInterval, Type: TypeDuration, Validate: positiveDuration, Description: "The time between health checks."},
+		{Key: AnnNxHealthCheckTimeout, Type: TypeDuration, Validate: positiveDuration, Description: "The timeout of a health check."},
+		{Key: AnnNxHealthCheckHealthyThreshold, Type: TypeInt, Validate: positiveCount, Description: "Successful checks before a backend is healthy."},
+		{Key: AnnNxHealthCheckUnhealthyThreshold, Type: TypeInt, Validate: positiveCount, Description: "Failed checks before a backend is unhealthy."},
+
+		{Key: AnnNxIdleTimeout, Type: TypeDuration, Validate: positiveDuration, Description: "Close connections idle this long."},
+		{Key: AnnNxMaxConnections, Type: TypeInt, Validate: positiveCount, Description: "The most concurrent connections."},
+
+		{
+			Key:  AnnSourceRanges,
+			Type: TypeList,
+			Validate: func(_ *corev1.Service, v string) error {
+				_, err := addresses.ParsePrefixes(strings.Split(v, ","))
+				return err
+			},
+			Description: "The networks allowed to connect, if spec.loadBalancerSourceRanges is not set.",
+		},
+	} {
+		Register(a)
+	}
+
+	// The upstream annotations are checked by their mappers.
+	for key, mapper := range upstreamMappers {
+		mapper := mapper
+		Register(Annotation{
+			Key:  key,
+			Type: TypeString,
+			Validate: func(_ *corev1.Service, v string) error {
+				return mapper(strings.TrimSpace(v), &LBConfig{})
+			},
+			Description: "Understood for Services migrated from a cloud provider (see Upstream).",
+		})
+	}
+}
+
+// Remove the "key: " prefix from an error that already names the annotation.
+func unwrapKey(err error, key string) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.TrimPrefix(err.Error(), key+": "))
+}
@@ -0,0 +1,223 @@
+package annotations
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+// Loadbalancer settings that are not specific to a port.
+type LBConfig struct {
+
+	// Only reachable from the internal network.
+	Internal bool
+
+	HealthCheck HealthCheck
+
+	// Close idle connections after this duration; zero for the loadbalancer default.
+	IdleTimeout time.Duration
+
+	// The most concurrent connections; zero for no limit.
+	MaxConnections int
+
+	// Send the PROXY protocol header to all backends. The proxy-protocol annotation takes precedence over upstream
+	// annotations if it is set: "none" disables it, a version enables it.
+	ProxyProtocol bool
+
+	// Only these networks may connect to the loadbalancer; all if empty (see SourceRanges).
+	SourceRanges []netip.Prefix
+
+	// Send the connections of a client to the same backend for SessionAffinityTimeout (see SessionAffinity).
+	SessionAffinity        bool
+	SessionAffinityTimeout time.Duration
+}
+
+// Health check settings. Zero values mean the loadbalancer default (see WithDefaults).
+type HealthCheck struct {
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+
+	// Path for HTTP health checks.
+	Path string
+
+	// Port and protocol of the health check, if different from the traffic port.
+	Port     int32
+	Protocol string
+}
+
+// Load balancer annotations of cloud providers that are understood by Upstream.
+const (
+	AnnAWSInternal           = "service.beta.kubernetes.io/aws-load-balancer-internal"
+	AnnAWSIdleTimeout        = "service.beta.kubernetes.io/aws-load-balancer-connection-idle-timeout"
+	AnnAWSProxyProtocol      = "service.beta.kubernetes.io/aws-load-balancer-proxy-protocol"
+	AnnAWSHCInterval         = "service.beta.kubernetes.io/aws-load-balancer-healthcheck-interval"
+	AnnAWSHCTimeout          = "service.beta.kubernetes.io/aws-load-balancer-healthcheck-timeout"
+	AnnAWSHCHealthyThreshold = "service.beta.kubernetes.io/aws-load-balancer-healthcheck-healthy-threshold"
+	AnnAWSHCUnhealthy        = "service.beta.kubernetes.io/aws-load-balancer-healthcheck-unhealthy-threshold"
+	AnnAWSHCPath             = "service.beta.kubernetes.io/aws-load-balancer-healthcheck-path"
+	AnnAWSHCPort             = "service.beta.kubernetes.io/aws-load-balancer-healthcheck-port"
+	AnnAWSHCProtocol         = "service.beta.kubernetes.io/aws-load-balancer-healthcheck-protocol"
+
+	AnnAzureInternal       = "service.beta.kubernetes.io/azure-load-balancer-internal"
+	AnnAzureIdleTimeout    = "service.beta.kubernetes.io/azure-load-balancer-tcp-idle-timeout"
+	AnnAzureHCPath         = "service.beta.kubernetes.io/azure-load-balancer-health-probe-request-path"
+	AnnAzureHCInterval     = "service.beta.kubernetes.io/azure-load-balancer-health-probe-interval"
+	AnnAzureHCProbeCount   = "service.beta.kubernetes.io/azure-load-balancer-health-probe-num-of-probe"
+	AnnOpenStackInternal   = "service.beta.kubernetes.io/openstack-internal-load-balancer"
+	AnnGCPLoadBalancerType = "cloud.google.com/load-balancer-type"
+)
+
+// Maps one upstream annotation into the config.
+type upstreamMapper func(value string, config *LBConfig) error
+
+var upstreamMappers = map[string]upstreamMapper{
+	AnnAWSInternal: func(v string, c *LBConfig) error {
+		// Older AWS versions used a CIDR like 0.0.0.0/0 instead of a boolean.
+		c.Internal = v == "0.0.0.0/0" || isTrue(v)
+		return nil
+	},
+	AnnAWSIdleTimeout: func(v string, c *LBConfig) error {
+		return parseDuration(v, time.Second, &c.IdleTimeout)
+	},
+	AnnAWSProxyProtocol: func(v string, c *LBConfig) error {
+		c.ProxyProtocol = v == "*"
+		return nil
+	},
+	AnnAWSHCInterval: func(v string, c *LBConfig) error {
+		return parseDuration(v, time.Second, &c.HealthCheck.Interval)
+	},
+	AnnAWSHCTimeout: func(v string, c *LBConfig) error {
+		return parseDuration(v, time.Second, &c.HealthCheck.Timeout)
+	},
+	AnnAWSHCHealthyThreshold: func(v string, c *LBConfig) error {
+		return parseCount(v, &c.HealthCheck.HealthyThreshold)
+	},
+	AnnAWSHCUnhealthy: func(v string, c *LBConfig) error {
+		return parseCount(v, &c.HealthCheck.UnhealthyThreshold)
+	},
+	AnnAWSHCPath: func(v string, c *LBConfig) error {
+		c.HealthCheck.Path = v
+		return nil
+	},
+	AnnAWSHCPort: func(v string, c *LBConfig) error {
+		// "traffic-port" is the default.
+		if v == "traffic-port" {
+			return nil
+		}
+		port, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port '%s'", sanitize.Value(v))
+		}
+		c.HealthCheck.Port = int32(port)
+		return nil
+	},
+	AnnAWSHCProtocol: func(v string, c *LBConfig) error {
+		c.HealthCheck.Protocol = strings.ToUpper(v)
+		return nil
+	},
+	AnnAzureInternal: func(v string, c *LBConfig) error {
+		c.Internal = isTrue(v)
+		return nil
+	},
+	AnnAzureIdleTimeout: func(v string, c *LBConfig) error {
+		return parseDuration(v, time.Minute, &c.IdleTimeout)
+	},
+	AnnAzureHCPath: func(v string, c *LBConfig) error {
+		c.HealthCheck.Path = v
+		return nil
+	},
+	AnnAzureHCInterval: func(v string, c *LBConfig) error {
+		return parseDuration(v, time.Second, &c.HealthCheck.Interval)
+	},
+	AnnAzureHCProbeCount: func(v string, c *LBConfig) error {
+		return parseCount(v, &c.HealthCheck.UnhealthyThreshold)
+	},
+	AnnOpenStackInternal: func(v string, c *LBConfig) error {
+		c.Internal = isTrue(v)
+		return nil
+	},
+	AnnGCPLoadBalancerType: func(v string, c *LBConfig) error {
+		c.Internal = strings.EqualFold(v, "internal")
+		return nil
+	},
+}
+
+// Returns the config from the upstream (cloud provider) load balancer annotations of the Service, so Services
+// migrated from a cloud provider work without new annotations. Unparseable annotations are reported together
+// and left at their defaults.
+func Upstream(service *corev1.Service) (LBConfig, error) {
+	var config LBConfig
+	var errs []error
+
+	// Sorted, so errors are reported in a stable order.
+	keys := make([]string, 0, len(upstreamMappers))
+	for key := range upstreamMappers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v, ok := service.Annotations[key]
+		if !ok {
+			continue
+		}
+		if err := upstreamMappers[key](strings.TrimSpace(v), &config); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	if portconfig.ProxyProtocolDisabled(service) {
+		config.ProxyProtocol = false
+	} else if version, err := portconfig.ParseProxyProtocol(service.Annotations[portconfig.AnnNxProxyProtocol]); err == nil && version != 0 {
+		config.ProxyProtocol = true
+	}
+
+	return config, utilerrors.NewAggregate(errs)
+}
+
+// Returns true if the Service has any upstream load balancer annotation.
+func HasUpstream(service *corev1.Service) bool {
+	for key := range upstreamMappers {
+		if _, ok := service.Annotations[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrue(v string) bool {
+	b, err := strconv.ParseBool(v)
+	return err == nil && b
+}
+
+// Parse a number of units into a duration.
+func parseDuration(v string, unit time.Duration, d *time.Duration) error {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/int64(unit) {
+		return fmt.Errorf("invalid duration '%s'", sanitize.Value(v))
+	}
+	*d = time.Duration(n) * unit
+	return nil
+}
+
+func parseCount(v string, count *int) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid count '%s'", sanitize.Value(v))
+	}
+	*count = n
+	return nil
+}
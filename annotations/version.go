@@ -0,0 +1,31 @@
+package annotations
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// The annotation schema version written by this version of the library.
+// Services without a version annotation use version 0, the schema from before versioning was introduced.
+const CurrentVersion = lbutil.AnnotationVersion
+
+// Converts the annotations of a Service from one schema version to the next. The map may be modified in place.
+type Conversion = lbutil.AnnotationConversion
+
+// Register the conversion from version "from" to from+1. Only used when CurrentVersion is raised.
+func RegisterConversion(from int, conversion Conversion) {
+	lbutil.RegisterAnnotationConversion(from, conversion)
+}
+
+// Returns the annotation schema version of the Service.
+func GetVersion(service *corev1.Service) (int, error) {
+	return lbutil.AnnotationVersionOf(service)
+}
+
+// Convert the annotations of the Service to CurrentVersion. If changed is true, the returned copy differs from
+// the Service and should be updated; the Service is only copied if a conversion changes it. Services written by a
+// newer version of the library are rejected rather than misread. Ensure and Config decode Services themselves.
+func Decode(service *corev1.Service) (decoded *corev1.Service, changed bool, err error) {
+	return lbutil.DecodeAnnotations(service)
+}
@@ -0,0 +1,175 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func (in *VIPBinding) DeepCopyInto(out *VIPBinding) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *VIPBinding) DeepCopy() *VIPBinding {
+	if in == nil {
+		return nil
+	}
+	out := new(VIPBinding)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *VIPBinding) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *VIPBindingStatus) DeepCopyInto(out *VIPBindingStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		out.Conditions = make([]Condition, len(in.Conditions))
+		for i := range in.Conditions {
+			in.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+}
+
+func (in *VIPBindingStatus) DeepCopy() *VIPBindingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VIPBindingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *Condition) DeepCopyInto(out *Condition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+func (in *VIPBindingList) DeepCopyInto(out *VIPBindingList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]VIPBinding, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *VIPBindingList) DeepCopy() *VIPBindingList {
+	if in == nil {
+		return nil
+	}
+	out := new(VIPBindingList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *VIPBindingList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *LBConfig) DeepCopyInto(out *LBConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+func (in *LBConfig) DeepCopy() *LBConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(LBConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *LBConfig) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *LBConfigSpec) DeepCopyInto(out *LBConfigSpec) {
+	*out = *in
+	out.Ports = copyStrings(in.Ports)
+	out.PortProtocols = copyStringMap(in.PortProtocols)
+	out.BackendProtocols = copyStringMap(in.BackendProtocols)
+	out.ProxyProtocolPorts = copyStrings(in.ProxyProtocolPorts)
+	if in.IdleTimeout != nil {
+		d := *in.IdleTimeout
+		out.IdleTimeout = &d
+	}
+	out.SourceRanges = copyStrings(in.SourceRanges)
+	if in.HealthCheck != nil {
+		out.HealthCheck = new(LBConfigHealthCheck)
+		in.HealthCheck.DeepCopyInto(out.HealthCheck)
+	}
+	if in.BackendWeights != nil {
+		out.BackendWeights = make(map[string]int32, len(in.BackendWeights))
+		for k, v := range in.BackendWeights {
+			out.BackendWeights[k] = v
+		}
+	}
+}
+
+func (in *LBConfigHealthCheck) DeepCopyInto(out *LBConfigHealthCheck) {
+	*out = *in
+	if in.Interval != nil {
+		d := *in.Interval
+		out.Interval = &d
+	}
+	if in.Timeout != nil {
+		d := *in.Timeout
+		out.Timeout = &d
+	}
+}
+
+func (in *LBConfigList) DeepCopyInto(out *LBConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]LBConfig, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *LBConfigList) DeepCopy() *LBConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(LBConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *LBConfigList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func copyStrings(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	out := make([]string, len(in))
+	copy(out, in)
+	return out
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
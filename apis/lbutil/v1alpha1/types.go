@@ -0,0 +1,193 @@
+// API types of lbutil (group lbutil.nexinto.com).
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var SchemeGroupVersion = schema.GroupVersion{Group: "lbutil.nexinto.com", Version: "v1alpha1"}
+
+var VIPBindingResource = SchemeGroupVersion.WithResource("vipbindings")
+
+var LBConfigResource = SchemeGroupVersion.WithResource("lbconfigs")
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion, &VIPBinding{}, &VIPBindingList{}, &LBConfig{}, &LBConfigList{})
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	return nil
+}
+
+// Condition types of a VIPBinding.
+const (
+
+	// The Service was claimed by a provider.
+	ConditionClaimed = "Claimed"
+
+	// IPAM assigned an address and it is stored on the Service.
+	ConditionAddressAssigned = "AddressAssigned"
+
+	// The provider configured the loadbalancer.
+	ConditionProviderConfigured = "ProviderConfigured"
+
+	// The loadbalancer reports the VIP as healthy.
+	ConditionHealthy = "Healthy"
+)
+
+// Condition types for the progress of the VIP assignment, so it can be awaited, for example with
+// kubectl wait --for=condition=Bound vipbinding/<service>.
+const (
+
+	// An IpAddress was requested from IPAM.
+	ConditionRequested = "Requested"
+
+	// IPAM allocated an address.
+	ConditionAllocated = "Allocated"
+
+	// The address is the VIP of the Service.
+	ConditionBound = "Bound"
+
+	// The VIP was released, for example for another provider.
+	ConditionReleased = "Released"
+)
+
+// The VIP status of a Service. Has the same namespace and name as the Service.
+type VIPBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VIPBindingSpec   `json:"spec"`
+	Status VIPBindingStatus `json:"status,omitempty"`
+}
+
+type VIPBindingSpec struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type VIPBindingStatus struct {
+	VIP      string `json:"vip,omitempty"`
+	Provider string `json:"provider,omitempty"`
+
+	// The state of the Service in the assignment process.
+	State string `json:"state,omitempty"`
+
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+type Condition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+type VIPBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []VIPBinding `json:"items"`
+}
+
+// Set a condition. The transition time is only updated if the status changes.
+func (s *VIPBindingStatus) SetCondition(conditionType string, status corev1.ConditionStatus, reason, message string) {
+	for i := range s.Conditions {
+		c := &s.Conditions[i]
+		if c.Type != conditionType {
+			continue
+		}
+		if c.Status != status {
+			c.LastTransitionTime = metav1.Now()
+		}
+		c.Status = status
+		c.Reason = reason
+		c.Message = message
+		return
+	}
+
+	s.Conditions = append(s.Conditions, Condition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// Returns true if the condition exists and its status is True.
+func (s *VIPBindingStatus) IsConditionTrue(conditionType string) bool {
+	c := s.GetCondition(conditionType)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+// Returns the condition or nil.
+func (s *VIPBindingStatus) GetCondition(conditionType string) *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// Loadbalancer settings shared by Services that reference it with the lb-config annotation, instead of
+// setting many annotations on each Service. Annotations on the Service override the settings.
+type LBConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LBConfigSpec `json:"spec"`
+}
+
+// The fields correspond to the annotations of the same name; zero values are not set.
+type LBConfigSpec struct {
+
+	// Service ports (names or numbers) to expose.
+	Ports []string `json:"ports,omitempty"`
+
+	// Protocols by Service port.
+	PortProtocols    map[string]string `json:"portProtocols,omitempty"`
+	BackendProtocols map[string]string `json:"backendProtocols,omitempty"`
+
+	// The proxy protocol version, v1 or v2, and the ports it is sent for.
+	ProxyProtocol      string   `json:"proxyProtocol,omitempty"`
+	ProxyProtocolPorts []string `json:"proxyProtocolPorts,omitempty"`
+
+	IdleTimeout    *metav1.Duration `json:"idleTimeout,omitempty"`
+	MaxConnections int32            `json:"maxConnections,omitempty"`
+
+	// Networks in CIDR notation allowed to connect.
+	SourceRanges []string `json:"sourceRanges,omitempty"`
+
+	HealthCheck *LBConfigHealthCheck `json:"healthCheck,omitempty"`
+
+	// Weights by node name.
+	BackendWeights map[string]int32 `json:"backendWeights,omitempty"`
+}
+
+type LBConfigHealthCheck struct {
+	Path string `json:"path,omitempty"`
+
+	// A port number or the name of a Service port.
+	Port string `json:"port,omitempty"`
+
+	Interval           *metav1.Duration `json:"interval,omitempty"`
+	Timeout            *metav1.Duration `json:"timeout,omitempty"`
+	HealthyThreshold   int32            `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int32            `json:"unhealthyThreshold,omitempty"`
+}
+
+type LBConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []LBConfig `json:"items"`
+}
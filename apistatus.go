@@ -0,0 +1,50 @@
+package lbutil
+
+import (
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The apierrors functions of the apimachinery version used by this library only recognize API status errors
+// themselves, not errors wrapping them with %w like the errors returned by the library. These functions
+// also look into wrapped errors.
+
+// Returns the API status error that err is or wraps, or err if there is none. The result can be passed to the
+// apierrors functions.
+func APIStatusError(err error) error {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		if e, ok := status.(error); ok {
+			return e
+		}
+	}
+	return err
+}
+
+// Returns the reason of the API status error that err is or wraps, or metav1.StatusReasonUnknown.
+func ReasonForError(err error) metav1.StatusReason {
+	return apierrors.ReasonForError(APIStatusError(err))
+}
+
+// Returns true if err is or wraps a NotFound error.
+func IsNotFound(err error) bool {
+	return apierrors.IsNotFound(APIStatusError(err))
+}
+
+// Returns true if err is or wraps a Conflict error.
+func IsConflict(err error) bool {
+	return apierrors.IsConflict(APIStatusError(err))
+}
+
+// Returns true if err is or wraps an AlreadyExists error.
+func IsAlreadyExists(err error) bool {
+	return apierrors.IsAlreadyExists(APIStatusError(err))
+}
+
+// Returns true if err is or wraps a Forbidden error.
+func IsForbidden(err error) bool {
+	return apierrors.IsForbidden(APIStatusError(err))
+}
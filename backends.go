@@ -0,0 +1,166 @@
+package lbutil
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	corev1 "k8s.io/api/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+)
+
+const (
+
+	// Set this on a Node to weight the traffic it gets from all loadbalancers. Weight 0 drains the node:
+	// it stays configured, but gets no new connections.
+	AnnNxBackendWeight = "nexinto.com/backend-weight"
+
+	// Set this on a Service to weight its backends, as a comma-separated list of node=weight pairs.
+	// Overrides the weight of the nodes.
+	AnnNxBackendWeights = "nexinto.com/backend-weights"
+)
+
+// The weight of a backend that has none set.
+const DefaultBackendWeight = 1
+
+// The highest weight, the limit of most loadbalancers.
+const MaxBackendWeight = 256
+
+// A node that receives loadbalanced traffic on the NodePorts.
+type Backend struct {
+	Node    string
+	Address string
+
+	// Relative share of the traffic. 0 means DefaultBackendWeight, so backends built without a weight get the default.
+	Weight int
+
+	// The backend stays configured, but gets no new connections. Set for a weight of 0 in the annotations.
+	Drain bool
+}
+
+// Returns the weight to configure on the loadbalancer: 0 if the backend is drained, the default if no weight is set.
+func (b Backend) EffectiveWeight() int {
+	switch {
+	case b.Drain:
+		return 0
+	case b.Weight == 0:
+		return DefaultBackendWeight
+	default:
+		return b.Weight
+	}
+}
+
+// Set the weight from an annotation, where 0 drains the backend.
+func (b *Backend) setWeight(weight int) {
+	b.Weight = weight
+	b.Drain = weight == 0
+}
+
+// Returns the ready, schedulable nodes as backends, addressed by their InternalIP, sorted by node name.
+// The backends are weighted by the backend-weight annotation of the nodes; an invalid weight is logged
+// and ignored.
+func Backends(nodeLister corelisterv1.NodeLister) ([]Backend, error) {
+	nodes, err := nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var backends []Backend
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !nodeReady(node) {
+			continue
+		}
+		for _, a := range node.Status.Addresses {
+			if a.Type == corev1.NodeInternalIP {
+				backend := Backend{Node: node.Name, Address: a.Address}
+				backend.setWeight(nodeWeight(node))
+				backends = append(backends, backend)
+				break
+			}
+		}
+	}
+
+	sort.Slice(backends, func(i, j int) bool { return backends[i].Node < backends[j].Node })
+
+	return backends, nil
+}
+
+// Like Backends, with the weights overridden by the backend-weights annotation of the Service.
+func ServiceBackends(nodeLister corelisterv1.NodeLister, service *corev1.Service) ([]Backend, error) {
+	backends, err := Backends(nodeLister)
+	if err != nil {
+		return nil, err
+	}
+
+	weights, err := BackendWeights(service)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range backends {
+		if weight, ok := weights[backends[i].Node]; ok {
+			backends[i].setWeight(weight)
+		}
+	}
+
+	return backends, nil
+}
+
+// Returns the backend weights by node name from the backend-weights annotation of the Service.
+func BackendWeights(service *corev1.Service) (map[string]int, error) {
+	weights := map[string]int{}
+
+	for _, e := range strings.Split(service.Annotations[AnnNxBackendWeights], ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%s: invalid entry '%s', expected node=weight", AnnNxBackendWeights, sanitize.Value(e))
+		}
+		weight, err := parseWeight(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", AnnNxBackendWeights, err)
+		}
+		weights[strings.TrimSpace(kv[0])] = weight
+	}
+
+	return weights, nil
+}
+
+func nodeWeight(node *corev1.Node) int {
+	v, ok := node.Annotations[AnnNxBackendWeight]
+	if !ok {
+		return DefaultBackendWeight
+	}
+	weight, err := parseWeight(v)
+	if err != nil {
+		log.WithField("node", node.Name).Warnf("%s: %s", AnnNxBackendWeight, err.Error())
+		return DefaultBackendWeight
+	}
+	return weight
+}
+
+func parseWeight(v string) (int, error) {
+	weight, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || weight < 0 || weight > MaxBackendWeight {
+		return 0, fmt.Errorf("invalid weight '%s', expected 0 to %d", sanitize.Value(v), MaxBackendWeight)
+	}
+	return weight, nil
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package lbutil
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// How long RunWorkers waits before processing a failed key again, and how many keys it requeues per second
+// overall. Errors back off longer the more often the key failed, times the multiplier of their class.
+type BackoffPolicy struct {
+
+	// The delay after the first failure, doubled for every further failure.
+	BaseDelay time.Duration
+
+	// The longest delay for a key.
+	MaxDelay time.Duration
+
+	// The number of requeues per second for all keys, and how many can happen at once.
+	QPS   float64
+	Burst int
+
+	// Multiplies the delay for errors of a class (see Classify). Classes that are not listed use 1.
+	Multipliers map[ErrorClass]float64
+}
+
+// The defaults of the client-go controller rate limiter, with IPAM outages backing off harder than conflicts.
+var DefaultBackoffPolicy = BackoffPolicy{
+	BaseDelay: 5 * time.Millisecond,
+	MaxDelay:  1000 * time.Second,
+	QPS:       10,
+	Burst:     100,
+	Multipliers: map[ErrorClass]float64{
+		ErrorClassConflict:  1,
+		ErrorClassThrottled: 2,
+		ErrorClassIPAM:      8,
+	},
+}
+
+// Returns a copy of the policy with unset fields taken from DefaultBackoffPolicy.
+func (p BackoffPolicy) WithDefaults() BackoffPolicy {
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultBackoffPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultBackoffPolicy.MaxDelay
+	}
+	if p.QPS <= 0 {
+		p.QPS = DefaultBackoffPolicy.QPS
+	}
+	if p.Burst <= 0 {
+		p.Burst = DefaultBackoffPolicy.Burst
+	}
+	if p.Multipliers == nil {
+		p.Multipliers = DefaultBackoffPolicy.Multipliers
+	}
+	return p
+}
+
+// Check that the delays are consistent and the multipliers are positive.
+func (p BackoffPolicy) Validate() error {
+	if p.MaxDelay < p.BaseDelay {
+		return fmt.Errorf("max delay %s is less than base delay %s", p.MaxDelay, p.BaseDelay)
+	}
+	for class, m := range p.Multipliers {
+		if m <= 0 {
+			return fmt.Errorf("multiplier for error class '%s' must be positive", class)
+		}
+	}
+	return nil
+}
+
+// Add flags for the delays and the rate to the flag set. Multipliers can only be set in code.
+func (p *BackoffPolicy) AddFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&p.BaseDelay, "lbutil-requeue-base-delay", p.BaseDelay, "Delay before retrying a failed service the first time")
+	fs.DurationVar(&p.MaxDelay, "lbutil-requeue-max-delay", p.MaxDelay, "Longest delay before retrying a failed service")
+	fs.Float64Var(&p.QPS, "lbutil-requeue-qps", p.QPS, "Retries per second for all services")
+	fs.IntVar(&p.Burst, "lbutil-requeue-burst", p.Burst, "Retries that may happen at once")
+}
+
+// A workqueue.RateLimiter for a BackoffPolicy. Create the queue with it and set it as Clients.Backoff,
+// so RunWorkers applies the multiplier of the error class:
+//
+//	limiter := lbutil.NewBackoffRateLimiter(policy)
+//	queue := lbutil.NewPriorityQueue(limiter)
+//	clients.Backoff = limiter
+type BackoffRateLimiter struct {
+	policy BackoffPolicy
+	bucket *rate.Limiter
+
+	lock     sync.Mutex
+	failures map[interface{}]int
+}
+
+// Create a BackoffRateLimiter. Unset fields of the policy are taken from DefaultBackoffPolicy.
+func NewBackoffRateLimiter(policy BackoffPolicy) *BackoffRateLimiter {
+	policy = policy.WithDefaults()
+	return &BackoffRateLimiter{
+		policy:   policy,
+		bucket:   rate.NewLimiter(rate.Limit(policy.QPS), policy.Burst),
+		failures: map[interface{}]int{},
+	}
+}
+
+// Returns the delay for the item, counting a failure without an error class.
+func (r *BackoffRateLimiter) When(item interface{}) time.Duration {
+	return r.WhenClass(item, ErrorClassOther)
+}
+
+// Returns the delay for the item after an error of the class, and counts the failure.
+func (r *BackoffRateLimiter) WhenClass(item interface{}, class ErrorClass) time.Duration {
+	r.lock.Lock()
+	n := r.failures[item]
+	r.failures[item] = n + 1
+	r.lock.Unlock()
+
+	delay := float64(r.policy.BaseDelay)
+	for i := 0; i < n && delay < float64(r.policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if m, ok := r.policy.Multipliers[class]; ok {
+		delay *= m
+	}
+	if delay > float64(r.policy.MaxDelay) {
+		delay = float64(r.policy.MaxDelay)
+	}
+
+	if wait := r.bucket.Reserve().Delay(); wait > time.Duration(delay) {
+		return wait
+	}
+	return time.Duration(delay)
+}
+
+// Reset the failures of the item.
+func (r *BackoffRateLimiter) Forget(item interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.failures, item)
+}
+
+func (r *BackoffRateLimiter) NumRequeues(item interface{}) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.failures[item]
+}
+
+var _ workqueue.RateLimiter = &BackoffRateLimiter{}
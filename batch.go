@@ -0,0 +1,135 @@
+package lbutil
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// The number of concurrent API requests made by EnsureVIPBatch.
+var BatchConcurrency = 16
+
+// Like EnsureVIP for many Services, for example during the initial sync of a controller. Unclaimed Services are
+// claimed and get their IpAddress requested in the same pass, the IpAddress objects are created and the Services
+// updated concurrently (see BatchConcurrency). Unlike EnsureVIP, the Services are updated by EnsureVIPBatch
+// (see UpdateResult); NeedsUpdate and NeedsStatusUpdate are false in the results and their Service is the
+// updated one.
+// Returns a result and an error (or nil) for each Service.
+func (c *Clients) EnsureVIPBatch(services []*corev1.Service, controllerName string, requireAnnotation bool) ([]*Result, []error) {
+	results := make([]*Result, len(services))
+	errs := make([]error, len(services))
+
+	creator := &deferredAddressCreator{addresses: map[int]*ipamv1.IpAddress{}, creator: c.AddressCreator}
+	deferred := *c
+	deferred.AddressCreator = creator
+
+	// Evaluate all Services; address requests are only collected.
+	for i, service := range services {
+		creator.current = i
+		results[i], errs[i] = deferred.EnsureVIP(service, controllerName, requireAnnotation)
+		if errs[i] != nil || results[i].State != StateClaimed || results[i].Service == nil {
+			continue
+		}
+
+		// Just claimed; request the address right away instead of waiting for the next reconcile.
+		claimed := results[i]
+		next, err := deferred.EnsureVIP(claimed.Service, controllerName, requireAnnotation)
+		if next.Service == nil {
+			next.Service = claimed.Service
+		}
+		next.NeedsUpdate = next.NeedsUpdate || claimed.NeedsUpdate
+		next.Actions = append(claimed.Actions, next.Actions...)
+		results[i], errs[i] = next, err
+	}
+
+	// Create the requested addresses.
+	indexes := make([]int, 0, len(creator.addresses))
+	for i := range creator.addresses {
+		indexes = append(indexes, i)
+	}
+	workqueue.ParallelizeUntil(context.TODO(), BatchConcurrency, len(indexes), func(piece int) {
+		i := indexes[piece]
+		service := services[i]
+
+		_, err := c.AddressCreator.CreateIpAddress(creator.addresses[i])
+		if err == nil {
+			c.serviceLogger(service).Info("created ip address request")
+			return
+		}
+
+		result := results[i]
+		result.State = StateClaimed
+
+		if errors.IsAlreadyExists(err) {
+			// Like EnsureVIP, wait for the previous address to be deleted.
+			result.Reason = ErrAddressReleasing.Error()
+			result.RequeueAfter = requeueDelay(nil, time.Now())
+			if result.Service != nil && result.NeedsUpdate {
+				SetVIPState(result.Service, StateClaimed, result.Reason, time.Now())
+			}
+			c.scheduleRequeue(service, result)
+			return
+		}
+
+		err = fmt.Errorf("failed to create ip address request for service '%s/%s': %w", service.Namespace, service.Name, err)
+		errs[i] = err
+
+		result.Reason = err.Error()
+		if result.Service != nil && result.NeedsUpdate {
+			SetVIPState(result.Service, StateClaimed, err.Error(), time.Now())
+		}
+	})
+
+	// Update the modified Services.
+	workqueue.ParallelizeUntil(context.TODO(), BatchConcurrency, len(services), func(i int) {
+		result := results[i]
+		if result == nil || !result.NeedsUpdate && !result.NeedsStatusUpdate || result.Service == nil {
+			return
+		}
+
+		if _, err := c.UpdateResult(services[i], result); err != nil {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+			result.Reason = err.Error()
+			return
+		}
+
+		if c.Bindings != nil {
+			if err := c.Bindings.Report(services[i], result); err != nil {
+				c.serviceLogger(services[i]).Warnf("error reporting status: %s", err.Error())
+			}
+		}
+	})
+
+	return results, errs
+}
+
+// Collects the addresses requested for the Service with the current index instead of creating them.
+// Deletions are passed to the AddressCreator of the Clients right away.
+type deferredAddressCreator struct {
+	current   int
+	addresses map[int]*ipamv1.IpAddress
+	creator   AddressCreator
+}
+
+func (d *deferredAddressCreator) CreateIpAddress(address *ipamv1.IpAddress) (*ipamv1.IpAddress, error) {
+	d.addresses[d.current] = address
+	return address, nil
+}
+
+func (d *deferredAddressCreator) DeleteIpAddress(namespace, name string, uid types.UID) error {
+	deleter, ok := d.creator.(AddressDeleter)
+	if !ok {
+		return fmt.Errorf("cannot delete ip address '%s/%s': addresses cannot be deleted", namespace, name)
+	}
+	return deleter.DeleteIpAddress(namespace, name, uid)
+}
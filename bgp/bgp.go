@@ -0,0 +1,231 @@
+// Announces VIPs as host routes to BGP peers using an embedded gobgp server.
+
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	log "github.com/sirupsen/logrus"
+
+	api "github.com/osrg/gobgp/api"
+	gobgp "github.com/osrg/gobgp/pkg/server"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+// A BGP neighbor that receives the VIP routes.
+type Peer struct {
+	Address string
+	AS      uint32
+}
+
+// Configuration of the Announcer.
+type Config struct {
+
+	// The local AS number and router ID.
+	AS       uint32
+	RouterID string
+
+	// The port to listen on for incoming BGP connections; -1 disables listening.
+	ListenPort int32
+
+	Peers []Peer
+
+	// The next hop announced for IPv4 and IPv6 VIPs, usually an address of this node.
+	NextHop   string
+	NextHopV6 string
+}
+
+// Announces VIPs as /32 (IPv4) or /128 (IPv6) routes.
+type Announcer struct {
+	config Config
+	server *gobgp.BgpServer
+
+	lock      sync.Mutex
+	announced map[string]*api.Path
+}
+
+// Create an Announcer. Call Start before announcing VIPs.
+func NewAnnouncer(config Config) *Announcer {
+	return &Announcer{
+		config:    config,
+		server:    gobgp.NewBgpServer(),
+		announced: map[string]*api.Path{},
+	}
+}
+
+// Start the BGP server and connect to the peers.
+func (a *Announcer) Start(ctx context.Context) error {
+	go a.server.Serve()
+
+	err := a.server.StartBgp(ctx, &api.StartBgpRequest{
+		Global: &api.Global{
+			As:         a.config.AS,
+			RouterId:   a.config.RouterID,
+			ListenPort: a.config.ListenPort,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error starting bgp server: %w", err)
+	}
+
+	for _, peer := range a.config.Peers {
+		err := a.server.AddPeer(ctx, &api.AddPeerRequest{
+			Peer: &api.Peer{
+				Conf: &api.PeerConf{
+					NeighborAddress: peer.Address,
+					PeerAs:          peer.AS,
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error adding bgp peer %s: %w", peer.Address, err)
+		}
+		log.WithField("peer", peer.Address).Info("added bgp peer")
+	}
+
+	return nil
+}
+
+// Stop the BGP server. All routes are withdrawn.
+func (a *Announcer) Stop(ctx context.Context) error {
+	a.lock.Lock()
+	a.announced = map[string]*api.Path{}
+	a.lock.Unlock()
+
+	return a.server.StopBgp(ctx, &api.StopBgpRequest{})
+}
+
+// Announce a host route for the VIP. Announcing a VIP twice is a no-op.
+func (a *Announcer) Announce(ctx context.Context, vip string) error {
+	addr, err := addresses.Parse(vip)
+	if err != nil {
+		return err
+	}
+	vip = addr.String()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, ok := a.announced[vip]; ok {
+		return nil
+	}
+
+	path, err := a.hostRoute(addr)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.server.AddPath(ctx, &api.AddPathRequest{Path: path}); err != nil {
+		return fmt.Errorf("error announcing %s: %w", vip, err)
+	}
+	a.announced[vip] = path
+
+	log.WithField("vip", vip).Info("announced vip")
+
+	return nil
+}
+
+// Withdraw the route for the VIP. Withdrawing a VIP that is not announced is a no-op.
+func (a *Announcer) Withdraw(ctx context.Context, vip string) error {
+	if canonical, err := addresses.Canonical(vip); err == nil {
+		vip = canonical
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	path, ok := a.announced[vip]
+	if !ok {
+		return nil
+	}
+
+	if err := a.server.DeletePath(ctx, &api.DeletePathRequest{Family: path.Family, Path: path}); err != nil {
+		return fmt.Errorf("error withdrawing %s: %w", vip, err)
+	}
+	delete(a.announced, vip)
+
+	log.WithField("vip", vip).Info("withdrew vip")
+
+	return nil
+}
+
+// Announce exactly the given VIPs: new ones are announced, routes for VIPs not in the list are withdrawn.
+// Call this with the assigned VIPs of all Services after each reconcile or periodically.
+func (a *Announcer) Sync(ctx context.Context, vips []string) error {
+	want := map[string]bool{}
+	for _, vip := range vips {
+		want[vip] = true
+		if err := a.Announce(ctx, vip); err != nil {
+			return err
+		}
+	}
+
+	for _, vip := range a.Announced() {
+		if !want[vip] {
+			if err := a.Withdraw(ctx, vip); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the announced VIPs, sorted.
+func (a *Announcer) Announced() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	vips := make([]string, 0, len(a.announced))
+	for vip := range a.announced {
+		vips = append(vips, vip)
+	}
+	sort.Strings(vips)
+
+	return vips
+}
+
+// Build the path for a host route to the VIP.
+func (a *Announcer) hostRoute(vip netip.Addr) (*api.Path, error) {
+	origin, _ := ptypes.MarshalAny(&api.OriginAttribute{Origin: 0})
+
+	if vip.Is4() {
+		if a.config.NextHop == "" {
+			return nil, fmt.Errorf("cannot announce %s: no IPv4 next hop configured", vip)
+		}
+
+		nlri, _ := ptypes.MarshalAny(&api.IPAddressPrefix{Prefix: vip.String(), PrefixLen: 32})
+		nextHop, _ := ptypes.MarshalAny(&api.NextHopAttribute{NextHop: a.config.NextHop})
+
+		return &api.Path{
+			Family: &api.Family{Afi: api.Family_AFI_IP, Safi: api.Family_SAFI_UNICAST},
+			Nlri:   nlri,
+			Pattrs: []*any.Any{origin, nextHop},
+		}, nil
+	}
+
+	if a.config.NextHopV6 == "" {
+		return nil, fmt.Errorf("cannot announce %s: no IPv6 next hop configured", vip)
+	}
+
+	family := &api.Family{Afi: api.Family_AFI_IP6, Safi: api.Family_SAFI_UNICAST}
+	nlri, _ := ptypes.MarshalAny(&api.IPAddressPrefix{Prefix: vip.String(), PrefixLen: 128})
+	mpReach, _ := ptypes.MarshalAny(&api.MpReachNLRIAttribute{
+		Family:   family,
+		NextHops: []string{a.config.NextHopV6},
+		Nlris:    []*any.Any{nlri},
+	})
+
+	return &api.Path{
+		Family: family,
+		Nlri:   nlri,
+		Pattrs: []*any.Any{origin, mpReach},
+	}, nil
+}
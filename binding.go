@@ -0,0 +1,224 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbv1alpha1 "github.com/plusserver/k8s-lbutil/apis/lbutil/v1alpha1"
+)
+
+// Maintains a VIPBinding object per Service, so users can see the state of the Service with kubectl describe.
+// The VIPBinding CRD (deploy/crds/vipbinding.yaml) must be installed.
+type BindingReporter struct {
+	Dynamic dynamic.Interface
+
+	// Reads the VIPBindings, for example
+	// dynamicinformer.NewDynamicSharedInformerFactory(...).ForResource(lbv1alpha1.VIPBindingResource).Lister().
+	// Optional; without it, the VIPBinding is read from the apiserver on every report.
+	Lister cache.GenericLister
+}
+
+// Update the VIPBinding of the Service from the result of EnsureVIP. Skipped Services get no VIPBinding.
+// EnsureVIP reports the result itself if the Service does not need an update; otherwise the update triggers
+// another reconcile, which reports the stored state.
+func (b *BindingReporter) Report(service *corev1.Service, result *Result) error {
+	if result.State == StateSkipped {
+		return nil
+	}
+
+	return b.update(service, func(status *lbv1alpha1.VIPBindingStatus) {
+		status.State = string(result.State)
+		if result.Service != nil {
+			status.Provider = result.Service.Annotations[AnnNxVIPActiveProvider]
+		}
+		if result.Ready() {
+			status.VIP = result.VIP
+		}
+
+		claimed := corev1.ConditionTrue
+		if result.State == StateUnclaimed || result.State == StateReleased {
+			claimed = corev1.ConditionFalse
+		}
+		status.SetCondition(lbv1alpha1.ConditionClaimed, claimed, string(result.State), "")
+
+		assigned := corev1.ConditionFalse
+		switch result.State {
+		case StateAssigned, StateReady, StateConflict:
+			assigned = corev1.ConditionTrue
+		}
+		status.SetCondition(lbv1alpha1.ConditionAddressAssigned, assigned, string(result.State), result.Reason)
+
+		conditions := PipelineConditions(result.State)
+		if conditions == nil {
+			return
+		}
+		for _, conditionType := range pipelineConditionTypes {
+			s := corev1.ConditionFalse
+			if conditions[conditionType] {
+				s = corev1.ConditionTrue
+			}
+			status.SetCondition(conditionType, s, string(result.State), "")
+		}
+	})
+}
+
+// In the order they are added to new VIPBindings.
+var pipelineConditionTypes = []string{
+	lbv1alpha1.ConditionRequested,
+	lbv1alpha1.ConditionAllocated,
+	lbv1alpha1.ConditionBound,
+	lbv1alpha1.ConditionReleased,
+}
+
+// Returns the assignment pipeline conditions (Requested, Allocated, Bound and Released) for the state of a Service.
+// Returns nil for states that do not change the assignment (Skipped, Claimed and Paused); the conditions
+// reported before stay as they are.
+func PipelineConditions(state State) map[string]bool {
+	switch state {
+	case StateSkipped, StateClaimed, StatePaused:
+		return nil
+	}
+
+	conditions := map[string]bool{}
+	for _, conditionType := range pipelineConditionTypes {
+		conditions[conditionType] = false
+	}
+
+	switch state {
+	case StateRequested, StateReassigning:
+		conditions[lbv1alpha1.ConditionRequested] = true
+	case StateAssigned, StateDrifted, StateConflict:
+		conditions[lbv1alpha1.ConditionRequested] = true
+		conditions[lbv1alpha1.ConditionAllocated] = true
+	case StateReady, StateMigrating:
+		conditions[lbv1alpha1.ConditionRequested] = true
+		conditions[lbv1alpha1.ConditionAllocated] = true
+		conditions[lbv1alpha1.ConditionBound] = true
+	case StateReleased, StateUnrequested:
+		conditions[lbv1alpha1.ConditionReleased] = true
+	}
+
+	return conditions
+}
+
+// Record if the provider configured the loadbalancer for the Service. message should explain failures.
+func (b *BindingReporter) SetProviderConfigured(service *corev1.Service, configured bool, message string) error {
+	return b.setCondition(service, lbv1alpha1.ConditionProviderConfigured, configured, message)
+}
+
+// Record if the loadbalancer reports the VIP of the Service as healthy.
+func (b *BindingReporter) SetHealthy(service *corev1.Service, healthy bool, message string) error {
+	return b.setCondition(service, lbv1alpha1.ConditionHealthy, healthy, message)
+}
+
+func (b *BindingReporter) setCondition(service *corev1.Service, conditionType string, ok bool, message string) error {
+	status, reason := corev1.ConditionFalse, "Failed"
+	if ok {
+		status, reason = corev1.ConditionTrue, "OK"
+	}
+	return b.update(service, func(s *lbv1alpha1.VIPBindingStatus) {
+		s.SetCondition(conditionType, status, reason, message)
+	})
+}
+
+// Create the VIPBinding of the Service if needed and update its status.
+func (b *BindingReporter) update(service *corev1.Service, mutate func(*lbv1alpha1.VIPBindingStatus)) error {
+	client := b.Dynamic.Resource(lbv1alpha1.VIPBindingResource).Namespace(service.Namespace)
+
+	binding := &lbv1alpha1.VIPBinding{}
+
+	u, err := b.get(client, service)
+	if errors.IsNotFound(err) {
+		binding = &lbv1alpha1.VIPBinding{
+			TypeMeta: metav1.TypeMeta{APIVersion: lbv1alpha1.SchemeGroupVersion.String(), Kind: "VIPBinding"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      service.Name,
+				Namespace: service.Namespace,
+				OwnerReferences: []metav1.OwnerReference{{
+					Name:       service.Name,
+					Kind:       "Service",
+					APIVersion: "v1",
+					UID:        service.UID,
+				}},
+			},
+			Spec: lbv1alpha1.VIPBindingSpec{ServiceName: service.Name},
+		}
+		obj, err := toUnstructured(binding)
+		if err != nil {
+			return err
+		}
+		if u, err = client.Create(obj, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating vipbinding for service '%s/%s': %w", service.Namespace, service.Name, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error getting vipbinding for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, binding); err != nil {
+		return err
+	}
+
+	old := binding.Status.DeepCopy()
+	mutate(&binding.Status)
+	if equalStatus(old, &binding.Status) {
+		return nil
+	}
+
+	obj, err := toUnstructured(binding)
+	if err != nil {
+		return err
+	}
+	if _, err := client.UpdateStatus(obj, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating vipbinding for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	return nil
+}
+
+// Get the VIPBinding of the Service from the Lister if there is one, or from the apiserver.
+func (b *BindingReporter) get(client dynamic.ResourceInterface, service *corev1.Service) (*unstructured.Unstructured, error) {
+	if b.Lister == nil {
+		return client.Get(service.Name, metav1.GetOptions{})
+	}
+
+	obj, err := b.Lister.ByNamespace(service.Namespace).Get(service.Name)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("expected an Unstructured, got %T", obj)
+	}
+
+	// Objects from the cache must not be modified.
+	return u.DeepCopy(), nil
+}
+
+func toUnstructured(binding *lbv1alpha1.VIPBinding) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(binding)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+func equalStatus(a, b *lbv1alpha1.VIPBindingStatus) bool {
+	if a.VIP != b.VIP || a.Provider != b.Provider || a.State != b.State || len(a.Conditions) != len(b.Conditions) {
+		return false
+	}
+	for i := range a.Conditions {
+		ca, cb := a.Conditions[i], b.Conditions[i]
+		if ca.Type != cb.Type || ca.Status != cb.Status || ca.Reason != cb.Reason || ca.Message != cb.Message {
+			return false
+		}
+	}
+	return true
+}
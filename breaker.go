@@ -0,0 +1,275 @@
+package lbutil
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamclientv1 "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned/typed/ipam.nexinto.com/v1"
+)
+
+// Returned instead of calling IPAM while the circuit is open.
+var ErrCircuitOpen = errors.New("IPAM is unavailable, not sending requests")
+
+// Stops calling IPAM after Threshold consecutive failures, so an IPAM outage does not cause a retry storm.
+// Services that already have a VIP are not affected. After Cooldown, one call (or Probe, if set) is let
+// through; if it succeeds, the circuit is closed again. See Clients.UseCircuitBreaker and WrapIpamClient.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	// Checks if IPAM is available again, for example CheckIPAM. Optional; if not set, the next real call is the probe.
+	Probe func() error
+
+	// Called once when the circuit opens, with the error that opened it. Optional.
+	OnOpen func(err error)
+
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// Create a CircuitBreaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Returns true if the circuit is open.
+func (b *CircuitBreaker) Open() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return !b.openedAt.IsZero()
+}
+
+// Run f unless the circuit is open. Failures of f count towards opening the circuit.
+func (b *CircuitBreaker) Do(f func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	if probe && b.Probe != nil {
+		if err := b.Probe(); err != nil {
+			b.done(err)
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, err.Error())
+		}
+		b.done(nil)
+	}
+
+	err = f()
+	b.done(err)
+
+	return err
+}
+
+// Returns ErrCircuitOpen if no call may be made now, and if the call is the probe.
+func (b *CircuitBreaker) allow() (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.openedAt.IsZero() {
+		return false, nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.Cooldown {
+		return false, ErrCircuitOpen
+	}
+
+	b.probing = true
+	return true, nil
+}
+
+// Record the outcome of a call.
+func (b *CircuitBreaker) done(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	wasProbe := b.probing
+	b.probing = false
+
+	if !isIPAMFailure(err) {
+		if !b.openedAt.IsZero() {
+			log.Info("IPAM is available again")
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+
+	switch {
+	case wasProbe:
+		// Still down; wait for another cooldown.
+		b.openedAt = time.Now()
+	case b.openedAt.IsZero() && b.failures >= b.Threshold:
+		b.openedAt = time.Now()
+		log.Warnf("IPAM failed %d times in a row, not sending requests for %s: %s", b.failures, b.Cooldown, err.Error())
+		if b.OnOpen != nil {
+			go b.OnOpen(err)
+		}
+	}
+}
+
+// Returns true if the error indicates that IPAM is unavailable, as opposed to a rejected request.
+func isIPAMFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		// Connection errors and the like.
+		return true
+	}
+
+	code := status.Status().Code
+	return code >= 500 || code == 429
+}
+
+// An AddressCreator (and AddressDeleter, if the wrapped one is) protected by a CircuitBreaker.
+type breakerAddressCreator struct {
+	creator AddressCreator
+	breaker *CircuitBreaker
+}
+
+// Protect the AddressCreator and the IPAM client of the WarmPool of the Clients with the breaker. When the
+// circuit opens, a Warning Event is recorded for the Service whose reconcile opened it. Pass the clients for
+// RenewLease, ReleaseQuarantined and IPAMSimulator through WrapIpamClient to protect them as well.
+func (c *Clients) UseCircuitBreaker(breaker *CircuitBreaker) {
+	c.AddressCreator = &breakerAddressCreator{creator: c.AddressCreator, breaker: breaker}
+	if c.WarmPool != nil {
+		c.WarmPool.IpamClient = breaker.WrapIpamClient(c.WarmPool.IpamClient)
+	}
+	c.breaker = breaker
+}
+
+func (b *breakerAddressCreator) CreateIpAddress(address *ipamv1.IpAddress) (*ipamv1.IpAddress, error) {
+	var created *ipamv1.IpAddress
+
+	err := b.breaker.Do(func() error {
+		var err error
+		created, err = b.creator.CreateIpAddress(address)
+		return err
+	})
+
+	return created, err
+}
+
+func (b *breakerAddressCreator) DeleteIpAddress(namespace, name string, uid types.UID) error {
+	deleter, ok := b.creator.(AddressDeleter)
+	if !ok {
+		return fmt.Errorf("ipaddress '%s/%s' cannot be deleted: not supported", namespace, name)
+	}
+
+	return b.breaker.Do(func() error {
+		return deleter.DeleteIpAddress(namespace, name, uid)
+	})
+}
+
+// Record a Warning Event for the Service if the circuit opened while it was reconciled.
+func (c *Clients) recordCircuitOpen(service *corev1.Service, wasOpen bool, err error) {
+	if c.breaker == nil || wasOpen || !c.breaker.Open() || c.Events == nil {
+		return
+	}
+
+	message := fmt.Sprintf("IPAM is unavailable, not requesting addresses for %s", c.breaker.Cooldown)
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	_ = c.Events.RecordEvent(service, ReasonIPAMUnavailable, message, true)
+}
+
+// Returns the IPAM client with the calls that change or list IpAddress objects protected by the breaker:
+// Create, Update, UpdateStatus, Patch, Delete, DeleteCollection and List. Get and Watch are passed through, so
+// informers keep working. Do not use the returned client for the Probe, which runs while the circuit is open.
+func (b *CircuitBreaker) WrapIpamClient(ipamclient ipamclientset.Interface) ipamclientset.Interface {
+	return &breakerIpamClient{Interface: ipamclient, breaker: b}
+}
+
+type breakerIpamClient struct {
+	ipamclientset.Interface
+	breaker *CircuitBreaker
+}
+
+func (c *breakerIpamClient) IpamV1() ipamclientv1.IpamV1Interface {
+	return &breakerIpamV1{IpamV1Interface: c.Interface.IpamV1(), breaker: c.breaker}
+}
+
+type breakerIpamV1 struct {
+	ipamclientv1.IpamV1Interface
+	breaker *CircuitBreaker
+}
+
+func (c *breakerIpamV1) IpAddresses(namespace string) ipamclientv1.IpAddressInterface {
+	return &breakerIpAddresses{IpAddressInterface: c.IpamV1Interface.IpAddresses(namespace), breaker: c.breaker}
+}
+
+type breakerIpAddresses struct {
+	ipamclientv1.IpAddressInterface
+	breaker *CircuitBreaker
+}
+
+func (c *breakerIpAddresses) Create(address *ipamv1.IpAddress) (result *ipamv1.IpAddress, err error) {
+	err = c.breaker.Do(func() error {
+		result, err = c.IpAddressInterface.Create(address)
+		return err
+	})
+	return result, err
+}
+
+func (c *breakerIpAddresses) Update(address *ipamv1.IpAddress) (result *ipamv1.IpAddress, err error) {
+	err = c.breaker.Do(func() error {
+		result, err = c.IpAddressInterface.Update(address)
+		return err
+	})
+	return result, err
+}
+
+func (c *breakerIpAddresses) UpdateStatus(address *ipamv1.IpAddress) (result *ipamv1.IpAddress, err error) {
+	err = c.breaker.Do(func() error {
+		result, err = c.IpAddressInterface.UpdateStatus(address)
+		return err
+	})
+	return result, err
+}
+
+func (c *breakerIpAddresses) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *ipamv1.IpAddress, err error) {
+	err = c.breaker.Do(func() error {
+		result, err = c.IpAddressInterface.Patch(name, pt, data, subresources...)
+		return err
+	})
+	return result, err
+}
+
+func (c *breakerIpAddresses) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.breaker.Do(func() error {
+		return c.IpAddressInterface.Delete(name, options)
+	})
+}
+
+func (c *breakerIpAddresses) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return c.breaker.Do(func() error {
+		return c.IpAddressInterface.DeleteCollection(options, listOptions)
+	})
+}
+
+func (c *breakerIpAddresses) List(opts metav1.ListOptions) (result *ipamv1.IpAddressList, err error) {
+	err = c.breaker.Do(func() error {
+		result, err = c.IpAddressInterface.List(opts)
+		return err
+	})
+	return result, err
+}
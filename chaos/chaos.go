@@ -0,0 +1,183 @@
+// Fault injection for the clientsets used with lbutil, to test the retry handling of controllers against
+// a misbehaving API server. Wrap a rest.Config for real clientsets or inject into fake clientsets.
+
+package chaos
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The faults to inject. Rates are fractions of the requests between 0 and 1.
+type Faults struct {
+
+	// Requests failing with an internal server error.
+	ErrorRate float64
+
+	// Updates and patches failing with a conflict.
+	ConflictRate float64
+
+	// Added to every request, plus a random duration up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// If set, only requests with these verbs (get, list, create, update, patch, delete) are affected.
+	Verbs []string
+
+	// The source of randomness; set it for reproducible runs.
+	Seed int64
+}
+
+// Decides which requests fail.
+type injector struct {
+	faults Faults
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func newInjector(faults Faults) *injector {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &injector{faults: faults, rand: rand.New(rand.NewSource(seed))}
+}
+
+// Sleep for the latency and return the error to inject, if any.
+func (i *injector) inject(verb string, resource schema.GroupResource, name string) error {
+	if !i.affects(verb) {
+		return nil
+	}
+
+	i.lock.Lock()
+	delay := i.faults.Latency
+	if i.faults.Jitter > 0 {
+		delay += time.Duration(i.rand.Int63n(int64(i.faults.Jitter)))
+	}
+	fail := i.rand.Float64() < i.faults.ErrorRate
+	conflict := (verb == "update" || verb == "patch") && i.rand.Float64() < i.faults.ConflictRate
+	i.lock.Unlock()
+
+	time.Sleep(delay)
+
+	switch {
+	case conflict:
+		return errors.NewConflict(resource, name, errInjected)
+	case fail:
+		return errors.NewInternalError(errInjected)
+	}
+	return nil
+}
+
+func (i *injector) affects(verb string) bool {
+	if verb == "watch" {
+		return false
+	}
+	if len(i.faults.Verbs) == 0 {
+		return true
+	}
+	for _, v := range i.faults.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+type injectedError struct{}
+
+func (injectedError) Error() string { return "injected fault" }
+
+var errInjected = injectedError{}
+
+// Returns a copy of the configuration whose clients suffer from the faults. Use it to create the kube
+// and ipam clientsets (for example with lbutil.NewClientsets).
+func Wrap(config *rest.Config, faults Faults) *rest.Config {
+	config = rest.CopyConfig(config)
+
+	i := newInjector(faults)
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &transport{next: rt, injector: i}
+	}
+
+	return config
+}
+
+type transport struct {
+	next     http.RoundTripper
+	injector *injector
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.injector.inject(verb(req), schema.GroupResource{}, "")
+	if err == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	status := err.(errors.APIStatus).Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	body, _ := json.Marshal(status)
+
+	return &http.Response{
+		StatusCode: int(status.Code),
+		Status:     http.StatusText(int(status.Code)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// The API verb of the request. Watches are never affected.
+func verb(req *http.Request) string {
+	switch req.Method {
+	case http.MethodGet:
+		if req.URL.Query().Get("watch") == "true" {
+			return "watch"
+		}
+		return "get"
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return req.Method
+}
+
+// Inject the faults into a fake clientset (the kube or ipam fake clientset, both embed k8stesting.Fake).
+// Reactors added later take precedence over the faults.
+func InjectFake(fake *k8stesting.Fake, faults Faults) {
+	i := newInjector(faults)
+
+	fake.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		var name string
+		if a, ok := action.(interface{ GetName() string }); ok {
+			name = a.GetName()
+		}
+		if err := i.inject(action.GetVerb(), action.GetResource().GroupResource(), name); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+}
@@ -0,0 +1,37 @@
+package lbutil
+
+import (
+	"fmt"
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+// Parse a list of networks in CIDR notation, for AllowedCIDRs.
+func ParseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	return addresses.ParsePrefixes(cidrs)
+}
+
+// Returns an error and records a Warning Event if AllowedCIDRs is set and the address is not in any of the networks.
+func (c *Clients) checkAllowed(service *corev1.Service, address string) error {
+	if c.allowed(address) {
+		return nil
+	}
+
+	err := fmt.Errorf("IPAM assigned address '%s' to service '%s/%s', which is not in an allowed network", address, service.Namespace, service.Name)
+	c.serviceLogger(service).Warn(err.Error())
+	_ = c.Events.RecordEvent(service, ReasonAddressRejected, err.Error(), true)
+
+	return Permanent(err)
+}
+
+// Returns true if the address is in one of the AllowedCIDRs, or if there are none.
+func (c *Clients) allowed(address string) bool {
+	if len(c.AllowedCIDRs) == 0 {
+		return true
+	}
+	addr, err := addresses.Parse(address)
+	return err == nil && addresses.Contains(c.AllowedCIDRs, addr)
+}
@@ -0,0 +1,81 @@
+package lbutil
+
+import (
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/plusserver/k8s-lbutil/patch"
+)
+
+// Returned by TryClaimService if another provider claimed the Service first.
+type ClaimConflictError struct {
+	Namespace string
+	Name      string
+
+	// The provider that holds the claim, if known.
+	Provider string
+}
+
+func (e *ClaimConflictError) Error() string {
+	if e.Provider == "" {
+		return fmt.Sprintf("service '%s/%s' was modified while claiming it", e.Namespace, e.Name)
+	}
+	return fmt.Sprintf("service '%s/%s' is already claimed by provider '%s'", e.Namespace, e.Name, e.Provider)
+}
+
+// Returns true if the error is or wraps a ClaimConflictError.
+func IsClaimConflict(err error) bool {
+	var conflict *ClaimConflictError
+	return errors.As(err, &conflict)
+}
+
+// Claim the Service for the controller using a JSON patch that only applies if the Service is unchanged
+// since it was read (same resourceVersion) and not claimed yet. Unlike setting the annotation with an Update,
+// exactly one of several competing providers wins; the others get a ClaimConflictError.
+// Returns the updated Service.
+func TryClaimService(kube kubernetes.Interface, service *corev1.Service, controllerName string) (*corev1.Service, error) {
+	if p := service.Annotations[AnnNxVIPActiveProvider]; p != "" {
+		if p == controllerName {
+			return service, nil
+		}
+		return nil, &ClaimConflictError{Namespace: service.Namespace, Name: service.Name, Provider: p}
+	}
+
+	data, err := patch.New().
+		TestResourceVersion(service).
+		AddAnnotation(service, AnnNxVIPActiveProvider, controllerName).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	newservice, err := kube.CoreV1().Services(service.Namespace).Patch(service.Name, types.JSONPatchType, data)
+	if err == nil {
+		ServiceLogger(service).Debug("claimed service")
+		return newservice, nil
+	}
+
+	if !apierrors.IsConflict(err) && !apierrors.IsInvalid(err) && !apierrors.IsBadRequest(err) {
+		return nil, fmt.Errorf("error claiming service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	// The precondition failed. Find out who won.
+	current, getErr := kube.CoreV1().Services(service.Namespace).Get(service.Name, metav1.GetOptions{})
+	if getErr != nil {
+		return nil, fmt.Errorf("error claiming service '%s/%s': %w", service.Namespace, service.Name, getErr)
+	}
+	if current.Annotations[AnnNxVIPActiveProvider] == controllerName {
+		return current, nil
+	}
+
+	ServiceLogger(service).Debugf("lost claim (%s)", err.Error())
+
+	return nil, &ClaimConflictError{Namespace: service.Namespace, Name: service.Name, Provider: current.Annotations[AnnNxVIPActiveProvider]}
+}
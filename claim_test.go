@@ -0,0 +1,33 @@
+package lbutil_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+func TestIsClaimConflict(t *testing.T) {
+	conflict := &lbutil.ClaimConflictError{Namespace: "default", Name: "web", Provider: "other"}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil"},
+		{name: "other error", err: errors.New("boom")},
+		{name: "conflict", err: conflict, want: true},
+		{name: "wrapped conflict", err: fmt.Errorf("error claiming service: %w", conflict), want: true},
+		{name: "conflict in the message only", err: fmt.Errorf("error claiming service: %s", conflict.Error())},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lbutil.IsClaimConflict(test.err); got != test.want {
+				t.Errorf("IsClaimConflict(%v) = %v, want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package lbutil
+
+import (
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// What to do after an error.
+type Decision string
+
+const (
+
+	// Nothing failed.
+	DecisionNone Decision = ""
+
+	// Try again right away; the error is expected to be gone, for example after a conflict.
+	DecisionRetry Decision = "Retry"
+
+	// Try again later, after After if it is set or with exponential backoff otherwise.
+	DecisionBackoff Decision = "Backoff"
+
+	// Give up; trying again will not help.
+	DecisionFail Decision = "Fail"
+)
+
+// The kind of an error, used to scale the backoff (see BackoffPolicy).
+type ErrorClass string
+
+const (
+
+	// Errors that are not one of the other classes.
+	ErrorClassOther ErrorClass = ""
+
+	// The object changed or is not in the cache yet.
+	ErrorClassConflict ErrorClass = "Conflict"
+
+	// The apiserver asks for fewer requests or timed out.
+	ErrorClassThrottled ErrorClass = "Throttled"
+
+	// The client is not allowed to do what it tried.
+	ErrorClassForbidden ErrorClass = "Forbidden"
+
+	// IPAM is unavailable (see CircuitBreaker).
+	ErrorClassIPAM ErrorClass = "IPAM"
+)
+
+// The delay for errors that need outside intervention, but might be fixed without restarting
+// the controller, for example missing RBAC permissions.
+var ForbiddenRetryDelay = time.Minute
+
+// The result of Classify.
+type Classification struct {
+	Decision Decision
+
+	// The suggested delay for DecisionBackoff. Zero means exponential backoff.
+	After time.Duration
+
+	// The kind of error.
+	Class ErrorClass
+}
+
+// Decide how to handle an error returned by the apiserver, IPAM or a WorkerFunc. Used by RunWorkers.
+func Classify(err error) Classification {
+	if err == nil {
+		return Classification{Decision: DecisionNone}
+	}
+
+	if IsPermanent(err) {
+		return Classification{Decision: DecisionFail}
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		return Classification{Decision: DecisionBackoff, Class: ErrorClassIPAM}
+	}
+
+	if errors.Is(err, ErrNotWarmedUp) {
+		return Classification{Decision: DecisionRetry, Class: ErrorClassConflict}
+	}
+
+	// Errors returned by the library wrap the API status error, which the apierrors functions don't see.
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return Classification{Decision: DecisionBackoff}
+	}
+
+	switch status.Status().Reason {
+	case metav1.StatusReasonConflict, metav1.StatusReasonAlreadyExists:
+		return Classification{Decision: DecisionRetry, Class: ErrorClassConflict}
+	case metav1.StatusReasonNotFound:
+		// Most likely an object that is not in the cache yet.
+		return Classification{Decision: DecisionRetry, Class: ErrorClassConflict}
+	case metav1.StatusReasonInvalid, metav1.StatusReasonBadRequest, metav1.StatusReasonMethodNotAllowed,
+		metav1.StatusReasonNotAcceptable, metav1.StatusReasonUnsupportedMediaType:
+		return Classification{Decision: DecisionFail}
+	case metav1.StatusReasonForbidden, metav1.StatusReasonUnauthorized:
+		return Classification{Decision: DecisionBackoff, After: ForbiddenRetryDelay, Class: ErrorClassForbidden}
+	case metav1.StatusReasonTooManyRequests, metav1.StatusReasonServerTimeout, metav1.StatusReasonTimeout:
+		if seconds, ok := apierrors.SuggestsClientDelay(APIStatusError(err)); ok && seconds > 0 {
+			return Classification{Decision: DecisionBackoff, After: time.Duration(seconds) * time.Second, Class: ErrorClassThrottled}
+		}
+		return Classification{Decision: DecisionBackoff, Class: ErrorClassThrottled}
+	}
+
+	return Classification{Decision: DecisionBackoff}
+}
@@ -0,0 +1,59 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+)
+
+// Client-side rate limits for the clientsets created by NewClientsets.
+type RateLimitOptions struct {
+
+	// Queries per second and burst per clientset. If zero, the values from the rest.Config
+	// (or the client-go defaults, 5 and 10) are used.
+	QPS   float32
+	Burst int
+
+	// If true, both clientsets share one rate limiter, so QPS and Burst limit the sum of their requests.
+	Shared bool
+}
+
+// Create the kube and ipam clientsets for the configuration with the given rate limits.
+// The configuration is not modified.
+func NewClientsets(config *rest.Config, options RateLimitOptions) (kubernetes.Interface, ipamclientset.Interface, error) {
+	config = rest.CopyConfig(config)
+
+	if options.QPS != 0 {
+		config.QPS = options.QPS
+	}
+	if options.Burst != 0 {
+		config.Burst = options.Burst
+	}
+
+	if options.Shared {
+		qps, burst := config.QPS, config.Burst
+		if qps == 0 {
+			qps = rest.DefaultQPS
+		}
+		if burst == 0 {
+			burst = rest.DefaultBurst
+		}
+		config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	}
+
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating kubernetes clientset: %w", err)
+	}
+
+	ipamclient, err := ipamclientset.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating ipam clientset: %w", err)
+	}
+
+	return kube, ipamclient, nil
+}
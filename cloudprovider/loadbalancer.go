@@ -0,0 +1,157 @@
+// Implements the LoadBalancer interface of k8s.io/cloud-provider with k8s-ipam, so lbutil can be embedded
+// in an out-of-tree cloud controller manager.
+
+package cloudprovider
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// The maximum number of EnsureVIP steps taken in one EnsureLoadBalancer call.
+const maxSteps = 3
+
+// A cloudprovider.LoadBalancer that gets VIPs from k8s-ipam.
+type LoadBalancer struct {
+	Clients *lbutil.Clients
+
+	ControllerName string
+
+	// Configures the loadbalancer for the Service once the VIP is assigned, and when the nodes change. Optional.
+	Configure func(ctx context.Context, service *corev1.Service, vip string, nodes []*corev1.Node) error
+
+	// Removes the loadbalancer configuration for the Service. Optional.
+	Deconfigure func(ctx context.Context, service *corev1.Service) error
+}
+
+var _ cloudprovider.LoadBalancer = &LoadBalancer{}
+
+// Create a LoadBalancer. It uses a copy of the Clients that handles LoadBalancer Services; the Clients passed
+// in are not modified.
+func NewLoadBalancer(clients *lbutil.Clients, controllerName string) *LoadBalancer {
+	own := *clients
+	own.ServiceTypes = []corev1.ServiceType{corev1.ServiceTypeLoadBalancer}
+
+	return &LoadBalancer{
+		Clients:        &own,
+		ControllerName: controllerName,
+	}
+}
+
+// Returns the status of the loadbalancer if the Service has a VIP from this provider.
+func (lb *LoadBalancer) GetLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service) (*corev1.LoadBalancerStatus, bool, error) {
+	if service.Annotations[lbutil.AnnNxVIPActiveProvider] != lb.ControllerName {
+		return nil, false, nil
+	}
+
+	vip := service.Annotations[lbutil.AnnNxAssignedVIP]
+	if vip == "" {
+		return nil, false, nil
+	}
+
+	return status(vip), true, nil
+}
+
+func (lb *LoadBalancer) GetLoadBalancerName(ctx context.Context, clusterName string, service *corev1.Service) string {
+	return cloudprovider.DefaultLoadBalancerName(service)
+}
+
+// Run EnsureVIP for the Service, updating it as needed. Returns an error while the VIP is not assigned yet,
+// so the service controller retries.
+func (lb *LoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, nodes []*corev1.Node) (*corev1.LoadBalancerStatus, error) {
+	var result *lbutil.Result
+	var err error
+
+	for step := 0; step < maxSteps; step++ {
+		result, err = lb.Clients.EnsureVIP(service, lb.ControllerName, false)
+		if err != nil {
+			return nil, err
+		}
+		if !result.NeedsUpdate {
+			break
+		}
+		if result.State == lbutil.StateReleased && lb.Deconfigure != nil {
+			if err := lb.Deconfigure(ctx, service); err != nil {
+				return nil, err
+			}
+		}
+		updated, err := lb.Clients.Services.UpdateService(result.Service)
+		if err != nil {
+			return nil, fmt.Errorf("error updating service '%s/%s': %w", service.Namespace, service.Name, err)
+		}
+		service = updated
+		if result.Ready() {
+			break
+		}
+	}
+
+	if !result.Ready() {
+		return nil, fmt.Errorf("VIP for service '%s/%s' is not ready: %s", service.Namespace, service.Name, result.Reason)
+	}
+
+	if lb.Configure != nil {
+		if err := lb.Configure(ctx, service, result.VIP, nodes); err != nil {
+			return nil, err
+		}
+	}
+
+	return status(result.VIP), nil
+}
+
+// Reconfigure the loadbalancer for the new set of nodes.
+func (lb *LoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, nodes []*corev1.Node) error {
+	if lb.Configure == nil {
+		return nil
+	}
+
+	vip := service.Annotations[lbutil.AnnNxAssignedVIP]
+	if vip == "" || service.Annotations[lbutil.AnnNxVIPActiveProvider] != lb.ControllerName {
+		return nil
+	}
+
+	return lb.Configure(ctx, service, vip, nodes)
+}
+
+// Remove the loadbalancer configuration, release the IpAddress and remove the claim and VIP from the Service.
+// Called when the Service is deleted or no longer of type LoadBalancer. The VIP is released like by
+// Clients.OnServiceDeleted, including the release policy and the OnVIPReleased hooks.
+func (lb *LoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *corev1.Service) error {
+	if service.Annotations[lbutil.AnnNxVIPActiveProvider] != lb.ControllerName {
+		return nil
+	}
+
+	var deconfigure func(service *corev1.Service) error
+	if lb.Deconfigure != nil {
+		deconfigure = func(service *corev1.Service) error {
+			return lb.Deconfigure(ctx, service)
+		}
+	}
+
+	if err := lb.Clients.OnServiceDeleted(service, lb.ControllerName, deconfigure); err != nil {
+		return err
+	}
+
+	// The Service may still exist with another type.
+	_, err := lb.Clients.Services.UpdateService(lbutil.UnclaimService(service))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error updating service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	lbutil.ServiceLogger(service).Info("released loadbalancer")
+
+	return nil
+}
+
+func status(vip string) *corev1.LoadBalancerStatus {
+	return &corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: vip}}}
+}
@@ -0,0 +1,159 @@
+package lbutil
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+const (
+
+	// Label on IpAddress objects in the management cluster with the name of the workload cluster of the Service.
+	LabelNxCluster = "nexinto.com/cluster"
+
+	// Label on IpAddress objects in the management cluster with the namespace of the Service.
+	LabelNxServiceNamespace = "nexinto.com/service-namespace"
+
+	// Label on IpAddress objects in the management cluster with the name of the Service.
+	LabelNxServiceName = "nexinto.com/service-name"
+)
+
+// A workload cluster whose Services get VIPs.
+type Cluster struct {
+	Name string
+	Kube kubernetes.Interface
+}
+
+// Manages VIPs for Services in several workload clusters. The IpAddress objects for all of them are created
+// in a single namespace of a central management cluster that runs k8s-ipam.
+type ClusterSet struct {
+	ipamclient    ipamclientset.Interface
+	addressLister ipamlisterv1.IpAddressLister
+	namespace     string
+
+	lock     sync.RWMutex
+	clusters map[string]Cluster
+}
+
+// Create a ClusterSet that creates IpAddress objects in the namespace of the management cluster.
+func NewClusterSet(ipamclient ipamclientset.Interface, addressLister ipamlisterv1.IpAddressLister, namespace string) *ClusterSet {
+	return &ClusterSet{
+		ipamclient:    ipamclient,
+		addressLister: addressLister,
+		namespace:     namespace,
+		clusters:      map[string]Cluster{},
+	}
+}
+
+// Add a workload cluster or replace its client.
+func (cs *ClusterSet) Add(name string, kube kubernetes.Interface) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.clusters[name] = Cluster{Name: name, Kube: kube}
+}
+
+// Remove a workload cluster.
+func (cs *ClusterSet) Remove(name string) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	delete(cs.clusters, name)
+}
+
+// Returns the workload cluster with the name.
+func (cs *ClusterSet) Get(name string) (Cluster, bool) {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	c, ok := cs.clusters[name]
+	return c, ok
+}
+
+// Returns Clients that read and update Services and record Events in the workload cluster, and
+// look up and create IpAddress objects in the management cluster.
+func (cs *ClusterSet) Clients(clusterName string) (*Clients, error) {
+	cluster, ok := cs.Get(clusterName)
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster '%s'", clusterName)
+	}
+
+	clients := NewClients(cluster.Kube, cs.ipamclient, cs.addressLister)
+	clients.Addresses = &remoteAddressGetter{cs: cs, cluster: clusterName}
+	clients.AddressCreator = &remoteAddressCreator{cs: cs, cluster: clusterName}
+
+	return clients, nil
+}
+
+// Name of the IpAddress object in the management cluster for a Service in a workload cluster.
+func RemoteAddressName(cluster, namespace, name string) string {
+	return fmt.Sprintf("%s.%s.%s", cluster, namespace, name)
+}
+
+// Returns the cluster and the namespace/name key of the Service an IpAddress in the management cluster was created for.
+// ok is false if the address was not created by a ClusterSet.
+func RemoteServiceKey(address *ipamv1.IpAddress) (cluster, key string, ok bool) {
+	cluster = address.Labels[LabelNxCluster]
+	namespace := address.Labels[LabelNxServiceNamespace]
+	name := address.Labels[LabelNxServiceName]
+	if cluster == "" || namespace == "" || name == "" {
+		return "", "", false
+	}
+	return cluster, fmt.Sprintf("%s/%s", namespace, name), true
+}
+
+type remoteAddressGetter struct {
+	cs      *ClusterSet
+	cluster string
+}
+
+func (g *remoteAddressGetter) GetIpAddress(namespace, name string) (*ipamv1.IpAddress, error) {
+	return g.cs.addressLister.IpAddresses(g.cs.namespace).Get(RemoteAddressName(g.cluster, namespace, name))
+}
+
+type remoteAddressCreator struct {
+	cs      *ClusterSet
+	cluster string
+}
+
+// Moves the address into the management namespace. OwnerReferences cannot point to objects in another cluster
+// (the garbage collector would delete the address), so they are replaced by labels.
+func (c *remoteAddressCreator) CreateIpAddress(address *ipamv1.IpAddress) (*ipamv1.IpAddress, error) {
+	remote := address.DeepCopy()
+	remote.Name = RemoteAddressName(c.cluster, address.Namespace, address.Name)
+	remote.Namespace = c.cs.namespace
+	remote.OwnerReferences = nil
+	if remote.Labels == nil {
+		remote.Labels = map[string]string{}
+	}
+	remote.Labels[LabelNxCluster] = c.cluster
+	remote.Labels[LabelNxServiceNamespace] = address.Namespace
+	remote.Labels[LabelNxServiceName] = address.Name
+
+	return c.cs.ipamclient.IpamV1().IpAddresses(remote.Namespace).Create(remote)
+}
+
+// Deletes the address in the management namespace for the address in the workload cluster. The UID is the UID
+// of the address in the management cluster, as returned by the AddressGetter.
+func (c *remoteAddressCreator) DeleteIpAddress(namespace, name string, uid types.UID) error {
+	opts := &metav1.DeleteOptions{}
+	if uid != "" {
+		opts.Preconditions = &metav1.Preconditions{UID: &uid}
+	}
+	return c.cs.ipamclient.IpamV1().IpAddresses(c.cs.namespace).Delete(RemoteAddressName(c.cluster, namespace, name), opts)
+}
+
+// Like EnsureVIPResult for a Service in a workload cluster.
+func (cs *ClusterSet) EnsureVIP(clusterName string, service *corev1.Service, controllerName string, requireAnnotation bool) (*Result, error) {
+	clients, err := cs.Clients(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return clients.EnsureVIP(service, controllerName, requireAnnotation)
+}
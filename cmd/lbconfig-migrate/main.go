@@ -0,0 +1,216 @@
+// Moves the loadbalancer settings of Services between annotations and LBConfig objects, so a cluster can switch
+// to LBConfigs gradually.
+//
+// With -to=crd, the settings in the annotations of each Service are written to an LBConfig named after it,
+// which the Service then references with the lb-config annotation. The annotations are kept unless -remove is given, and they
+// override the LBConfig, so running the command again keeps the LBConfig in sync with the annotations while
+// both are in use. With -to=annotations, the settings of the referenced LBConfig are written back to the
+// annotations of the Service.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/plusserver/k8s-lbutil/annotations"
+	lbv1alpha1 "github.com/plusserver/k8s-lbutil/apis/lbutil/v1alpha1"
+)
+
+type migrator struct {
+	kube    kubernetes.Interface
+	dynamic dynamic.Interface
+
+	remove bool
+	dryRun bool
+}
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "path to the kubeconfig; in-cluster configuration if empty")
+	namespace := flag.String("namespace", "", "only migrate Services in this namespace")
+	selector := flag.String("selector", "", "only migrate Services matching this label selector")
+	to := flag.String("to", "crd", "where to move the settings: crd or annotations")
+	remove := flag.Bool("remove", false, "remove the settings from where they are moved from")
+	dryRun := flag.Bool("dry-run", false, "only log what would be changed")
+	flag.Parse()
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		log.Fatalf("error loading kubeconfig: %s", err.Error())
+	}
+
+	m := &migrator{
+		kube:    kubernetes.NewForConfigOrDie(config),
+		dynamic: dynamic.NewForConfigOrDie(config),
+		remove:  *remove,
+		dryRun:  *dryRun,
+	}
+
+	var migrate func(*corev1.Service) error
+	switch *to {
+	case "crd":
+		migrate = m.toLBConfig
+	case "annotations":
+		migrate = m.toAnnotations
+	default:
+		log.Fatalf("invalid value '%s' for -to, must be crd or annotations", *to)
+	}
+
+	services, err := m.kube.CoreV1().Services(*namespace).List(metav1.ListOptions{LabelSelector: *selector})
+	if err != nil {
+		log.Fatalf("error listing services: %s", err.Error())
+	}
+
+	failed := 0
+	for i := range services.Items {
+		service := &services.Items[i]
+		if err := migrate(service); err != nil {
+			log.Errorf("error migrating service '%s/%s': %s", service.Namespace, service.Name, err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Write the annotation settings of the Service to its LBConfig, which is named after the Service. LBConfigs
+// with other names may be shared and are not modified.
+func (m *migrator) toLBConfig(service *corev1.Service) error {
+	if !annotations.HasLBConfigAnnotations(service) {
+		return nil
+	}
+
+	spec, err := annotations.AnnotationsToLBConfig(service.Annotations)
+	if err != nil {
+		return err
+	}
+
+	name := service.Annotations[annotations.AnnNxLBConfig]
+	if name == "" {
+		name = service.Name
+	}
+
+	logger := log.WithField("service", service.Namespace+"/"+service.Name).WithField("lbconfig", name)
+
+	if name != service.Name {
+		// Possibly shared with other Services; the annotations override it anyway.
+		logger.Warn("service references another lbconfig, leaving it alone")
+		return nil
+	}
+
+	if m.dryRun {
+		logger.Info("would write lbconfig")
+		return nil
+	}
+
+	if err := m.writeLBConfig(service, name, spec); err != nil {
+		return err
+	}
+	logger.Info("wrote lbconfig")
+
+	updated := service
+	if m.remove {
+		updated = annotations.WithoutLBConfigAnnotations(service)
+	}
+	if updated.Annotations[annotations.AnnNxLBConfig] != name {
+		if updated == service {
+			updated = service.DeepCopy()
+		}
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[annotations.AnnNxLBConfig] = name
+	}
+	if updated == service {
+		return nil
+	}
+
+	_, err = m.kube.CoreV1().Services(service.Namespace).Update(updated)
+	return err
+}
+
+// Write the settings of the LBConfig referenced by the Service to its annotations.
+func (m *migrator) toAnnotations(service *corev1.Service) error {
+	name := service.Annotations[annotations.AnnNxLBConfig]
+	if name == "" {
+		return nil
+	}
+
+	logger := log.WithField("service", service.Namespace+"/"+service.Name).WithField("lbconfig", name)
+
+	merged, err := (&annotations.Resolver{Dynamic: m.dynamic}).Resolve(service)
+	if err != nil {
+		return err
+	}
+	if m.remove {
+		delete(merged.Annotations, annotations.AnnNxLBConfig)
+	}
+
+	if m.dryRun {
+		logger.Info("would write annotations")
+		return nil
+	}
+
+	if _, err := m.kube.CoreV1().Services(service.Namespace).Update(merged); err != nil {
+		return err
+	}
+	logger.Info("wrote annotations")
+
+	return nil
+}
+
+// Create the LBConfig with the spec, or set the annotation settings of the Service in the existing one. Settings
+// that are only in the LBConfig are kept.
+func (m *migrator) writeLBConfig(service *corev1.Service, name string, spec lbv1alpha1.LBConfigSpec) error {
+	namespace := service.Namespace
+	client := m.dynamic.Resource(lbv1alpha1.LBConfigResource).Namespace(namespace)
+
+	u, err := client.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		config := &lbv1alpha1.LBConfig{
+			TypeMeta:   metav1.TypeMeta{APIVersion: lbv1alpha1.SchemeGroupVersion.String(), Kind: "LBConfig"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       spec,
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(config)
+		if err != nil {
+			return err
+		}
+		_, err = client.Create(&unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	config := &lbv1alpha1.LBConfig{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, config); err != nil {
+		return fmt.Errorf("invalid lbconfig '%s/%s': %w", namespace, name, err)
+	}
+	config.Spec, err = annotations.AnnotationsToLBConfig(annotations.Merge(service, config).Annotations)
+	if err != nil {
+		return fmt.Errorf("invalid lbconfig '%s/%s': %w", namespace, name, err)
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(config)
+	if err != nil {
+		return err
+	}
+	_, err = client.Update(&unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	return err
+}
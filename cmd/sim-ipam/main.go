@@ -0,0 +1,158 @@
+// Simulates the ipam controller, so loadbalancer controllers can be run against development clusters (for example
+// kind) without deploying k8s-ipam. Only the IpAddress CRD must be installed. Addresses are assigned from -cidr,
+// or from the network of the pool or namespace of the IpAddress (see lbutil.IPAMSimulator):
+//
+//	sim-ipam -cidr 172.18.255.0/24 -pool internal=10.10.0.0/24 -delay 2s
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipaminformers "github.com/Nexinto/k8s-ipam/pkg/client/informers/externalversions"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+// The only key in the queue; every run assigns all pending IpAddresses.
+const runKey = "run"
+
+// A repeatable name=value flag.
+type pairs map[string]string
+
+func (p pairs) String() string {
+	list := make([]string, 0, len(p))
+	for k, v := range p {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func (p pairs) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return fmt.Errorf("expected name=cidr, got '%s'", s)
+	}
+	p[kv[0]] = kv[1]
+	return nil
+}
+
+func main() {
+	pools := pairs{}
+	namespaceNetworks := pairs{}
+
+	kubeconfig := flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "path to the kubeconfig; in-cluster configuration if empty")
+	cidr := flag.String("cidr", lbutil.DefaultSimIPAMNetwork, "network to assign addresses from")
+	flag.Var(pools, "pool", "network of a pool as pool=cidr; can be repeated")
+	flag.Var(namespaceNetworks, "namespace-network", "network for IpAddresses in a namespace that are not in a -pool as namespace=cidr; can be repeated")
+	delay := flag.Duration("delay", 0, "how long to wait before assigning an address")
+	reuseDelay := flag.Duration("reuse-delay", 0, "how long a released address is not assigned again")
+	configMap := flag.String("configmap", "", "namespace/name of a ConfigMap to keep the allocations in")
+	resync := flag.Duration("resync", 30*time.Second, "how often to check all IpAddresses")
+	debug := flag.Bool("debug", false, "log every assignment")
+	flag.Parse()
+
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	networks := []string{*cidr}
+	for _, p := range []pairs{pools, namespaceNetworks} {
+		for _, network := range p {
+			networks = append(networks, network)
+		}
+	}
+	if _, err := addresses.ParsePrefixes(networks); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		log.Fatalf("error loading kubeconfig: %s", err.Error())
+	}
+
+	ipamclient, err := ipamclientset.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("error creating ipam client: %s", err.Error())
+	}
+
+	sim := lbutil.NewIPAMSimulator(ipamclient)
+	sim.Network = *cidr
+	sim.Pools = pools
+	sim.NamespaceNetworks = namespaceNetworks
+	sim.AssignDelay = *delay
+	sim.ReuseDelay = *reuseDelay
+
+	if *configMap != "" {
+		namespace, name, err := cache.SplitMetaNamespaceKey(*configMap)
+		if err != nil || namespace == "" {
+			log.Fatalf("invalid value '%s' for -configmap, must be namespace/name", *configMap)
+		}
+		sim.Kube = kubernetes.NewForConfigOrDie(config)
+		sim.ConfigMapNamespace = namespace
+		sim.ConfigMapName = name
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	// Run again once the delay of a new IpAddress is over.
+	enqueue := func(address *ipamv1.IpAddress) {
+		if address.Status.Address == "" {
+			queue.AddAfter(runKey, *delay)
+		}
+	}
+
+	factory := ipaminformers.NewSharedInformerFactory(ipamclient, *resync)
+	factory.Ipam().V1().IpAddresses().Informer().AddEventHandler(lbutil.TypedHandlers(
+		enqueue,
+		func(_, address *ipamv1.IpAddress) { enqueue(address) },
+		func(address *ipamv1.IpAddress) {
+			if err := sim.Deleted(address); err != nil {
+				log.Errorf("error releasing address of '%s/%s': %s", address.Namespace, address.Name, err.Error())
+			}
+		},
+	))
+
+	stopCh := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stopCh)
+	}()
+
+	startup := &lbutil.Startup{IpamInformers: factory}
+	if err := startup.Start(stopCh); err != nil {
+		log.Fatalf("error starting informers: %s", err.Error())
+	}
+
+	log.Infof("assigning addresses from %s", *cidr)
+
+	queue.Add(runKey)
+	lbutil.RunWorkers(queue, 1, func(string) error {
+		next, err := sim.Run()
+		if next > 0 {
+			// IpAddresses that were too young at this run; the queue only keeps the earliest AddAfter.
+			queue.AddAfter(runKey, next)
+		}
+		return err
+	}, stopCh)
+}
@@ -0,0 +1,98 @@
+package lbutil
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// A work queue that collects added keys for a short window and adds each key only once when the window ends.
+// Use it for the Service queue passed to IpAddressCreatedOrUpdated, so a burst of IpAddress updates
+// (for example when IPAM catches up after an outage) does not wake the same Service over and over.
+// The window starts with the first key added after a flush, so no key is delayed longer than the window.
+// All other methods are passed through to the wrapped queue.
+type CoalescingQueue struct {
+	workqueue.RateLimitingInterface
+
+	window time.Duration
+
+	lock    sync.Mutex
+	pending map[interface{}]bool
+	order   []interface{}
+	timer   *time.Timer
+}
+
+// Wrap the queue. Keys are held back for at most window.
+func NewCoalescingQueue(queue workqueue.RateLimitingInterface, window time.Duration) *CoalescingQueue {
+	return &CoalescingQueue{
+		RateLimitingInterface: queue,
+		window:                window,
+		pending:               map[interface{}]bool{},
+	}
+}
+
+// Add the item when the current window ends. Items already waiting are ignored.
+func (q *CoalescingQueue) Add(item interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.pending[item] {
+		return
+	}
+	q.pending[item] = true
+	q.order = append(q.order, item)
+
+	if q.timer == nil {
+		q.timer = time.AfterFunc(q.window, q.Flush)
+	}
+}
+
+// Add the item to the wrapped queue right away with low priority if the wrapped queue implements
+// LowPriorityQueue; otherwise like Add. Implements LowPriorityQueue.
+func (q *CoalescingQueue) AddLowPriority(item interface{}) {
+	if low, ok := q.RateLimitingInterface.(LowPriorityQueue); ok {
+		low.AddLowPriority(item)
+		return
+	}
+	q.Add(item)
+}
+
+// Add all waiting items to the wrapped queue now, in the order they were first added.
+func (q *CoalescingQueue) Flush() {
+	q.lock.Lock()
+	items := q.order
+	q.reset()
+	q.lock.Unlock()
+
+	for _, item := range items {
+		q.RateLimitingInterface.Add(item)
+	}
+}
+
+// Returns the number of items waiting for the window to end.
+func (q *CoalescingQueue) Pending() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.order)
+}
+
+// Drop the waiting items and shut down the wrapped queue.
+func (q *CoalescingQueue) ShutDown() {
+	q.lock.Lock()
+	q.reset()
+	q.lock.Unlock()
+
+	q.RateLimitingInterface.ShutDown()
+}
+
+// Must be called with the lock held.
+func (q *CoalescingQueue) reset() {
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	q.pending = map[interface{}]bool{}
+	q.order = nil
+}
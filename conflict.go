@@ -0,0 +1,152 @@
+package lbutil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/tools/cache"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+// Name of the Service informer index that maps VIPs to Services.
+const IndexVIP = "nexinto.com/vip"
+
+// Returned if a Service uses the same VIP as another Service that came first.
+type VIPConflictError struct {
+	VIP   string
+	Owner *corev1.Service
+}
+
+func (e *VIPConflictError) Error() string {
+	return fmt.Sprintf("VIP %s is already used by service '%s/%s'", e.VIP, e.Owner.Namespace, e.Owner.Name)
+}
+
+// Returns true if the error is or wraps a VIPConflictError.
+func IsVIPConflict(err error) bool {
+	var conflict *VIPConflictError
+	return errors.As(err, &conflict)
+}
+
+// Returns the VIPs a Service has been assigned or requested, including the second VIP of a dual-stack Service,
+// in canonical form (see addresses.Canonical).
+func ServiceVIPs(service *corev1.Service) []string {
+	var vips []string
+	if vip := canonicalVIP(service.Annotations[AnnNxAssignedVIP]); vip != "" {
+		vips = append(vips, vip)
+	}
+	if ip := canonicalVIP(service.Annotations[AnnNxRequestedIP]); ip != "" && (len(vips) == 0 || ip != vips[0]) {
+		vips = append(vips, ip)
+	}
+	if vip := canonicalVIP(service.Annotations[AnnNxAssignedSecondaryVIP]); vip != "" {
+		vips = append(vips, vip)
+	}
+	return vips
+}
+
+// Returns the address in canonical form, or unchanged if it cannot be parsed.
+func canonicalVIP(s string) string {
+	if c, err := addresses.Canonical(s); err == nil {
+		return c
+	}
+	return s
+}
+
+// Index function for Service informers; indexes Services by their assigned and requested VIPs.
+func ServiceVIPIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, fmt.Errorf("expected a Service, got %T", obj)
+	}
+	return ServiceVIPs(service), nil
+}
+
+// Register the VIP and UID indexes with a Service informer. Must be called before the informer is started.
+func AddServiceIndexers(informer cache.SharedIndexInformer) error {
+	return addMissingIndexers(informer, cache.Indexers{
+		IndexVIP: ServiceVIPIndexFunc,
+		IndexUID: ServiceUIDIndexFunc,
+	})
+}
+
+// Returns the other Services that use one of the VIPs of the Service.
+func FindVIPConflicts(indexer cache.Indexer, service *corev1.Service) ([]*corev1.Service, error) {
+	var conflicts []*corev1.Service
+
+	for _, vip := range ServiceVIPs(service) {
+		objs, err := indexer.ByIndex(IndexVIP, vip)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			other := obj.(*corev1.Service)
+			if other.UID != service.UID {
+				conflicts = append(conflicts, other)
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+// Returns true if service a has precedence over b for a shared VIP: the older Service wins.
+func precedes(a, b *corev1.Service) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name) < 0
+}
+
+// Check if the Service shares a VIP with another Service. If the other Service came first, a VIPConflictError is
+// returned and the Service must not be programmed. Warning Events are recorded for both Services when the Service
+// that lost enters StateConflict, not on every call; the Service that came first records nothing.
+// EnsureVIP does not return the VIPConflictError, but stores StateConflict with the error as its LastError.
+func (c *Clients) CheckVIPConflict(indexer cache.Indexer, service *corev1.Service) error {
+	conflicts, err := FindVIPConflicts(indexer, service)
+	if err != nil {
+		return err
+	}
+
+	var conflictErr *VIPConflictError
+
+	for _, other := range conflicts {
+		if !precedes(other, service) {
+			continue
+		}
+
+		vip := sharedVIP(service, other)
+		if conflictErr == nil {
+			conflictErr = &VIPConflictError{VIP: vip, Owner: other}
+		}
+
+		if state, _ := GetVIPState(service); state != nil && state.Phase == StateConflict {
+			continue
+		}
+
+		message := fmt.Sprintf("VIP %s is used by services '%s/%s' and '%s/%s'", vip, service.Namespace, service.Name, other.Namespace, other.Name)
+		ServiceLogger(service).Warn(message)
+		_ = c.Events.RecordEvent(service, ReasonVIPConflict, message, true)
+		_ = c.Events.RecordEvent(other, ReasonVIPConflict, message, true)
+	}
+
+	if conflictErr != nil {
+		return conflictErr
+	}
+
+	return nil
+}
+
+// Returns a VIP used by both Services.
+func sharedVIP(a, b *corev1.Service) string {
+	for _, v := range ServiceVIPs(a) {
+		for _, w := range ServiceVIPs(b) {
+			if v == w {
+				return v
+			}
+		}
+	}
+	return ""
+}
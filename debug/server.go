@@ -0,0 +1,143 @@
+// An opt-in HTTP server for diagnosing controllers in production: pprof and a dump of the managed Services.
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// Serves pprof under /debug/pprof/ and the state of all Services under /debug/services.
+// Only bind it to localhost or protect it otherwise; profiles and the Service dump are not authenticated.
+type Server struct {
+
+	// The address to listen on, for example "localhost:6060".
+	Addr string
+
+	Clients  *lbutil.Clients
+	Services corelisterv1.ServiceLister
+
+	ControllerName    string
+	RequireAnnotation bool
+}
+
+// The state of one Service as seen by the library.
+type ServiceState struct {
+	Namespace string       `json:"namespace"`
+	Name      string       `json:"name"`
+	State     lbutil.State `json:"state"`
+	VIP       string       `json:"vip,omitempty"`
+	Provider  string       `json:"provider,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+}
+
+// Returns the HTTP handler of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/services", s.serveServices)
+
+	return mux
+}
+
+// Serve until stopCh is closed.
+func (s *Server) Run(stopCh <-chan struct{}) error {
+	server := &http.Server{Addr: s.Addr, Handler: s.Handler()}
+
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	log.Infof("debug server listening on %s", s.Addr)
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Returns the state of all Services, sorted by namespace and name. Services not handled by the controller
+// are only included if all is true.
+func (s *Server) States(all bool) ([]ServiceState, error) {
+	services, err := s.Services.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	states := []ServiceState{}
+	for _, service := range services {
+		state := ServiceState{
+			Namespace: service.Namespace,
+			Name:      service.Name,
+			VIP:       service.Annotations[lbutil.AnnNxAssignedVIP],
+			Provider:  service.Annotations[lbutil.AnnNxVIPActiveProvider],
+		}
+
+		obs, err := s.Clients.Observe(service, s.ControllerName, s.RequireAnnotation)
+		if err != nil {
+			state.Reason = err.Error()
+		} else {
+			state.State = obs.State()
+			state.Reason = obs.Describe()
+		}
+
+		if state.State == lbutil.StateSkipped && !all {
+			continue
+		}
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Namespace != states[j].Namespace {
+			return states[i].Namespace < states[j].Namespace
+		}
+		return states[i].Name < states[j].Name
+	})
+
+	return states, nil
+}
+
+// Dump the Service states as JSON. ?all=true includes skipped Services, ?state=Requested filters by state.
+func (s *Server) serveServices(w http.ResponseWriter, r *http.Request) {
+	states, err := s.States(r.URL.Query().Get("all") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if filter := r.URL.Query().Get("state"); filter != "" {
+		filtered := []ServiceState{}
+		for _, state := range states {
+			if string(state.State) == filter {
+				filtered = append(filtered, state)
+			}
+		}
+		states = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(states)
+}
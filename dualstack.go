@@ -0,0 +1,263 @@
+package lbutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+	"github.com/plusserver/k8s-lbutil/features"
+)
+
+// The Kubernetes API used by this library predates spec.ipFamilies and spec.ipFamilyPolicy, so Services
+// set them with these annotations, using the same values. spec.ipFamily is honored if ip-families is not set.
+const (
+
+	// SingleStack, PreferDualStack or RequireDualStack. If not set, the Service is single-stack.
+	AnnNxIPFamilyPolicy = "nexinto.com/ip-family-policy"
+
+	// Comma-separated address families in order of preference, for example "IPv6,IPv4".
+	AnnNxIPFamilies = "nexinto.com/ip-families"
+
+	// This will be the VIP of the second address family of a dual-stack Service.
+	AnnNxAssignedSecondaryVIP = "nexinto.com/assigned-secondary-vip"
+)
+
+// How many address families a Service gets VIPs for, like corev1.IPFamilyPolicyType in later Kubernetes versions.
+type IPFamilyPolicy string
+
+const (
+	IPFamilyPolicySingleStack      IPFamilyPolicy = "SingleStack"
+	IPFamilyPolicyPreferDualStack  IPFamilyPolicy = "PreferDualStack"
+	IPFamilyPolicyRequireDualStack IPFamilyPolicy = "RequireDualStack"
+)
+
+// Event reason if the VIPs a Service asks for cannot be allocated.
+const ReasonIPFamilyUnsatisfiable = "IPFamilyUnsatisfiable"
+
+// The value of LabelNxAddressRole for the IpAddress of the VIP of the second family.
+const AddressRoleSecondary = "secondary"
+
+// Returns the family policy and the address families of the Service, the primary family first. The families
+// are empty if a single-stack Service does not care. A dual-stack Service always gets two families, IPv4 first
+// unless it asks otherwise.
+func ServiceFamilies(service *corev1.Service) (IPFamilyPolicy, []string, error) {
+	policy := IPFamilyPolicy(service.Annotations[AnnNxIPFamilyPolicy])
+	switch policy {
+	case "":
+		policy = IPFamilyPolicySingleStack
+	case IPFamilyPolicySingleStack, IPFamilyPolicyPreferDualStack, IPFamilyPolicyRequireDualStack:
+	default:
+		return "", nil, fmt.Errorf("%s: invalid policy '%s'", AnnNxIPFamilyPolicy, policy)
+	}
+
+	var families []string
+	if v := service.Annotations[AnnNxIPFamilies]; v != "" {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f != addresses.IPv4 && f != addresses.IPv6 {
+				return "", nil, fmt.Errorf("%s: invalid family '%s'", AnnNxIPFamilies, f)
+			}
+			if contains(families, f) {
+				return "", nil, fmt.Errorf("%s: duplicate family '%s'", AnnNxIPFamilies, f)
+			}
+			families = append(families, f)
+		}
+	} else if family := serviceFamily(service); family != "" {
+		families = []string{family}
+	}
+
+	if len(families) > 1 && policy == IPFamilyPolicySingleStack {
+		return "", nil, fmt.Errorf("%s: two families need a dual-stack policy", AnnNxIPFamilies)
+	}
+
+	if policy != IPFamilyPolicySingleStack && len(families) < 2 {
+		if len(families) == 0 || families[0] == addresses.IPv4 {
+			families = []string{addresses.IPv4, addresses.IPv6}
+		} else {
+			families = []string{addresses.IPv6, addresses.IPv4}
+		}
+	}
+
+	return policy, families, nil
+}
+
+// Returns the pool for the primary family of the Service from FamilyPools, or "".
+func (c *Clients) familyPool(service *corev1.Service) string {
+	if len(c.FamilyPools) == 0 {
+		return ""
+	}
+	_, families, err := ServiceFamilies(service)
+	if err != nil || len(families) == 0 {
+		return ""
+	}
+	return c.FamilyPools[families[0]]
+}
+
+// Returns the name of the IpAddress for the VIP of the family that is not the primary one.
+func (c *Clients) secondaryAddressName(service *corev1.Service, family string) string {
+	return truncateName(c.addressName(service), "-"+strings.ToLower(family))
+}
+
+// Make sure a ready dual-stack Service also has a VIP of its second family. With RequireDualStack, the Service
+// is not ready until it has both; with PreferDualStack, it is ready with the primary VIP alone and gets the
+// second one when it can. Needs the DualStack feature and a pool for the second family in FamilyPools.
+// The primary VIP must be of the first family.
+func (c *Clients) ensureSecondary(result *Result) error {
+	service := result.Service
+
+	policy, families, err := ServiceFamilies(service)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	if policy == IPFamilyPolicySingleStack {
+		if service.Annotations[AnnNxAssignedSecondaryVIP] == "" {
+			return nil
+		}
+		if err := c.deleteSecondaryAddresses(service); err != nil {
+			return err
+		}
+		c.setSecondaryVIP(result, "")
+		return nil
+	}
+
+	family := families[1]
+	pool := c.FamilyPools[family]
+
+	var reason string
+	if !features.Enabled(features.DualStack) {
+		reason = fmt.Sprintf("the %s feature is disabled", features.DualStack)
+	} else if pool == "" {
+		reason = fmt.Sprintf("no pool is configured for %s", family)
+	}
+	if reason != "" {
+		if policy == IPFamilyPolicyPreferDualStack {
+			return nil
+		}
+		err := fmt.Errorf("cannot allocate a %s VIP for service '%s/%s': %s", family, service.Namespace, service.Name, reason)
+		return c.rejectFamily(result, err)
+	}
+
+	if primary := addresses.FamilyOf(result.VIP); primary != families[0] {
+		err := fmt.Errorf("IPAM assigned %s address '%s' to service '%s/%s', which asks for %s first", primary, result.VIP, service.Namespace, service.Name, families[0])
+		if policy == IPFamilyPolicyPreferDualStack {
+			// Without the primary family, a second VIP would be of the same family.
+			c.serviceLogger(service).Warn(err.Error())
+			return nil
+		}
+		return c.rejectFamily(result, err)
+	}
+
+	name := c.secondaryAddressName(service, family)
+
+	address, err := c.Addresses.GetIpAddress(service.Namespace, name)
+	if errors.IsNotFound(err) {
+		opts := c.AddressOptions(service)
+		opts.Pool = pool
+		opts.RequestedIP = ""
+
+		request := NewIpAddress(service, name, opts)
+		request.Labels[LabelNxAddressRole] = AddressRoleSecondary
+
+		if _, err := c.AddressCreator.CreateIpAddress(request); err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create %s ip address request for service '%s/%s': %w", family, service.Namespace, service.Name, err)
+		}
+		c.serviceLogger(service).Infof("created %s ip address request", family)
+
+		c.waitForSecondary(result, policy, requeueDelay(nil, time.Now()))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	vip := address.Status.Address
+	if vip == "" {
+		c.waitForSecondary(result, policy, requeueDelay(address, time.Now()))
+		return nil
+	}
+
+	if addresses.FamilyOf(vip) != family {
+		err := fmt.Errorf("IPAM assigned %s address '%s' to service '%s/%s' from pool '%s'", addresses.FamilyOf(vip), vip, service.Namespace, service.Name, pool)
+		return c.rejectFamily(result, err)
+	}
+	if err := c.checkAllowed(service, vip); err != nil {
+		result.State = StateRequested
+		return err
+	}
+
+	c.setSecondaryVIP(result, vip)
+	result.SecondaryVIP = vip
+
+	return nil
+}
+
+// The second VIP is not assigned yet; with RequireDualStack, the Service is not ready.
+func (c *Clients) waitForSecondary(result *Result, policy IPFamilyPolicy, delay time.Duration) {
+	if policy == IPFamilyPolicyRequireDualStack {
+		result.State = StateRequested
+	}
+	result.RequeueAfter = delay
+}
+
+// Record a Warning Event for a RequireDualStack Service that cannot get both VIPs. The Service is not ready.
+func (c *Clients) rejectFamily(result *Result, err error) error {
+	c.serviceLogger(result.Service).Warn(err.Error())
+	_ = c.Events.RecordEvent(result.Service, ReasonIPFamilyUnsatisfiable, err.Error(), true)
+	result.State = StateRequested
+	return Permanent(err)
+}
+
+// Store the second VIP on the Service, copying it unless it was already modified.
+func (c *Clients) setSecondaryVIP(result *Result, vip string) {
+	if result.Service.Annotations[AnnNxAssignedSecondaryVIP] == vip {
+		return
+	}
+
+	if !result.NeedsUpdate {
+		result.Service = result.Service.DeepCopy()
+	}
+	if vip == "" {
+		delete(result.Service.Annotations, AnnNxAssignedSecondaryVIP)
+	} else {
+		result.Service.Annotations[AnnNxAssignedSecondaryVIP] = vip
+	}
+	result.NeedsUpdate = true
+
+	c.serviceLogger(result.Service).WithField(LogFieldVIP, vip).Debug("storing assigned secondary VIP")
+}
+
+// Delete the IpAddress objects for the second VIP of the Service, of either family. Addresses of a previous
+// Service with the same name are left alone.
+func (c *Clients) deleteSecondaryAddresses(service *corev1.Service) error {
+	deleter, ok := c.AddressCreator.(AddressDeleter)
+	if !ok {
+		return fmt.Errorf("cannot release the secondary ip address of service '%s/%s': addresses cannot be deleted", service.Namespace, service.Name)
+	}
+
+	for _, family := range []string{addresses.IPv4, addresses.IPv6} {
+		name := c.secondaryAddressName(service, family)
+
+		address, err := c.Addresses.GetIpAddress(service.Namespace, name)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error looking up ip address '%s' of service '%s/%s': %w", name, service.Namespace, service.Name, err)
+		}
+		if !ownedBy(address, service) {
+			continue
+		}
+
+		if err := deleter.DeleteIpAddress(address.Namespace, address.Name, address.UID); err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+			return fmt.Errorf("error releasing ip address '%s' of service '%s/%s': %w", name, service.Namespace, service.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package lbutil
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Limits Events to one per object and reason per interval. Used by the EventRecorder of NewClients.
+var DefaultEventLimiter = NewEventLimiter(time.Minute)
+
+// Allows one Event per object and reason per interval and counts the suppressed ones.
+type EventLimiter struct {
+	interval time.Duration
+
+	lock      sync.Mutex
+	entries   map[string]*eventLimit
+	lastSweep time.Time
+}
+
+type eventLimit struct {
+	last       time.Time
+	suppressed int
+}
+
+// Create an EventLimiter.
+func NewEventLimiter(interval time.Duration) *EventLimiter {
+	return &EventLimiter{interval: interval, entries: map[string]*eventLimit{}}
+}
+
+// Returns true if an Event with the reason may be recorded for the object now, and how many were suppressed
+// since the last one.
+func (l *EventLimiter) Allow(o metav1.Object, reason string, now time.Time) (bool, int) {
+	key := fmt.Sprintf("%s/%s/%s/%s", o.GetNamespace(), o.GetName(), o.GetUID(), reason)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		l.entries[key] = &eventLimit{last: now}
+		return true, 0
+	}
+	if now.Sub(e.last) < l.interval {
+		e.suppressed++
+		return false, 0
+	}
+
+	suppressed := e.suppressed
+	e.last = now
+	e.suppressed = 0
+
+	return true, suppressed
+}
+
+// Forget objects without Events for an interval. Objects with suppressed Events are kept, so the next Event
+// still reports them.
+func (l *EventLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.interval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, e := range l.entries {
+		if e.suppressed == 0 && now.Sub(e.last) >= l.interval {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// An EventRecorder that drops Events exceeding the limit of the EventLimiter. The next Event recorded
+// mentions how many were suppressed.
+type rateLimitedEventRecorder struct {
+	recorder EventRecorder
+	limiter  *EventLimiter
+}
+
+// Wrap the EventRecorder with the EventLimiter.
+func RateLimitEvents(recorder EventRecorder, limiter *EventLimiter) EventRecorder {
+	return &rateLimitedEventRecorder{recorder: recorder, limiter: limiter}
+}
+
+func (r *rateLimitedEventRecorder) RecordEvent(o metav1.Object, reason, message string, warn bool) error {
+	ok, suppressed := r.limiter.Allow(o, reason, time.Now())
+	if !ok {
+		return nil
+	}
+	if suppressed > 0 {
+		message = fmt.Sprintf("%s (%d similar events suppressed)", message, suppressed)
+	}
+
+	return r.recorder.RecordEvent(o, reason, message, warn)
+}
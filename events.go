@@ -0,0 +1,95 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// Knows the kinds of all objects events may be created for.
+var eventScheme = runtime.NewScheme()
+
+func init() {
+	_ = scheme.AddToScheme(eventScheme)
+	_ = ipamv1.AddToScheme(eventScheme)
+}
+
+// Stores the Events created by MakeEventTo and LogEventAndFailTo. NewEventSink writes them to the API;
+// fake.EventSink keeps them in memory, so tests can check them without inspecting the actions of a fake clientset.
+type EventSink interface {
+	CreateEvent(event *corev1.Event) (*corev1.Event, error)
+}
+
+// Create an EventSink writing Events to the API.
+func NewEventSink(kube kubernetes.Interface) EventSink {
+	return &kubeEventSink{kube: kube}
+}
+
+type kubeEventSink struct {
+	kube kubernetes.Interface
+}
+
+func (s *kubeEventSink) CreateEvent(event *corev1.Event) (*corev1.Event, error) {
+	return s.kube.CoreV1().Events(event.Namespace).Create(event)
+}
+
+// An EventRecorder writing Events to the sink. Unlike the EventRecorder of NewClients, it is not rate limited.
+func SinkEventRecorder(sink EventSink) EventRecorder {
+	return &sinkEventRecorder{sink: sink}
+}
+
+type sinkEventRecorder struct {
+	sink EventSink
+}
+
+func (r *sinkEventRecorder) RecordEvent(o metav1.Object, reason, message string, warn bool) error {
+	return MakeEventTo(r.sink, o, reason, message, warn)
+}
+
+// Create an event for an object, setting the Kind and APIVersion of the involved object from its type.
+// The reason should be one of the Reason constants.
+func MakeEventFor(kube kubernetes.Interface, o runtime.Object, reason, message string, warn bool) error {
+	meta, ok := o.(metav1.Object)
+	if !ok {
+		return fmt.Errorf("cannot create an event for %T: not an API object", o)
+	}
+	return MakeEvent(kube, meta, reason, message, warn)
+}
+
+// Build the reference to the object for its events. Objects that are not runtime.Objects or of an unknown
+// type are referenced as IpAddresses for compatibility.
+func involvedObject(o metav1.Object) corev1.ObjectReference {
+	ref := corev1.ObjectReference{
+		Name:            o.GetName(),
+		Namespace:       o.GetNamespace(),
+		APIVersion:      "v1",
+		UID:             o.GetUID(),
+		Kind:            "IpAddress",
+		ResourceVersion: o.GetResourceVersion(),
+	}
+
+	obj, ok := o.(runtime.Object)
+	if !ok {
+		return ref
+	}
+
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Kind == "" {
+		gvks, _, err := eventScheme.ObjectKinds(obj)
+		if err != nil || len(gvks) == 0 {
+			return ref
+		}
+		gvk = gvks[0]
+	}
+
+	ref.APIVersion, ref.Kind = gvk.ToAPIVersionAndKind()
+
+	return ref
+}
@@ -0,0 +1,182 @@
+// Periodic export of the Service to VIP mapping to external inventories, for example a CSV file in object
+// storage, NetBox or phpIPAM. Implement Exporter for other systems.
+
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/internal/fileutil"
+)
+
+// One VIP in the inventory.
+type Entry struct {
+	Namespace string
+	Service   string
+	VIP       string
+	Provider  string
+	Pool      string
+
+	// True if the VIP is being retired (see lbutil.RetiringVIPs).
+	Retiring bool
+}
+
+// Pushes the complete inventory to an external system. Called with all entries on every sync.
+type Exporter interface {
+	Export(entries []Entry) error
+}
+
+// Returns the VIPs of all Services, sorted by namespace, name and VIP.
+func Inventory(services corelisterv1.ServiceLister) ([]Entry, error) {
+	list, err := services.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing services: %w", err)
+	}
+
+	var entries []Entry
+	for _, service := range list {
+		entry := Entry{
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			Provider:  service.Annotations[lbutil.AnnNxVIPActiveProvider],
+			Pool:      service.Annotations[lbutil.AnnNxVIPPool],
+		}
+
+		if vip := service.Annotations[lbutil.AnnNxAssignedVIP]; vip != "" {
+			e := entry
+			e.VIP = vip
+			entries = append(entries, e)
+		}
+
+		retiring, err := lbutil.RetiringVIPs(service)
+		if err != nil {
+			lbutil.ServiceLogger(service).Warn(err.Error())
+			continue
+		}
+		for _, r := range retiring {
+			e := entry
+			e.VIP = r.VIP
+			e.Retiring = true
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.VIP < b.VIP
+	})
+
+	return entries, nil
+}
+
+// Export the inventory every interval until stopCh is closed. Errors are logged; the next sync tries again.
+func RunSync(services corelisterv1.ServiceLister, exporter Exporter, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		entries, err := Inventory(services)
+		if err != nil {
+			log.Errorf("error building VIP inventory: %s", err.Error())
+			return
+		}
+		if err := exporter.Export(entries); err != nil {
+			log.Errorf("error exporting VIP inventory: %s", err.Error())
+			return
+		}
+		log.Debugf("exported %d VIPs", len(entries))
+	}, interval, stopCh)
+}
+
+// Write the entries as CSV with a header line.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"namespace", "service", "vip", "provider", "pool", "retiring"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := cw.Write([]string{e.Namespace, e.Service, e.VIP, e.Provider, e.Pool, fmt.Sprint(e.Retiring)}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes the inventory as a CSV file. The file is replaced atomically.
+type FileExporter struct {
+	Path string
+}
+
+func (f *FileExporter) Export(entries []Entry) error {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, entries); err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(f.Path, buf.Bytes())
+}
+
+// Uploads the inventory as CSV with an HTTP PUT, for example to a pre-signed S3 URL or a WebDAV share.
+type HTTPExporter struct {
+	URL string
+
+	// Added to every request, for example for authentication.
+	Headers map[string]string
+
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (h *HTTPExporter) Export(entries []Entry) error {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, entries); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, h.URL, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/csv")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Not including the URL; pre-signed URLs contain credentials.
+		return fmt.Errorf("upload returned %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// An lbutil.EventSink that keeps Events in memory. Use it with lbutil.MakeEventTo or, as the EventRecorder
+// of Clients, with lbutil.SinkEventRecorder.
+type EventSink struct {
+	lock   sync.Mutex
+	events []corev1.Event
+}
+
+var _ lbutil.EventSink = &EventSink{}
+
+// Create an empty EventSink.
+func NewEventSink() *EventSink {
+	return &EventSink{}
+}
+
+func (s *EventSink) CreateEvent(event *corev1.Event) (*corev1.Event, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := event.DeepCopy()
+	if e.Name == "" {
+		e.Name = fmt.Sprintf("%s%d", e.GenerateName, len(s.events))
+	}
+	s.events = append(s.events, *e)
+	return e.DeepCopy(), nil
+}
+
+// Returns all Events in the order they were created.
+func (s *EventSink) Events() []corev1.Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]corev1.Event(nil), s.events...)
+}
+
+// Returns the Events for the object, in the order they were created.
+func (s *EventSink) For(namespace, name string) []corev1.Event {
+	return s.filter(func(e corev1.Event) bool {
+		return e.InvolvedObject.Namespace == namespace && e.InvolvedObject.Name == name
+	})
+}
+
+// Returns the Events with the reason, in the order they were created.
+func (s *EventSink) WithReason(reason string) []corev1.Event {
+	return s.filter(func(e corev1.Event) bool { return e.Reason == reason })
+}
+
+// Returns the Warning Events, in the order they were created.
+func (s *EventSink) Warnings() []corev1.Event {
+	return s.filter(func(e corev1.Event) bool { return e.Type == corev1.EventTypeWarning })
+}
+
+// Returns the reasons of the Events for the object, in the order they were created.
+func (s *EventSink) Reasons(namespace, name string) []string {
+	var reasons []string
+	for _, e := range s.For(namespace, name) {
+		reasons = append(reasons, e.Reason)
+	}
+	return reasons
+}
+
+// Returns true if an Event with the reason was created for the object.
+func (s *EventSink) Has(namespace, name, reason string) bool {
+	for _, e := range s.For(namespace, name) {
+		if e.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// Forget all Events.
+func (s *EventSink) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.events = nil
+}
+
+// Fail the test unless an Event with the reason was created for the object.
+func (s *EventSink) ExpectEvent(t testing.TB, namespace, name, reason string) {
+	t.Helper()
+
+	if !s.Has(namespace, name, reason) {
+		t.Errorf("expected a %s event for %s; got %v", reason, key(namespace, name), s.Reasons(namespace, name))
+	}
+}
+
+// Fail the test if an Event with the reason was created for the object.
+func (s *EventSink) ExpectNoEvent(t testing.TB, namespace, name, reason string) {
+	t.Helper()
+
+	if s.Has(namespace, name, reason) {
+		t.Errorf("expected no %s event for %s", reason, key(namespace, name))
+	}
+}
+
+func (s *EventSink) filter(match func(e corev1.Event) bool) []corev1.Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var events []corev1.Event
+	for _, e := range s.events {
+		if match(e) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
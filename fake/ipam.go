@@ -0,0 +1,227 @@
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/allocator"
+)
+
+// The pool of IpAddresses without the vip-pool annotation.
+const DefaultPool = "default"
+
+// Returned if a pool has no free address. Use errors.As to get the pool.
+type ExhaustedError struct {
+	Pool     string
+	Capacity int
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("pool '%s' is exhausted: all %d addresses are in use", e.Pool, e.Capacity)
+}
+
+// A fake IPAM that hands out addresses from pools of finite size. Implements the AddressGetter, AddressCreator
+// and AddressDeleter of lbutil.Clients, so providers can test running out of addresses and releasing them
+// without a cluster.
+//
+// Addresses are assigned when the IpAddress is created, like a synchronous IPAM, unless Async is set; then
+// they are assigned by Assign. If the pool is exhausted, CreateIpAddress fails with an ExhaustedError, or with
+// Async, the IpAddress stays unassigned. Requested addresses (see AddressOptions.RequestedIP) are honored if
+// they are free.
+type IPAM struct {
+	Async bool
+
+	lock      sync.Mutex
+	pools     map[string]*allocator.Allocator
+	addresses map[string]*ipamv1.IpAddress
+	uid       int
+}
+
+var (
+	_ lbutil.AddressGetter  = &IPAM{}
+	_ lbutil.AddressCreator = &IPAM{}
+	_ lbutil.AddressDeleter = &IPAM{}
+)
+
+// Create an IPAM without pools.
+func NewIPAM() *IPAM {
+	return &IPAM{
+		pools:     map[string]*allocator.Allocator{},
+		addresses: map[string]*ipamv1.IpAddress{},
+	}
+}
+
+// Add a pool with the first capacity usable addresses of the network, or all of them if capacity is 0.
+// The network and broadcast addresses of IPv4 networks are not used (see allocator.Allocator).
+func (i *IPAM) AddPool(name, cidr string, capacity int) error {
+	a, err := allocator.New(cidr)
+	if err != nil {
+		return err
+	}
+	a.Capacity = capacity
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, ok := i.pools[name]; ok {
+		return fmt.Errorf("duplicate pool '%s'", name)
+	}
+	i.pools[name] = a
+	return nil
+}
+
+func (i *IPAM) GetIpAddress(namespace, name string) (*ipamv1.IpAddress, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	address, ok := i.addresses[key(namespace, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(ipamv1.Resource("ipaddresses"), name)
+	}
+	return address.DeepCopy(), nil
+}
+
+func (i *IPAM) CreateIpAddress(address *ipamv1.IpAddress) (*ipamv1.IpAddress, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	k := key(address.Namespace, address.Name)
+	if _, ok := i.addresses[k]; ok {
+		return nil, apierrors.NewAlreadyExists(ipamv1.Resource("ipaddresses"), address.Name)
+	}
+
+	name := poolOf(address)
+	if _, ok := i.pools[name]; !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unknown pool '%s'", name))
+	}
+
+	created := address.DeepCopy()
+	i.uid++
+	created.UID = types.UID(fmt.Sprintf("fake-ipam-%d", i.uid))
+	created.Status = ipamv1.IpAddressStatus{}
+
+	if !i.Async {
+		if err := i.assign(created); err != nil {
+			return nil, err
+		}
+	}
+
+	i.addresses[k] = created
+	return created.DeepCopy(), nil
+}
+
+// Delete the IpAddress and release its address. A uid of "" matches any object.
+func (i *IPAM) DeleteIpAddress(namespace, name string, uid types.UID) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	k := key(namespace, name)
+	address, ok := i.addresses[k]
+	if !ok {
+		return apierrors.NewNotFound(ipamv1.Resource("ipaddresses"), name)
+	}
+	if uid != "" && address.UID != uid {
+		return apierrors.NewConflict(ipamv1.Resource("ipaddresses"), name, fmt.Errorf("uid is %s, not %s", address.UID, uid))
+	}
+
+	i.release(address)
+	delete(i.addresses, k)
+	return nil
+}
+
+// Assign addresses to all unassigned IpAddresses, in the order of their keys. Returns the errors of
+// the IpAddresses that could not be assigned, for example because their pool is exhausted.
+func (i *IPAM) Assign() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	keys := make([]string, 0, len(i.addresses))
+	for k := range i.addresses {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []string
+	for _, k := range keys {
+		address := i.addresses[k]
+		if address.Status.Address != "" {
+			continue
+		}
+		if err := i.assign(address); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", k, err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot assign all addresses: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Returns the number of free addresses in the pool.
+func (i *IPAM) Free(name string) int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if a, ok := i.pools[name]; ok {
+		return a.Free()
+	}
+	return 0
+}
+
+// Returns the assigned addresses of the pool and the keys of their IpAddresses.
+func (i *IPAM) Used(name string) map[string]string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if a, ok := i.pools[name]; ok {
+		return a.Allocations()
+	}
+	return map[string]string{}
+}
+
+// Assign an address from the pool of the IpAddress. Must be called with the lock held.
+func (i *IPAM) assign(address *ipamv1.IpAddress) error {
+	name := poolOf(address)
+	a := i.pools[name]
+	k := key(address.Namespace, address.Name)
+
+	vip := ""
+	if requested := address.Annotations[lbutil.AnnNxRequestedIP]; requested != "" && a.AllocateAddress(k, requested) == nil {
+		vip = a.Address(k)
+	} else {
+		var err error
+		if vip, err = a.Allocate(k); errors.Is(err, allocator.ErrExhausted) {
+			return &ExhaustedError{Pool: name, Capacity: a.Size()}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	address.Status.Address = vip
+	address.Status.Provider = "fake"
+	return nil
+}
+
+// Return the address of the IpAddress to its pool. Must be called with the lock held.
+func (i *IPAM) release(address *ipamv1.IpAddress) {
+	if a, ok := i.pools[poolOf(address)]; ok {
+		a.Release(key(address.Namespace, address.Name))
+	}
+}
+
+func poolOf(address *ipamv1.IpAddress) string {
+	if pool := address.Annotations[lbutil.AnnNxVIPPool]; pool != "" {
+		return pool
+	}
+	return DefaultPool
+}
@@ -0,0 +1,233 @@
+// Test doubles for controllers using lbutil.
+
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// A delayed add recorded by the Queue.
+type DelayedAdd struct {
+	Item     interface{}
+	Duration time.Duration
+}
+
+// A workqueue.RateLimitingInterface that records all adds instead of scheduling them, so tests can check
+// what was enqueued without sleeping or polling a real queue. All adds (immediate, delayed and rate limited)
+// are immediately available from Get. Like a real queue, Get blocks while the queue is empty and reports
+// a shutdown only after ShutDown was called and the queued items are processed.
+type Queue struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	items       []interface{}
+	added       []interface{}
+	delayed     []DelayedAdd
+	rateLimited []interface{}
+	requeues    map[interface{}]int
+	shutDown    bool
+}
+
+var _ workqueue.RateLimitingInterface = &Queue{}
+
+// Create an empty Queue.
+func NewQueue() *Queue {
+	q := &Queue{requeues: map[interface{}]int{}}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+func (q *Queue) Add(item interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.added = append(q.added, item)
+	q.push(item)
+}
+
+func (q *Queue) AddAfter(item interface{}, duration time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.delayed = append(q.delayed, DelayedAdd{Item: item, Duration: duration})
+	q.push(item)
+}
+
+func (q *Queue) AddRateLimited(item interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.rateLimited = append(q.rateLimited, item)
+	q.requeues[item]++
+	q.push(item)
+}
+
+func (q *Queue) Forget(item interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.requeues, item)
+}
+
+func (q *Queue) NumRequeues(item interface{}) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.requeues[item]
+}
+
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.items)
+}
+
+func (q *Queue) Get() (interface{}, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.items) == 0 && !q.shutDown {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return nil, true
+	}
+	item := q.items[0]
+	q.items = q.items[1:]
+	return item, false
+}
+
+func (q *Queue) Done(item interface{}) {}
+
+func (q *Queue) ShutDown() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.shutDown = true
+	q.cond.Broadcast()
+}
+
+func (q *Queue) ShuttingDown() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.shutDown
+}
+
+// Must be called with the lock held. Like a real queue, an item waiting to be processed is only queued once.
+func (q *Queue) push(item interface{}) {
+	if q.shutDown {
+		return
+	}
+	for _, i := range q.items {
+		if i == item {
+			return
+		}
+	}
+	q.items = append(q.items, item)
+	q.cond.Signal()
+}
+
+// Returns all items added with Add, in order, including duplicates.
+func (q *Queue) Added() []interface{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return append([]interface{}{}, q.added...)
+}
+
+// Returns all AddAfter calls, in order.
+func (q *Queue) Delayed() []DelayedAdd {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return append([]DelayedAdd{}, q.delayed...)
+}
+
+// Returns all items added with AddRateLimited, in order.
+func (q *Queue) RateLimited() []interface{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return append([]interface{}{}, q.rateLimited...)
+}
+
+// Forget all recorded calls and queued items.
+func (q *Queue) Reset() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.items = nil
+	q.added = nil
+	q.delayed = nil
+	q.rateLimited = nil
+	q.requeues = map[interface{}]int{}
+}
+
+// Returns true if the key was added in any way.
+func (q *Queue) WasEnqueued(key string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, item := range q.added {
+		if item == key {
+			return true
+		}
+	}
+	for _, d := range q.delayed {
+		if d.Item == key {
+			return true
+		}
+	}
+	for _, item := range q.rateLimited {
+		if item == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Fail the test unless the Service key namespace/name was added.
+func (q *Queue) ExpectEnqueued(t testing.TB, namespace, name string) {
+	t.Helper()
+
+	if key := key(namespace, name); !q.WasEnqueued(key) {
+		t.Errorf("expected %s to be enqueued; added: %v, delayed: %v, rate limited: %v", key, q.Added(), q.Delayed(), q.RateLimited())
+	}
+}
+
+// Fail the test if the Service key namespace/name was added.
+func (q *Queue) ExpectNotEnqueued(t testing.TB, namespace, name string) {
+	t.Helper()
+
+	if key := key(namespace, name); q.WasEnqueued(key) {
+		t.Errorf("expected %s not to be enqueued", key)
+	}
+}
+
+// Fail the test unless the Service key was added with AddAfter with the duration.
+func (q *Queue) ExpectEnqueuedAfter(t testing.TB, namespace, name string, duration time.Duration) {
+	t.Helper()
+
+	key := key(namespace, name)
+	for _, d := range q.Delayed() {
+		if d.Item == key && d.Duration == duration {
+			return
+		}
+	}
+	t.Errorf("expected %s to be enqueued after %s; delayed: %v", key, duration, q.Delayed())
+}
+
+// The queue key of an object, like cache.MetaNamespaceKeyFunc.
+func key(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
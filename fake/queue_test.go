@@ -0,0 +1,83 @@
+package fake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueRecordsAdds(t *testing.T) {
+	q := NewQueue()
+
+	q.Add("a/one")
+	q.Add("a/one")
+	q.AddAfter("a/two", time.Minute)
+	q.AddRateLimited("a/three")
+
+	if len(q.Added()) != 2 {
+		t.Errorf("expected both adds to be recorded, got %v", q.Added())
+	}
+	if q.Len() != 3 {
+		t.Errorf("expected 3 queued items, got %d", q.Len())
+	}
+	if q.NumRequeues("a/three") != 1 {
+		t.Errorf("expected 1 requeue, got %d", q.NumRequeues("a/three"))
+	}
+
+	q.ExpectEnqueued(t, "a", "one")
+	q.ExpectEnqueuedAfter(t, "a", "two", time.Minute)
+	q.ExpectEnqueued(t, "a", "three")
+	q.ExpectNotEnqueued(t, "a", "four")
+
+	q.Forget("a/three")
+	if q.NumRequeues("a/three") != 0 {
+		t.Errorf("expected the requeues to be forgotten")
+	}
+}
+
+func TestQueueGetBlocksUntilAdd(t *testing.T) {
+	q := NewQueue()
+
+	got := make(chan interface{})
+	go func() {
+		item, shutdown := q.Get()
+		if shutdown {
+			t.Error("unexpected shutdown")
+		}
+		got <- item
+	}()
+
+	select {
+	case item := <-got:
+		t.Fatalf("Get returned %v from an empty queue", item)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	q.Add("a/one")
+
+	select {
+	case item := <-got:
+		if item != "a/one" {
+			t.Errorf("got %v, want a/one", item)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get did not return after Add")
+	}
+}
+
+func TestQueueShutDownDrains(t *testing.T) {
+	q := NewQueue()
+
+	q.Add("a/one")
+	q.ShutDown()
+	q.Add("a/two")
+
+	if item, shutdown := q.Get(); shutdown || item != "a/one" {
+		t.Errorf("got %v, %v; want the queued item before the shutdown", item, shutdown)
+	}
+	if _, shutdown := q.Get(); !shutdown {
+		t.Error("expected a shutdown once the queue is drained")
+	}
+	if !q.ShuttingDown() {
+		t.Error("expected the queue to be shutting down")
+	}
+}
@@ -0,0 +1,166 @@
+// Feature gates for risky library behaviors, so controllers can adopt new lbutil versions and enable features
+// one at a time. Gates are set with LBUTIL_FEATURE_GATES="Feature=true,Other=false" or the flag added by AddFlag.
+
+package features
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A library behavior that can be switched on or off.
+type Feature string
+
+const (
+
+	// Update Services with JSON patches instead of full updates.
+	PatchUpdates Feature = "PatchUpdates"
+
+	// Request IPv4 and IPv6 VIPs for dual-stack Services.
+	DualStack Feature = "DualStack"
+
+	// Let a provider take over Services claimed by a provider that is no longer in the registry
+	// (see Clients.Registry).
+	Takeover Feature = "Takeover"
+)
+
+// The environment variable read by the Default gate.
+const EnvFeatureGates = "LBUTIL_FEATURE_GATES"
+
+// The name of the flag added by AddFlag.
+const FlagFeatureGates = "lbutil-feature-gates"
+
+// The known features and their defaults.
+var defaults = map[Feature]bool{
+	PatchUpdates: false,
+	DualStack:    false,
+	Takeover:     false,
+}
+
+// The gate used by the library, initialized from the environment.
+var Default = NewGate()
+
+func init() {
+	if err := Default.SetFromEnv(); err != nil {
+		log.Errorf("ignoring invalid %s: %s", EnvFeatureGates, err.Error())
+	}
+}
+
+// Returns true if the feature is enabled in the Default gate.
+func Enabled(f Feature) bool {
+	return Default.Enabled(f)
+}
+
+// A set of enabled features. Implements flag.Value.
+type Gate struct {
+	lock    sync.RWMutex
+	enabled map[Feature]bool
+}
+
+// Create a gate with all features at their defaults.
+func NewGate() *Gate {
+	g := &Gate{enabled: map[Feature]bool{}}
+	for f, on := range defaults {
+		g.enabled[f] = on
+	}
+	return g
+}
+
+// Returns true if the feature is enabled. Unknown features are disabled.
+func (g *Gate) Enabled(f Feature) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	return g.enabled[f]
+}
+
+// Enable or disable a feature.
+func (g *Gate) SetEnabled(f Feature, on bool) error {
+	if _, ok := defaults[f]; !ok {
+		return fmt.Errorf("unknown feature '%s'", f)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.enabled[f] = on
+	return nil
+}
+
+// Set features from a comma-separated list of Feature=bool pairs. Nothing is changed if the list is invalid.
+func (g *Gate) Set(spec string) error {
+	settings := map[Feature]bool{}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("missing value for feature '%s'", kv[0])
+		}
+		f := Feature(strings.TrimSpace(kv[0]))
+		if _, ok := defaults[f]; !ok {
+			return fmt.Errorf("unknown feature '%s'", f)
+		}
+		on, err := strconv.ParseBool(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return fmt.Errorf("invalid value for feature '%s': %w", f, err)
+		}
+		settings[f] = on
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for f, on := range settings {
+		g.enabled[f] = on
+	}
+	return nil
+}
+
+// Set features from the LBUTIL_FEATURE_GATES environment variable, if set.
+func (g *Gate) SetFromEnv() error {
+	if spec := os.Getenv(EnvFeatureGates); spec != "" {
+		return g.Set(spec)
+	}
+	return nil
+}
+
+// Returns the state of all known features as a comma-separated list, sorted by name.
+func (g *Gate) String() string {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	pairs := make([]string, 0, len(g.enabled))
+	for f, on := range g.enabled {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", f, on))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// Returns the known features, sorted.
+func Known() []Feature {
+	features := make([]Feature, 0, len(defaults))
+	for f := range defaults {
+		features = append(features, f)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i] < features[j] })
+
+	return features
+}
+
+// Add the --lbutil-feature-gates flag for the gate to the flag set.
+func (g *Gate) AddFlag(fs *flag.FlagSet) {
+	fs.Var(g, FlagFeatureGates, fmt.Sprintf("Comma-separated list of lbutil features to enable or disable (Feature=true|false). Known features: %v", Known()))
+}
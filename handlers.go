@@ -0,0 +1,96 @@
+package lbutil
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Returns an AddFunc for informer event handlers that calls f with the object as T.
+// Objects of other types are logged and ignored.
+func OnAdd[T any](f func(T)) func(obj interface{}) {
+	return func(obj interface{}) {
+		o, ok := obj.(T)
+		if !ok {
+			log.Errorf("add handler: unexpected object of type %T", obj)
+			return
+		}
+		f(o)
+	}
+}
+
+// Returns an UpdateFunc for informer event handlers that calls f with the old and new objects as T.
+func OnUpdate[T any](f func(oldObj, newObj T)) func(oldObj, newObj interface{}) {
+	return func(oldObj, newObj interface{}) {
+		o, ok1 := oldObj.(T)
+		n, ok2 := newObj.(T)
+		if !ok1 || !ok2 {
+			log.Errorf("update handler: unexpected objects of type %T, %T", oldObj, newObj)
+			return
+		}
+		f(o, n)
+	}
+}
+
+// Returns a DeleteFunc for informer event handlers that calls f with the object as T.
+// If the informer missed the deletion, the last known state is unwrapped from the tombstone.
+func OnDelete[T any](f func(T)) func(obj interface{}) {
+	return func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		o, ok := obj.(T)
+		if !ok {
+			log.Errorf("delete handler: unexpected object of type %T", obj)
+			return
+		}
+		f(o)
+	}
+}
+
+// Build typed event handlers for an informer. Any of the callbacks may be nil. To add the keys of changed
+// objects to a work queue, use QueueHandlers.
+func TypedHandlers[T any](add func(T), update func(oldObj, newObj T), del func(T)) cache.ResourceEventHandlerFuncs {
+	var h cache.ResourceEventHandlerFuncs
+	if add != nil {
+		h.AddFunc = OnAdd(add)
+	}
+	if update != nil {
+		h.UpdateFunc = OnUpdate(update)
+	}
+	if del != nil {
+		h.DeleteFunc = OnDelete(del)
+	}
+	return h
+}
+
+// Build event handlers for an informer that add the key (namespace/name) of added, updated and deleted objects
+// to the queue. Periodic resyncs of the informer (see IsResync) are added with EnqueueLowPriority, so they
+// yield to changes if the queue implements LowPriorityQueue.
+func QueueHandlers[T metav1.Object](queue workqueue.Interface) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj T) {
+		queue.Add(objectKey(obj))
+	}
+	return TypedHandlers(
+		enqueue,
+		func(oldObj, newObj T) {
+			if IsResync(oldObj, newObj) {
+				EnqueueLowPriority(queue, objectKey(newObj))
+				return
+			}
+			enqueue(newObj)
+		},
+		enqueue,
+	)
+}
+
+// Returns the namespace/name key of the object, or only the name if it is not namespaced.
+func objectKey(obj metav1.Object) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
@@ -0,0 +1,172 @@
+// Renders HAProxy configuration for Services with a VIP, for software loadbalancers managed by an lbutil controller.
+
+package haproxy
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/internal/fileutil"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+// A Service to loadbalance.
+type Service struct {
+	Namespace string
+	Name      string
+	VIP       string
+	Ports     []portconfig.PortConfig
+	Backends  []lbutil.Backend
+}
+
+type server struct {
+	Name    string
+	Address string
+	Options string
+}
+
+type proxy struct {
+	Name    string
+	Bind    string
+	Mode    string
+	Servers []server
+}
+
+var configTemplate = template.Must(template.New("haproxy").Parse(`# Generated by lbutil; do not edit.
+{{- range . }}
+
+frontend {{ .Name }}
+    bind {{ .Bind }}
+    mode {{ .Mode }}
+    default_backend {{ .Name }}
+
+backend {{ .Name }}
+    mode {{ .Mode }}
+    balance roundrobin
+{{- range .Servers }}
+    server {{ .Name }} {{ .Address }} check{{ .Options }}
+{{- end }}
+{{- end }}
+`))
+
+// Render the configuration fragment for the Services. The output is deterministic, so it can be compared
+// to the previous configuration to avoid needless reloads. UDP ports are skipped, HAProxy cannot balance them.
+func Render(services []Service) ([]byte, error) {
+	var proxies []proxy
+
+	for _, s := range services {
+		for _, p := range s.Ports {
+			if p.Protocol == portconfig.ProtocolUDP {
+				log.WithField(lbutil.LogFieldService, s.Name).Warnf("skipping UDP port %d", p.Port)
+				continue
+			}
+
+			mode := "tcp"
+			if p.Protocol == portconfig.ProtocolHTTP {
+				mode = "http"
+			}
+
+			options := ""
+			if p.ProxyProtocol && p.ProxyProtocolVersion == portconfig.ProxyProtocolV2 {
+				options += " send-proxy-v2"
+			} else if p.ProxyProtocol {
+				options += " send-proxy"
+			}
+			if p.BackendProtocol == portconfig.ProtocolHTTPS {
+				options += " ssl verify none"
+			}
+
+			px := proxy{
+				Name: fmt.Sprintf("%s_%s_%d", s.Namespace, s.Name, p.Port),
+				Bind: bindAddress(s.VIP, p.Port),
+				Mode: mode,
+			}
+			for _, b := range s.Backends {
+				serverOptions := options
+				if weight := b.EffectiveWeight(); weight != lbutil.DefaultBackendWeight {
+					serverOptions += fmt.Sprintf(" weight %d", weight)
+				}
+				px.Servers = append(px.Servers, server{
+					Name:    b.Node,
+					Address: bindAddress(b.Address, p.NodePort),
+					Options: serverOptions,
+				})
+			}
+
+			proxies = append(proxies, px)
+		}
+	}
+
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].Name < proxies[j].Name })
+
+	var buf bytes.Buffer
+	if err := configTemplate.Execute(&buf, proxies); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Format address:port, with brackets for IPv6.
+func bindAddress(address string, port int32) string {
+	if strings.Contains(address, ":") {
+		return fmt.Sprintf("[%s]:%d", address, port)
+	}
+	return fmt.Sprintf("%s:%d", address, port)
+}
+
+// Writes the configuration to a file and reloads HAProxy when it changed.
+type Writer struct {
+
+	// The configuration file.
+	Path string
+
+	// The command that reloads HAProxy, for example {"systemctl", "reload", "haproxy"}. Optional.
+	ReloadCommand []string
+
+	// Called instead of ReloadCommand if set.
+	Reload func() error
+}
+
+// Render the configuration for the Services, write it if it differs from the current file and reload.
+// Returns true if the configuration changed.
+func (w *Writer) Apply(services []Service) (changed bool, err error) {
+	config, err := Render(services)
+	if err != nil {
+		return false, err
+	}
+
+	if fileutil.SameContent(w.Path, config) {
+		return false, nil
+	}
+
+	if err := fileutil.WriteFileAtomic(w.Path, config); err != nil {
+		return false, err
+	}
+
+	log.WithField("path", w.Path).Info("haproxy configuration changed; reloading")
+
+	return true, w.reload()
+}
+
+func (w *Writer) reload() error {
+	if w.Reload != nil {
+		return w.Reload()
+	}
+	if len(w.ReloadCommand) == 0 {
+		return nil
+	}
+
+	out, err := exec.Command(w.ReloadCommand[0], w.ReloadCommand[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error reloading haproxy: %w: %s", err, string(out))
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package lbutil
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+)
+
+// Check that IPAM can be reached by listing at most one IpAddress. Use it for readiness checks
+// (see the health package) and as the Probe of a CircuitBreaker.
+func CheckIPAM(ipamclient ipamclientset.Interface) error {
+	if _, err := ipamclient.IpamV1().IpAddresses(metav1.NamespaceAll).List(metav1.ListOptions{Limit: 1}); err != nil {
+		return fmt.Errorf("IPAM is not available: %w", err)
+	}
+	return nil
+}
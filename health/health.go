@@ -0,0 +1,57 @@
+// HTTP readiness and liveness endpoints for controllers using lbutil.
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// Returns an error if the controller is not healthy.
+type Check func() error
+
+// Check that IPAM can be reached.
+func IPAM(ipamclient ipamclientset.Interface) Check {
+	return func() error {
+		return lbutil.CheckIPAM(ipamclient)
+	}
+}
+
+// Returns a handler that runs all checks and responds with 200 if they pass and 503 otherwise.
+// The body lists the result of every check by name. Use it for /readyz.
+func Handler(checks map[string]Check) http.Handler {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		healthy := true
+
+		for _, name := range names {
+			if err := checks[name](); err != nil {
+				healthy = false
+				log.Warnf("health check %s failed: %s", name, err.Error())
+				fmt.Fprintf(&b, "[-] %s: %s\n", name, err.Error())
+				continue
+			}
+			fmt.Fprintf(&b, "[+] %s ok\n", name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
@@ -0,0 +1,95 @@
+package lbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// JSON list of the VIPs that were assigned to the Service, oldest first. Maintained by StoreVIP.
+const AnnNxVIPHistory = "nexinto.com/vip-history"
+
+// The maximum number of entries kept in the VIP history.
+var MaxVIPHistory = 10
+
+// A VIP that was assigned to a Service.
+type VIPHistoryEntry struct {
+	VIP      string       `json:"vip"`
+	Provider string       `json:"provider,omitempty"`
+	Assigned metav1.Time  `json:"assigned"`
+	Released *metav1.Time `json:"released,omitempty"`
+}
+
+// Returns the VIP history of the Service, oldest first.
+func VIPHistory(service *corev1.Service) ([]VIPHistoryEntry, error) {
+	var history []VIPHistoryEntry
+
+	if service.Annotations[AnnNxVIPHistory] == "" {
+		return history, nil
+	}
+
+	if err := json.Unmarshal([]byte(service.Annotations[AnnNxVIPHistory]), &history); err != nil {
+		return nil, fmt.Errorf("invalid VIP history for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	return history, nil
+}
+
+// Record in the history of the Service that the VIP was assigned at the given time; the previous VIP is marked as released.
+// Recording an empty VIP only releases the previous one. Modifies the Service, which must not come from a cache.
+// An unparseable history is replaced.
+func RecordVIPHistory(service *corev1.Service, vip string, now time.Time) {
+	history, _ := VIPHistory(service)
+
+	t := metav1.NewTime(now)
+
+	if n := len(history); n > 0 && history[n-1].Released == nil {
+		if history[n-1].VIP == vip {
+			return
+		}
+		history[n-1].Released = &t
+	}
+
+	if vip != "" {
+		history = append(history, VIPHistoryEntry{
+			VIP:      vip,
+			Provider: service.Annotations[AnnNxVIPActiveProvider],
+			Assigned: t,
+		})
+	}
+
+	if len(history) > MaxVIPHistory {
+		history = history[len(history)-MaxVIPHistory:]
+	}
+
+	if len(history) == 0 {
+		return
+	}
+
+	data, _ := json.Marshal(history)
+
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	service.Annotations[AnnNxVIPHistory] = string(data)
+}
+
+// Returns the VIP the Service had at the given time according to its history, or "" if it had none.
+func VIPAt(service *corev1.Service, t time.Time) (string, error) {
+	history, err := VIPHistory(service)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		e := history[i]
+		if !t.Before(e.Assigned.Time) && (e.Released == nil || t.Before(e.Released.Time)) {
+			return e.VIP, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,84 @@
+package lbutil
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Callbacks invoked by EnsureVIP when a Service changes state, so providers can program their dataplane without
+// comparing annotations themselves. All callbacks are optional. They are called before the caller updates the
+// Service; if a callback fails, EnsureVIP returns the error, the Service is not updated and the transition is
+// retried later. Callbacks must therefore be idempotent.
+type Hooks struct {
+
+	// The controller claimed the Service.
+	OnClaim func(service *corev1.Service) error
+
+	// The VIP was assigned to the Service.
+	OnVIPAssigned func(service *corev1.Service, vip string) error
+
+	// The Service no longer has the VIP, because it was reset, reassigned, retired, migrated to another
+	// provider or the Service was deleted. Remove the loadbalancer configuration for the VIP here.
+	OnVIPReleased func(service *corev1.Service, vip string) error
+}
+
+// Invoke the hooks for the changes between the Service and the updated Service of the result.
+func (h *Hooks) run(service *corev1.Service, result *Result, controllerName string) error {
+	if !result.NeedsUpdate || result.Service == nil {
+		return nil
+	}
+	updated := result.Service
+
+	if h.OnClaim != nil && service.Annotations[AnnNxVIPActiveProvider] != controllerName && updated.Annotations[AnnNxVIPActiveProvider] == controllerName {
+		if err := h.OnClaim(updated); err != nil {
+			return err
+		}
+	}
+
+	before := ownVIPs(service, controllerName)
+	after := ownVIPs(updated, controllerName)
+
+	if h.OnVIPReleased != nil {
+		for _, vip := range before {
+			if !contains(after, vip) {
+				if err := h.OnVIPReleased(service, vip); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	vip := updated.Annotations[AnnNxAssignedVIP]
+	if h.OnVIPAssigned != nil && vip != "" && vip != service.Annotations[AnnNxAssignedVIP] && contains(after, vip) {
+		if err := h.OnVIPAssigned(updated, vip); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the VIP and the retiring VIPs of the Service if it is claimed by the controller.
+func ownVIPs(service *corev1.Service, controllerName string) []string {
+	if service.Annotations[AnnNxVIPActiveProvider] != controllerName {
+		return nil
+	}
+
+	var vips []string
+	if vip := service.Annotations[AnnNxAssignedVIP]; vip != "" {
+		vips = append(vips, vip)
+	}
+	if vip := service.Annotations[AnnNxAssignedSecondaryVIP]; vip != "" {
+		vips = append(vips, vip)
+	}
+
+	var retiring []RetiringVIP
+	if json.Unmarshal([]byte(service.Annotations[AnnNxRetiringVIPs]), &retiring) == nil {
+		for _, r := range retiring {
+			vips = append(vips, r.VIP)
+		}
+	}
+
+	return vips
+}
@@ -0,0 +1,33 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipaminformers "github.com/Nexinto/k8s-ipam/pkg/client/informers/externalversions"
+)
+
+// Create Clients that read from the informers of the controller's shared informer factories, so the controller
+// and the library share one cache and one watch per resource. The IpAddress and Service indexes are registered
+// with the informers and the ServiceIndexer is set. Must be called before the factories are started.
+func NewClientsWithInformers(kube kubernetes.Interface, ipamclient ipamclientset.Interface,
+	kubeInformers informers.SharedInformerFactory, ipamInformers ipaminformers.SharedInformerFactory) (*Clients, error) {
+
+	addressInformer := ipamInformers.Ipam().V1().IpAddresses()
+	if err := AddIpAddressIndexers(addressInformer.Informer()); err != nil {
+		return nil, fmt.Errorf("error adding ipaddress indexers: %w", err)
+	}
+
+	serviceInformer := kubeInformers.Core().V1().Services().Informer()
+	if err := AddServiceIndexers(serviceInformer); err != nil {
+		return nil, fmt.Errorf("error adding service indexers: %w", err)
+	}
+
+	clients := NewClients(kube, ipamclient, addressInformer.Lister())
+	clients.ServiceIndexer = serviceInformer.GetIndexer()
+
+	return clients, nil
+}
@@ -0,0 +1,208 @@
+package lbutil
+
+import (
+	"net/netip"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+// Looks up IpAddress objects. Must return a NotFound error if the object does not exist.
+type AddressGetter interface {
+	GetIpAddress(namespace, name string) (*ipamv1.IpAddress, error)
+}
+
+// Creates IpAddress objects.
+type AddressCreator interface {
+	CreateIpAddress(address *ipamv1.IpAddress) (*ipamv1.IpAddress, error)
+}
+
+// Optionally implemented by an AddressCreator to delete IpAddress objects, for example to retire old VIPs
+// (see TransferOverlap). The UID is a precondition, so a newer object with the same name is not deleted.
+type AddressDeleter interface {
+	DeleteIpAddress(namespace, name string, uid types.UID) error
+}
+
+// Updates Services.
+type ServiceUpdater interface {
+	UpdateService(service *corev1.Service) (*corev1.Service, error)
+}
+
+// Optionally implemented by a ServiceUpdater to update Services with JSON patches (see features.PatchUpdates).
+type ServicePatcher interface {
+	PatchService(namespace, name string, patch []byte) (*corev1.Service, error)
+}
+
+// Optionally implemented by a ServiceUpdater to update the status of Services (see PublishStatus).
+type ServiceStatusUpdater interface {
+	UpdateServiceStatus(service *corev1.Service) (*corev1.Service, error)
+}
+
+// Records Events for objects.
+type EventRecorder interface {
+	RecordEvent(o metav1.Object, reason, message string, warn bool) error
+}
+
+// Everything the library needs to talk to the cluster. Tests can replace any of the members with simple fakes.
+type Clients struct {
+	Addresses      AddressGetter
+	AddressCreator AddressCreator
+	Services       ServiceUpdater
+	Events         EventRecorder
+
+	// If not zero, IpAddress objects are created with a lease of this duration (see RenewLease).
+	LeaseDuration time.Duration
+
+	// If not zero, addresses stay allocated for this period after their IpAddress is deleted (see SetQuarantine).
+	QuarantinePeriod time.Duration
+
+	// The Service types that get a VIP. If empty, only NodePort Services do.
+	ServiceTypes []corev1.ServiceType
+
+	// Names IpAddress objects. If nil, they are named after their Service (NameAsService).
+	AddressNaming NamingStrategy
+
+	// Describes IpAddress objects. If nil, the description names the Service (DefaultDescription).
+	AddressDescription DescriptionStrategy
+
+	// If set, Services adopt pre-requested addresses from the warm pool before requesting new ones.
+	WarmPool *WarmPool
+
+	// If set, a VIPBinding with the state of each Service is maintained.
+	Bindings *BindingReporter
+
+	// A Service informer indexer with the VIP and UID indexes (see AddServiceIndexers). If set, Services sharing a VIP
+	// with an older Service are not reported as ready, and IpAddress objects are matched to their Services by UID.
+	ServiceIndexer cache.Indexer
+
+	// Labels and annotations copied from Services to their IpAddress objects. Optional.
+	Propagation *PropagationPolicy
+
+	// Adjusts the options of the IpAddress objects requested for Services. Optional.
+	CustomizeAddress func(service *corev1.Service, opts *AddressOptions)
+
+	// Mark the owner reference of new IpAddress objects as the controller reference, and set blockOwnerDeletion
+	// (see AddressOptions).
+	ControllerOwnerReference bool
+	BlockOwnerDeletion       bool
+
+	// What to do with an IpAddress that has the name of the Service, but belongs to a previous Service of the
+	// same name. If empty, it is replaced (MismatchRecreate).
+	AddressMismatchPolicy AddressMismatchPolicy
+
+	// What happens to the IpAddress when its Service is deleted. If empty, it is deleted (ReleasePolicyDelete).
+	// Can be overridden per Service with the release-policy annotation.
+	ReleasePolicy ReleasePolicy
+
+	// If set, addresses from IPAM outside these networks are not stored on Services; a Warning Event is recorded
+	// instead. A safety net against a misconfigured IPAM.
+	AllowedCIDRs []netip.Prefix
+
+	// Keep the VIP of every Service if IPAM changes the address of its IpAddress (see AnnNxPinVIP).
+	PinVIPs bool
+
+	// Callbacks invoked when a Service changes state. Optional.
+	Hooks *Hooks
+
+	// If set, an unclaimed Service without the vip-provider annotation is only claimed if this controller is the
+	// provider the registry selects for it. If the registry selects no provider, any controller may claim it.
+	// With the Takeover feature, Services claimed by unregistered providers are claimed again.
+	Registry *Registry
+
+	// If set, a Service is only modified by the replica holding its Lease. Other replicas leave it alone
+	// and ask to be requeued when the Lease may have expired.
+	Locker *ServiceLocker
+
+	// If not zero, changing the pool of a ready Service moves it to a new VIP make-before-break: the new VIP is
+	// assigned while the old one is kept as a retiring VIP (see RetiringVIPs) for this period, then it is released.
+	// The AddressCreator must implement AddressDeleter.
+	TransferOverlap time.Duration
+
+	// Where VIPs are published besides the assigned-vip annotation. Can be overridden per Service with the
+	// publish-mode annotation. If empty, only the annotation is set (PublishAnnotation).
+	PublishMode PublishMode
+
+	// The pools for the address families of dual-stack Services (see ServiceFamilies), for example
+	// {"IPv4": "default", "IPv6": "default-v6"}. The pool of the primary family is used for Services that don't
+	// choose a pool. A second VIP is only requested with the DualStack feature.
+	FamilyPools map[string]string
+
+	// If set, Services that ask to be processed again (see Result.RequeueAfter), for example while waiting for
+	// IPAM to assign an address, are added to this queue after the delay. This bounds how long a Service waits
+	// if an IpAddress update event is lost. Leave it unset if the caller already requeues with RequeueAfter,
+	// like the reconciler does.
+	RequeueQueue workqueue.DelayingInterface
+
+	// If set, RunWorkers delays failed keys by the policy of the limiter and the class of the error. The queue
+	// must use the same limiter, so keys are forgotten when they succeed.
+	Backoff *BackoffRateLimiter
+
+	// Set by UseCircuitBreaker.
+	breaker *CircuitBreaker
+
+	// Set by the options of Ensure.
+	pool   string
+	logger *log.Entry
+}
+
+// Create Clients backed by the clientsets and the IpAddress lister. Events are rate limited by DefaultEventLimiter.
+func NewClients(kube kubernetes.Interface, ipamclient ipamclientset.Interface, addressLister ipamlisterv1.IpAddressLister) *Clients {
+	return &Clients{
+		Addresses:      &listerAddressGetter{lister: addressLister},
+		AddressCreator: &clientAddressCreator{ipamclient: ipamclient},
+		Services:       &clientServiceUpdater{kube: kube},
+		Events:         RateLimitEvents(SinkEventRecorder(NewEventSink(kube)), DefaultEventLimiter),
+	}
+}
+
+type listerAddressGetter struct {
+	lister ipamlisterv1.IpAddressLister
+}
+
+func (g *listerAddressGetter) GetIpAddress(namespace, name string) (*ipamv1.IpAddress, error) {
+	return g.lister.IpAddresses(namespace).Get(name)
+}
+
+type clientAddressCreator struct {
+	ipamclient ipamclientset.Interface
+}
+
+func (c *clientAddressCreator) CreateIpAddress(address *ipamv1.IpAddress) (*ipamv1.IpAddress, error) {
+	return c.ipamclient.IpamV1().IpAddresses(address.Namespace).Create(address)
+}
+
+func (c *clientAddressCreator) DeleteIpAddress(namespace, name string, uid types.UID) error {
+	opts := &metav1.DeleteOptions{}
+	if uid != "" {
+		opts.Preconditions = &metav1.Preconditions{UID: &uid}
+	}
+	return c.ipamclient.IpamV1().IpAddresses(namespace).Delete(name, opts)
+}
+
+type clientServiceUpdater struct {
+	kube kubernetes.Interface
+}
+
+func (u *clientServiceUpdater) UpdateService(service *corev1.Service) (*corev1.Service, error) {
+	return u.kube.CoreV1().Services(service.Namespace).Update(service)
+}
+
+func (u *clientServiceUpdater) UpdateServiceStatus(service *corev1.Service) (*corev1.Service, error) {
+	return u.kube.CoreV1().Services(service.Namespace).UpdateStatus(service)
+}
+
+func (u *clientServiceUpdater) PatchService(namespace, name string, patch []byte) (*corev1.Service, error) {
+	return u.kube.CoreV1().Services(namespace).Patch(name, types.JSONPatchType, patch)
+}
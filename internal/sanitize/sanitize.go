@@ -0,0 +1,30 @@
+// Helpers for echoing user input (annotation values) in errors, events and logs.
+
+package sanitize
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// The maximum number of characters of a value shown in messages.
+const MaxValueLength = 64
+
+// Returns the value for use in a message: control and invalid characters are escaped and long values are shortened,
+// so malformed input cannot produce huge or garbled Events.
+func Value(s string) string {
+	truncated := false
+	if utf8.RuneCountInString(s) > MaxValueLength {
+		runes := []rune(s)
+		s = string(runes[:MaxValueLength])
+		truncated = true
+	}
+
+	quoted := strconv.Quote(s)
+	s = quoted[1 : len(quoted)-1]
+
+	if truncated {
+		s += "..."
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func FuzzValue(f *testing.F) {
+	f.Add("10.0.0.1")
+	f.Add("line\nbreak\x00\x1b[31m")
+	f.Add(strings.Repeat("ä", 100))
+	f.Add("\xff\xfe")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		v := Value(s)
+
+		if !utf8.ValidString(v) {
+			t.Errorf("invalid UTF-8 in %q", v)
+		}
+		for _, r := range v {
+			if unicode.IsControl(r) {
+				t.Errorf("control character %U in %q", r, v)
+			}
+		}
+		// Every character is escaped to at most 10 bytes (\UXXXXXXXX).
+		if len(v) > 10*MaxValueLength+len("...") {
+			t.Errorf("value of %d bytes is too long", len(v))
+		}
+	})
+}
@@ -0,0 +1,270 @@
+// Generates keepalived VRRP configuration for the assigned VIPs, for HA pairs of software loadbalancers.
+// Every VIP gets its own VRRP instance, so VIPs fail over independently.
+
+package keepalived
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/plusserver/k8s-lbutil/internal/fileutil"
+)
+
+// VRRP settings of this node.
+type Config struct {
+
+	// The interface the VIPs are configured on.
+	Interface string
+
+	// The priority of this node (1-254); the node with the highest priority holds a VIP.
+	Priority int
+
+	// The password for VRRP authentication. Optional.
+	AuthPass string
+
+	// Addresses of the other nodes, for unicast VRRP. If empty, multicast is used.
+	UnicastPeers []string
+}
+
+type instance struct {
+	Name     string
+	RouterID int
+	VIP      string
+}
+
+var configTemplate = template.Must(template.New("keepalived").Parse(`# Generated by lbutil; do not edit.
+{{- $c := .Config }}
+{{- range .Instances }}
+
+vrrp_instance {{ .Name }} {
+    state BACKUP
+    interface {{ $c.Interface }}
+    virtual_router_id {{ .RouterID }}
+    priority {{ $c.Priority }}
+    advert_int 1
+{{- if $c.AuthPass }}
+    authentication {
+        auth_type PASS
+        auth_pass {{ $c.AuthPass }}
+    }
+{{- end }}
+{{- if $c.UnicastPeers }}
+    unicast_peer {
+{{- range $c.UnicastPeers }}
+        {{ . }}
+{{- end }}
+    }
+{{- end }}
+    virtual_ipaddress {
+        {{ .VIP }}
+    }
+}
+{{- end }}
+`))
+
+// Render the configuration for the VIPs. Virtual router ids are derived from the VIPs, so a VIP keeps
+// its id when other VIPs are added or removed (unless ids collide). At most 255 VIPs are supported.
+func Render(config Config, vips []string) ([]byte, error) {
+	data, _, err := RenderWithIDs(config, vips, nil)
+	return data, err
+}
+
+// Like Render, but VIPs keep the virtual router ids in previous, so ids that were moved to resolve a
+// collision stay stable. Returns the ids of the VIPs in the configuration.
+func RenderWithIDs(config Config, vips []string, previous map[string]int) ([]byte, map[string]int, error) {
+	if config.Priority < 1 || config.Priority > 254 {
+		return nil, nil, fmt.Errorf("invalid vrrp priority %d", config.Priority)
+	}
+
+	ids, err := AssignRouterIDs(vips, previous)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for vip := range ids {
+		sorted = append(sorted, vip)
+	}
+	sort.Strings(sorted)
+
+	var instances []instance
+	for _, vip := range sorted {
+		instances = append(instances, instance{Name: fmt.Sprintf("VIP_%d", ids[vip]), RouterID: ids[vip], VIP: vip})
+	}
+
+	var buf bytes.Buffer
+	err = configTemplate.Execute(&buf, struct {
+		Config    Config
+		Instances []instance
+	}{config, instances})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), ids, nil
+}
+
+// Assign virtual router ids (1-255) to the VIPs. VIPs in previous keep their id; other VIPs get the id
+// derived from the VIP, or the next free one if it is taken.
+func AssignRouterIDs(vips []string, previous map[string]int) (map[string]int, error) {
+	sorted := append([]string(nil), vips...)
+	sort.Strings(sorted)
+
+	ids := map[string]int{}
+	used := map[int]bool{}
+	for _, vip := range sorted {
+		if id, ok := previous[vip]; ok && id >= 1 && id <= 255 && !used[id] {
+			ids[vip] = id
+			used[id] = true
+		}
+	}
+
+	for _, vip := range sorted {
+		if _, ok := ids[vip]; ok {
+			continue
+		}
+		if len(used) == 255 {
+			return nil, fmt.Errorf("too many vips for vrrp: %d", len(ids)+1)
+		}
+
+		id := routerID(vip)
+		for used[id] {
+			id = id%255 + 1
+		}
+		used[id] = true
+		ids[vip] = id
+	}
+
+	return ids, nil
+}
+
+// Read the virtual router ids of the VIPs from a configuration written by Render.
+func ParseRouterIDs(data []byte) map[string]int {
+	ids := map[string]int{}
+
+	id := 0
+	inAddresses := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "vrrp_instance":
+			id = 0
+		case fields[0] == "virtual_router_id" && len(fields) == 2:
+			id, _ = strconv.Atoi(fields[1])
+		case fields[0] == "virtual_ipaddress":
+			inAddresses = true
+		case inAddresses && fields[0] == "}":
+			inAddresses = false
+		case inAddresses && id != 0:
+			ids[fields[0]] = id
+		}
+	}
+
+	return ids
+}
+
+// A stable virtual router id (1-255) for the VIP.
+func routerID(vip string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(vip))
+	return int(h.Sum32()%255) + 1
+}
+
+// Keeps the keepalived configuration in sync with the assigned VIPs.
+type Manager struct {
+	Config Config
+
+	// The configuration file.
+	Path string
+
+	// The command that reloads keepalived, for example {"systemctl", "reload", "keepalived"}. Optional.
+	ReloadCommand []string
+
+	// Called instead of ReloadCommand if set.
+	Reload func() error
+
+	lock sync.Mutex
+
+	// The virtual router ids in the current configuration; read from Path on the first Sync, so the
+	// ids survive restarts.
+	ids map[string]int
+}
+
+// Write the configuration for the VIPs if it changed and reload keepalived. Returns true if the configuration changed.
+func (m *Manager) Sync(vips []string) (changed bool, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.ids == nil {
+		current, err := ioutil.ReadFile(m.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		m.ids = ParseRouterIDs(current)
+	}
+
+	config, ids, err := RenderWithIDs(m.Config, vips, m.ids)
+	if err != nil {
+		return false, err
+	}
+
+	if fileutil.SameContent(m.Path, config) {
+		m.ids = ids
+		return false, nil
+	}
+
+	if err := fileutil.WriteFileAtomic(m.Path, config); err != nil {
+		return false, err
+	}
+	m.ids = ids
+
+	log.WithField("path", m.Path).Info("keepalived configuration changed; reloading")
+
+	return true, m.reload()
+}
+
+// Sync the VIPs returned by vips every interval until stopCh is closed.
+func (m *Manager) Run(vips func() ([]string, error), interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		list, err := vips()
+		if err != nil {
+			log.Errorf("error listing vips: %s", err.Error())
+			return
+		}
+		if _, err := m.Sync(list); err != nil {
+			log.Errorf("error updating keepalived configuration: %s", err.Error())
+		}
+	}, interval, stopCh)
+}
+
+func (m *Manager) reload() error {
+	if m.Reload != nil {
+		return m.Reload()
+	}
+	if len(m.ReloadCommand) == 0 {
+		return nil
+	}
+
+	out, err := exec.Command(m.ReloadCommand[0], m.ReloadCommand[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error reloading keepalived: %w: %s", err, string(out))
+	}
+	return nil
+}
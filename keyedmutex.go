@@ -0,0 +1,64 @@
+package lbutil
+
+import (
+	"sync"
+)
+
+// A set of mutexes identified by keys, for example Service keys (namespace/name). Entries are removed
+// when they are no longer used, so the set does not grow with the number of keys ever locked.
+type KeyedMutex struct {
+	lock  sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	users int
+}
+
+// Create an empty KeyedMutex.
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: map[string]*keyedLock{}}
+}
+
+// Locks Service keys for RunWorkers, shared by all runners in the process, so a Service is never processed
+// by two workers at the same time even if it is enqueued in several queues.
+var ServiceLocks = NewKeyedMutex()
+
+// Lock the key, waiting until it is unlocked.
+func (m *KeyedMutex) Lock(key string) {
+	m.lock.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.users++
+	m.lock.Unlock()
+
+	l.Lock()
+}
+
+// Unlock the key. Unlocking a key that is not locked panics.
+func (m *KeyedMutex) Unlock(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	l, ok := m.locks[key]
+	if !ok {
+		panic("unlock of unlocked key " + key)
+	}
+	l.users--
+	if l.users == 0 {
+		delete(m.locks, key)
+	}
+	l.Unlock()
+}
+
+// Run f with the key locked.
+func (m *KeyedMutex) Do(key string, f func() error) error {
+	m.Lock(key)
+	defer m.Unlock(key)
+
+	return f()
+}
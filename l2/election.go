@@ -0,0 +1,150 @@
+package l2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The default leader election timings, the same as those of kube-controller-manager.
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Elects one node per VIP (using a coordination.k8s.io Lease per VIP) and lets the Responder of
+// the elected node answer for it.
+type Elector struct {
+	Kube      kubernetes.Interface
+	Responder *Responder
+
+	// The namespace for the Leases.
+	Namespace string
+
+	// Identifies this node, usually the node name.
+	Identity string
+
+	// The leader election timings. Zero values are replaced by DefaultLeaseDuration, DefaultRenewDeadline
+	// and DefaultRetryPeriod.
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	lock      sync.Mutex
+	elections map[string]context.CancelFunc
+}
+
+// Take part in the elections for exactly the given VIPs. Elections for VIPs not in the list are stopped,
+// releasing the VIP if this node is the leader.
+func (e *Elector) Sync(vips []string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(vips) > 0 {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+
+	if e.elections == nil {
+		e.elections = map[string]context.CancelFunc{}
+	}
+
+	want := map[string]bool{}
+	for _, vip := range vips {
+		want[vip] = true
+		if _, ok := e.elections[vip]; !ok {
+			ctx, cancel := context.WithCancel(context.Background())
+			e.elections[vip] = cancel
+			go e.run(ctx, vip)
+		}
+	}
+
+	for vip, cancel := range e.elections {
+		if !want[vip] {
+			cancel()
+			delete(e.elections, vip)
+		}
+	}
+
+	return nil
+}
+
+// Stop all elections.
+func (e *Elector) Stop() {
+	_ = e.Sync(nil)
+}
+
+// Fill in the default timings and check them, so RunOrDie does not panic.
+func (e *Elector) validate() error {
+	if e.LeaseDuration == 0 {
+		e.LeaseDuration = DefaultLeaseDuration
+	}
+	if e.RenewDeadline == 0 {
+		e.RenewDeadline = DefaultRenewDeadline
+	}
+	if e.RetryPeriod == 0 {
+		e.RetryPeriod = DefaultRetryPeriod
+	}
+
+	if e.LeaseDuration <= e.RenewDeadline {
+		return fmt.Errorf("lease duration %s must be greater than the renew deadline %s", e.LeaseDuration, e.RenewDeadline)
+	}
+	if e.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(e.RetryPeriod)) {
+		return fmt.Errorf("renew deadline %s must be greater than %v times the retry period %s", e.RenewDeadline, leaderelection.JitterFactor, e.RetryPeriod)
+	}
+	if e.Kube == nil || e.Responder == nil || e.Identity == "" {
+		return fmt.Errorf("elector needs Kube, Responder and Identity")
+	}
+
+	return nil
+}
+
+// Name of the Lease for the VIP.
+func LeaseName(vip string) string {
+	return "vip-" + strings.Replace(vip, ":", "-", -1)
+}
+
+func (e *Elector) run(ctx context.Context, vip string) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: e.Namespace, Name: LeaseName(vip)},
+		Client:     e.Kube.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.Identity},
+	}
+
+	logger := log.WithField("vip", vip)
+
+	// RunOrDie returns when leadership is lost; keep competing until the election is stopped.
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   e.LeaseDuration,
+			RenewDeadline:   e.RenewDeadline,
+			RetryPeriod:     e.RetryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) {
+					logger.Info("elected; answering for vip")
+					if err := e.Responder.Add(vip); err != nil {
+						logger.Errorf("error announcing vip: %s", err.Error())
+					}
+				},
+				OnStoppedLeading: func() {
+					logger.Info("no longer leader; releasing vip")
+					e.Responder.Remove(vip)
+				},
+			},
+		})
+	}
+}
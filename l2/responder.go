@@ -0,0 +1,211 @@
+// Answers ARP (IPv4) and NDP (IPv6) requests for VIPs on a network interface, so a node can receive
+// traffic for the VIPs without an external loadbalancer. Only one node may answer for a VIP at a time;
+// use an Elector to decide which.
+
+package l2
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/mdlayher/arp"
+	"github.com/mdlayher/ethernet"
+	"github.com/mdlayher/ndp"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+// Answers ARP and NDP requests for the active VIPs.
+type Responder struct {
+	ifi *net.Interface
+	arp *arp.Client
+	ndp *ndp.Conn
+
+	lock sync.RWMutex
+	vips map[string]net.IP
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Create a Responder on the network interface.
+func NewResponder(ifname string) (*Responder, error) {
+	ifi, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find interface %s: %w", ifname, err)
+	}
+
+	arpClient, err := arp.Dial(ifi)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for ARP on %s: %w", ifname, err)
+	}
+
+	ndpConn, _, err := ndp.Listen(ifi, ndp.LinkLocal)
+	if err != nil {
+		arpClient.Close()
+		return nil, fmt.Errorf("cannot listen for NDP on %s: %w", ifname, err)
+	}
+
+	return &Responder{
+		ifi:    ifi,
+		arp:    arpClient,
+		ndp:    ndpConn,
+		vips:   map[string]net.IP{},
+		closed: make(chan struct{}),
+	}, nil
+}
+
+// Start answering for the VIP and announce it with a gratuitous ARP or an unsolicited neighbor advertisement.
+func (r *Responder) Add(vip string) error {
+	addr, err := addresses.Parse(vip)
+	if err != nil {
+		return err
+	}
+	ip := net.IP(addr.AsSlice())
+
+	r.lock.Lock()
+	r.vips[addr.String()] = ip
+	r.lock.Unlock()
+
+	if addr.Is4() {
+		return r.gratuitousARP(ip)
+	}
+
+	group, err := ndp.SolicitedNodeMulticast(ip)
+	if err != nil {
+		return err
+	}
+	if err := r.ndp.JoinGroup(group); err != nil {
+		return fmt.Errorf("cannot join multicast group for %s: %w", vip, err)
+	}
+
+	return r.advertise(ip, net.IPv6linklocalallnodes, false)
+}
+
+// Stop answering for the VIP.
+func (r *Responder) Remove(vip string) {
+	addr, err := addresses.Parse(vip)
+	if err != nil {
+		return
+	}
+
+	r.lock.Lock()
+	delete(r.vips, addr.String())
+	r.lock.Unlock()
+
+	if addr.Is6() {
+		if group, err := ndp.SolicitedNodeMulticast(net.IP(addr.AsSlice())); err == nil {
+			_ = r.ndp.LeaveGroup(group)
+		}
+	}
+}
+
+// Returns true if the Responder answers for the address.
+func (r *Responder) Has(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	_, ok = r.vips[addr.Unmap().String()]
+	return ok
+}
+
+// Answer requests until stopCh is closed.
+func (r *Responder) Run(stopCh <-chan struct{}) {
+	go r.serveARP()
+	go r.serveNDP()
+
+	<-stopCh
+	r.Close()
+}
+
+// Stop answering requests.
+func (r *Responder) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		r.arp.Close()
+		r.ndp.Close()
+	})
+}
+
+func (r *Responder) serveARP() {
+	for {
+		pkt, _, err := r.arp.Read()
+		if err != nil {
+			if r.isClosed(err) {
+				return
+			}
+			// A malformed packet on the wire must not stop the responder.
+			log.Debugf("error reading ARP packet: %s", err.Error())
+			continue
+		}
+
+		if pkt.Operation != arp.OperationRequest || !r.Has(pkt.TargetIP) {
+			continue
+		}
+
+		if err := r.arp.Reply(pkt, r.ifi.HardwareAddr, pkt.TargetIP); err != nil {
+			log.WithField("vip", pkt.TargetIP.String()).Errorf("error sending ARP reply: %s", err.Error())
+		}
+	}
+}
+
+func (r *Responder) serveNDP() {
+	for {
+		msg, _, from, err := r.ndp.ReadFrom()
+		if err != nil {
+			if r.isClosed(err) {
+				return
+			}
+			log.Debugf("error reading NDP message: %s", err.Error())
+			continue
+		}
+
+		ns, ok := msg.(*ndp.NeighborSolicitation)
+		if !ok || !r.Has(ns.TargetAddress) {
+			continue
+		}
+
+		if err := r.advertise(ns.TargetAddress, from, true); err != nil {
+			log.WithField("vip", ns.TargetAddress.String()).Errorf("error sending neighbor advertisement: %s", err.Error())
+		}
+	}
+}
+
+func (r *Responder) gratuitousARP(ip net.IP) error {
+	pkt, err := arp.NewPacket(arp.OperationReply, r.ifi.HardwareAddr, ip, ethernet.Broadcast, ip)
+	if err != nil {
+		return err
+	}
+	return r.arp.WriteTo(pkt, ethernet.Broadcast)
+}
+
+func (r *Responder) advertise(ip, to net.IP, solicited bool) error {
+	na := &ndp.NeighborAdvertisement{
+		Solicited:     solicited,
+		Override:      true,
+		TargetAddress: ip,
+		Options: []ndp.Option{
+			&ndp.LinkLayerAddress{Direction: ndp.Target, Addr: r.ifi.HardwareAddr},
+		},
+	}
+	return r.ndp.WriteTo(na, nil, to)
+}
+
+// Returns true if the read failed because the Responder was closed. Other errors, such as packets that
+// cannot be parsed, are not fatal.
+func (r *Responder) isClosed(err error) bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+	}
+	return errors.Is(err, net.ErrClosed)
+}
@@ -4,6 +4,7 @@ package lbutil
 
 import (
 	"fmt"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -18,6 +19,9 @@ import (
 	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
 	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
 	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+
+	"github.com/plusserver/k8s-lbutil/features"
+	"github.com/plusserver/k8s-lbutil/patch"
 )
 
 const (
@@ -31,15 +35,92 @@ const (
 	// This will be the VIP chosen for the service.
 	AnnNxAssignedVIP = "nexinto.com/assigned-vip"
 
+	// If set to any value, the Service gets no VIP, even if the req-vip annotation is not required.
+	// A VIP the Service already has is kept, so adding the annotation never takes the VIP of a Service away.
+	AnnNxNoVIP = "nexinto.com/no-vip"
+
+	// If set to any value, EnsureVIP does not change anything for the Service, for example during maintenance.
+	// The value can explain why.
+	AnnNxPaused = "nexinto.com/paused"
+
+	// If set to any value, the VIP is immutable once assigned: if IPAM changes the address of the IpAddress,
+	// the Service keeps its VIP and a Warning Event is recorded until an operator intervenes, for example
+	// with the reassign annotation.
+	AnnNxPinVIP = "nexinto.com/pin-vip"
+
 	// Set this to explicitly choose a VIP provider.
 	AnnNxVIPProvider = "nexinto.com/vip-provider"
 
 	// The active provider for this VIP.
 	AnnNxVIPActiveProvider = "nexinto.com/vip-active-provider"
+
+	// Set this to request a specific address for the VIP.
+	AnnNxRequestedIP = "nexinto.com/requested-ip"
+
+	// Set this to choose the address pool the VIP is allocated from.
+	AnnNxVIPPool = "nexinto.com/vip-pool"
+
+	// The version of the annotation schema used by the Service (see the annotations package).
+	AnnNxLBConfigVersion = "nexinto.com/lb-config-version"
+
+	// Set this on an IpAddress to allow deleting it while its Service still uses it.
+	AnnNxForceDelete = "nexinto.com/force-delete"
+)
+
+// Machine-readable reasons for the Events created by this library.
+const (
+
+	// A VIP was assigned to the Service.
+	ReasonVIPAssigned = "VIPAssigned"
+
+	// The assigned VIP changed or was reset.
+	ReasonVIPChanged = "VIPChanged"
+
+	// An IpAddress was requested for the Service.
+	ReasonAddressRequested = "AddressRequested"
+
+	// The IpAddress for the Service was deleted.
+	ReasonAddressLost = "AddressLost"
+
+	// The Service is claimed by a different provider.
+	ReasonClaimConflict = "ClaimConflict"
+
+	// IPAM did not assign an address in time.
+	ReasonIPAMTimeout = "IPAMTimeout"
+
+	// IPAM assigned an address outside the allowed networks.
+	ReasonAddressRejected = "AddressRejected"
+
+	// Requests to IPAM failed repeatedly and are suspended (see CircuitBreaker).
+	ReasonIPAMUnavailable = "IPAMUnavailable"
+
+	// The VIP is also used by another Service.
+	ReasonVIPConflict = "VIPConflict"
+
+	// The VIP of a deleted Service was released.
+	ReasonVIPReleased = "VIPReleased"
+
+	// IPAM changed the address of a pinned VIP.
+	ReasonVIPPinned = "VIPPinned"
+
+	// Reconciliation of the Service was paused.
+	ReasonPaused = "Paused"
+
+	// Configuring the loadbalancer failed.
+	ReasonFailed = "Failed"
+
+	// The Service was claimed by a provider that is no longer registered and was taken over.
+	ReasonTakenOver = "TakenOver"
 )
 
-// Create an event for an object.
-func MakeEvent(kube kubernetes.Interface, o metav1.Object, message string, warn bool) error {
+// Create an event for an object. The reason should be one of the Reason constants.
+// The Kind of the involved object is inferred if o is a runtime.Object (like all API types).
+func MakeEvent(kube kubernetes.Interface, o metav1.Object, reason, message string, warn bool) error {
+	return MakeEventTo(NewEventSink(kube), o, reason, message, warn)
+}
+
+// Like MakeEvent, but the event is stored by the sink.
+func MakeEventTo(sink EventSink, o metav1.Object, reason, message string, warn bool) error {
 	var t string
 	if warn {
 		t = "Warning"
@@ -50,30 +131,45 @@ func MakeEvent(kube kubernetes.Interface, o metav1.Object, message string, warn
 	event := &corev1.Event{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: o.GetName(),
+			Namespace:    o.GetNamespace(),
 		},
-		InvolvedObject: corev1.ObjectReference{
-			Name:            o.GetName(),
-			Namespace:       o.GetNamespace(),
-			APIVersion:      "v1",
-			UID:             o.GetUID(),
-			Kind:            "IpAddress",
-			ResourceVersion: o.GetResourceVersion(),
-		},
+		InvolvedObject: involvedObject(o),
+		Reason:         reason,
 		Message:        message,
 		FirstTimestamp: metav1.Now(),
 		LastTimestamp:  metav1.Now(),
 		Type:           t,
 	}
 
-	_, err := kube.CoreV1().Events(o.GetNamespace()).Create(event)
+	_, err := sink.CreateEvent(event)
 	return err
 }
 
 // Create a Warning Event for the object and also return it as an error.
-func LogEventAndFail(kube kubernetes.Interface, o metav1.Object, message string) error {
+func LogEventAndFail(kube kubernetes.Interface, o metav1.Object, reason, message string) error {
+	return LogEventAndFailTo(NewEventSink(kube), o, reason, message)
+}
+
+// Like LogEventAndFail, but the event is stored by the sink.
+func LogEventAndFailTo(sink EventSink, o metav1.Object, reason, message string) error {
 	log.Error(message)
-	_ = MakeEvent(kube, o, message, true)
-	return fmt.Errorf(message)
+	_ = MakeEventTo(sink, o, reason, message, true)
+	return fmt.Errorf("%s", message)
+}
+
+// Like LogEventAndFail, but the returned error wraps the cause, so callers can still inspect it with errors.As
+// or the functions of this package that look into wrapped errors, like IsNotFound and IsConflict. The apierrors
+// functions do not recognize the wrapped cause; pass the error through APIStatusError first.
+func LogEventAndFailWithCause(kube kubernetes.Interface, o metav1.Object, reason, message string, cause error) error {
+	return LogEventAndFailWithCauseTo(NewEventSink(kube), o, reason, message, cause)
+}
+
+// Like LogEventAndFailWithCause, but the event is stored by the sink.
+func LogEventAndFailWithCauseTo(sink EventSink, o metav1.Object, reason, message string, cause error) error {
+	err := fmt.Errorf("%s: %w", message, cause)
+	log.Error(err.Error())
+	_ = MakeEventTo(sink, o, reason, err.Error(), true)
+	return err
 }
 
 // Checks if the service is annotated with a valid VIP, if not, work towards that. Return value ok is true if the service / its
@@ -85,181 +181,218 @@ func LogEventAndFail(kube kubernetes.Interface, o metav1.Object, message string)
 func EnsureVIP(kube kubernetes.Interface, ipamclient ipamclientset.Interface, addressLister ipamlisterv1.IpAddressLister,
 	service *corev1.Service, controllerName string, requireAnnotation bool) (ok bool, needsUpdate bool, newservice *corev1.Service, err error) {
 
-	if service.Spec.Type != corev1.ServiceTypeNodePort {
-		log.Debugf("skipping '%s-%s': not a NodePort", service.Namespace, service.Name)
-		return false, false, nil, nil
-	}
+	result, err := EnsureVIPResult(kube, ipamclient, addressLister, service, controllerName, requireAnnotation)
 
-	if requireAnnotation && service.Annotations[AnnNxReqVIP] == "" {
-		log.Debugf("skipping '%s-%s': REQUIRE_TAG is true and service does not have our annotation", service.Namespace, service.Name)
-		return false, false, nil, nil
-	}
+	return result.Ready(), result.NeedsUpdate, result.Service, err
+}
 
-	if service.Annotations[AnnNxVIPProvider] != "" && service.Annotations[AnnNxVIPProvider] != controllerName {
-		log.Debugf("skipping '%s-%s': service requests provider '%s'", service.Namespace, service.Name, service.Annotations[AnnNxVIPProvider])
-		return false, false, nil, nil
-	}
+// Create a new IpAddress Object for a Service.
+func RequestAddress(kube kubernetes.Interface, ipamclient ipamclientset.Interface, service *corev1.Service) error {
+	return NewClients(kube, ipamclient, nil).RequestAddress(service)
+}
 
-	if service.Annotations[AnnNxVIPActiveProvider] != "" && service.Annotations[AnnNxVIPActiveProvider] != controllerName {
-		log.Debugf("skipping '%s-%s': service is managed by provider '%s'", service.Namespace, service.Name, service.Annotations[AnnNxVIPActiveProvider])
-		return false, false, nil, nil
-	}
+// Like RequestAddress, using the Clients.
+func (c *Clients) RequestAddress(service *corev1.Service) error {
+	return c.requestAddress(service, c.addressName(service))
+}
 
-	if service.Annotations[AnnNxVIPActiveProvider] == "" {
+// Create an IpAddress object with the name for the Service.
+func (c *Clients) requestAddress(service *corev1.Service, name string) error {
+	addr := NewIpAddress(service, name, c.AddressOptions(service))
 
-		log.Debugf("trying to claim the service")
+	_, err := c.AddressCreator.CreateIpAddress(addr)
+	if errors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot create ip address request for service '%s/%s': %w: %s", service.Namespace, service.Name, ErrAddressReleasing, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create ip address request for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
 
-		// Try to claim the service
-		newservice := service.DeepCopy()
-		if newservice.Annotations == nil {
-			newservice.Annotations = map[string]string{}
-		}
-		newservice.Annotations[AnnNxVIPActiveProvider] = controllerName
+	c.serviceLogger(service).Info("created ip address request")
 
-		return false, true, newservice, nil
-	}
+	return nil
+}
 
-	addr, addrLookupErr := addressLister.IpAddresses(service.Namespace).Get(service.Name)
-	if err != nil && !errors.IsNotFound(addrLookupErr) {
-		// General error getting the address. NotFound is handled below depending on context.
-		return false, false, nil, fmt.Errorf("error looking up ipaddress object for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
-	}
+// Returns a copy of the Service with the VIP stored and records an Event.
+func StoreVIP(vip string, kube kubernetes.Interface, service *corev1.Service) *corev1.Service {
+	return NewClients(kube, nil, nil).StoreVIP(vip, service)
+}
 
-	if service.Annotations[AnnNxAssignedVIP] == "" {
-		// A VIP is not yet set for the Service.
+// Like StoreVIP, using the Clients.
+func (c *Clients) StoreVIP(vip string, service *corev1.Service) *corev1.Service {
+	o2 := service.DeepCopy()
+	o2.Annotations[AnnNxAssignedVIP] = vip
+	RecordVIPHistory(o2, vip, time.Now())
 
-		if errors.IsNotFound(addrLookupErr) {
-			log.Debugf("no address for '%s-%s' exists", service.Namespace, service.Name)
-			return false, false, nil, RequestAddress(kube, ipamclient, service)
-		}
+	c.serviceLogger(service).WithField(LogFieldVIP, vip).Debug("storing assigned VIP")
+	reason := ReasonVIPAssigned
+	if vip == "" {
+		reason = ReasonVIPChanged
+	}
+	_ = c.Events.RecordEvent(service, reason, fmt.Sprintf("assigned VIP %s", vip), false)
 
-		if addr.Status.Address == "" {
-			log.Debugf("ip address '%s-%s' has no address yet", addr.Namespace, addr.Name)
-			return false, false, nil, nil
-		}
+	return o2
+}
 
-		newservice := StoreVIP(addr.Status.Address, kube, service)
+// If an IP address object changes and a Service is an owner, wake up that Service.
+func IpAddressCreatedOrUpdated(serviceQueue workqueue.RateLimitingInterface, address *ipamv1.IpAddress) {
+	NewClients(nil, nil, nil).IpAddressCreatedOrUpdated(serviceQueue, address)
+}
 
-		return true, true, newservice, nil
+// Like IpAddressCreatedOrUpdated, using the Clients. If the ServiceIndexer is set, only the Service
+// with the owner UID is woken up, not a recreated Service with the same name.
+func (c *Clients) IpAddressCreatedOrUpdated(serviceQueue workqueue.RateLimitingInterface, address *ipamv1.IpAddress) {
+	if address.Status.Address == "" {
+		return
 	}
 
-	if errors.IsNotFound(addrLookupErr) {
-		// The IP address object for our service has somehow disappeared. Reset the stored address
-		// and restart the process.
-		log.Infof("assigned IP address for service '%s-%s' has disappeared (was %s)", service.Namespace, service.Name, service.Annotations[AnnNxAssignedVIP])
-		newservice := StoreVIP("", kube, service)
-		return false, true, newservice, err
+	if c.ServiceIndexer != nil && !retained(address) {
+		for _, uid := range addressOwnerUIDs(address) {
+			service, err := ServiceByUID(c.ServiceIndexer, uid)
+			if err != nil {
+				c.addressLogger(address).Errorf("error looking up owning service: %s", err.Error())
+				continue
+			}
+			if service != nil {
+				serviceQueue.Add(fmt.Sprintf("%s/%s", service.Namespace, service.Name))
+			}
+		}
+		return
 	}
 
-	if addr.Status.Address != service.Annotations[AnnNxAssignedVIP] {
-		// The IP address has changed. Set the new address and continue.
-		log.Infof("assigned IP address for service '%s-%s' has changed (from %s to %s)", service.Namespace, service.Name, addr.Status.Address, service.Annotations[AnnNxAssignedVIP])
-		newservice := StoreVIP("", kube, service)
-		return true, true, newservice, err
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind == "Service" {
+			serviceQueue.Add(fmt.Sprintf("%s/%s", address.Namespace, ref.Name))
+		}
 	}
 
-	return true, needsUpdate, service, nil
+	if namespace, name, ok := adoptedServiceKey(address); ok {
+		serviceQueue.Add(fmt.Sprintf("%s/%s", namespace, name))
+	}
 }
 
-// Create a new IpAddress Object for a Service.
-func RequestAddress(kube kubernetes.Interface, ipamclient ipamclientset.Interface, service *corev1.Service) error {
-	addr := ipamv1.IpAddress{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      service.Name,
-			Namespace: service.Namespace,
-			OwnerReferences: []metav1.OwnerReference{{
-				Name:       service.GetName(),
-				Kind:       "Service",
-				APIVersion: "v1",
-				UID:        service.GetUID(),
-			}},
-		},
-		Spec: ipamv1.IpAddressSpec{
-			Description: fmt.Sprintf("created for service %s", service.Name),
-		},
-	}
+// If an IP address is deleted and a Service is the owner and it still exists, remove
+// the VIP annotation and wake up the service so the service can retry requesting loadbalancing.
+// The Service is looked up in the namespace of the address and must have the UID from the
+// label or the owner reference; a Service with the same name but a different UID is left alone.
+// Paused Services are left alone as well; EnsureVIP notices the missing address when they are resumed.
+func IpAddressDeleted(kubernetes kubernetes.Interface, serviceLister corelisterv1.ServiceLister, address *ipamv1.IpAddress) error {
+	return NewClients(kubernetes, nil, nil).IpAddressDeleted(serviceLister, address)
+}
 
-	_, err := ipamclient.IpamV1().IpAddresses(service.Namespace).Create(&addr)
+// Like IpAddressDeleted, using the Clients. If the ServiceIndexer is set, the owning Service is looked up by UID.
+func (c *Clients) IpAddressDeleted(serviceLister corelisterv1.ServiceLister, address *ipamv1.IpAddress) error {
+	services, err := c.addressOwners(serviceLister, address)
 	if err != nil {
-		return fmt.Errorf("failed to create ip address request for service '%s-%s': %s", service.Namespace, service.Name, err.Error())
+		return err
 	}
 
-	log.Infof("created ip address request for '%s-%s'", service.Namespace, service.Name)
+	for _, service := range services {
+		if service.Annotations[AnnNxPaused] != "" {
+			c.serviceLogger(service).WithField(LogFieldIpAddress, address.Name).Debug("ipaddress was deleted; service is paused")
+			continue
+		}
+		vip := service.Annotations[AnnNxAssignedVIP]
+		if vip != "" && address.Status.Address != "" && address.Status.Address != vip {
+			// Not the current VIP, for example a retired one (see TransferOverlap).
+			continue
+		}
+		if vip != "" {
+			c.serviceLogger(service).WithField(LogFieldIpAddress, address.Name).Debug("ipaddress was deleted; resetting service")
+			if err := c.resetVIP(service); err != nil {
+				return err
+			}
+		}
+	}
 
 	return nil
 }
 
-func StoreVIP(vip string, kube kubernetes.Interface, service *corev1.Service) *corev1.Service {
-	o2 := service.DeepCopy()
-	o2.Annotations[AnnNxAssignedVIP] = vip
+// Remove the stored VIP from the Service. Uses a patch if the PatchUpdates feature is enabled and the
+// ServiceUpdater supports it. The OnVIPReleased hook is invoked first, like for the transitions of EnsureVIP.
+func (c *Clients) resetVIP(service *corev1.Service) error {
+	if vip := service.Annotations[AnnNxAssignedVIP]; vip != "" && c.Hooks != nil && c.Hooks.OnVIPReleased != nil {
+		if err := c.Hooks.OnVIPReleased(service, vip); err != nil {
+			return err
+		}
+	}
 
-	log.Debugf("storing assigned VIP '%s' for service '%s-%s'", vip, service.Namespace, service.Name)
-	_ = MakeEvent(kube, service, fmt.Sprintf("assigned VIP %s", vip), false)
+	if patcher, ok := c.Services.(ServicePatcher); ok && features.Enabled(features.PatchUpdates) {
+		data, err := patch.New().
+			TestResourceVersion(service).
+			AddAnnotation(service, AnnNxAssignedVIP, "").
+			Build()
+		if err != nil {
+			return err
+		}
+		_, err = patcher.PatchService(service.Namespace, service.Name, data)
+		return err
+	}
 
-	return o2
+	newService := service.DeepCopy()
+	newService.Annotations[AnnNxAssignedVIP] = ""
+	_, err := c.Services.UpdateService(newService)
+	return err
 }
 
-// If an IP address object changes and a Service is an owner, wake up that Service.
-func IpAddressCreatedOrUpdated(serviceQueue workqueue.RateLimitingInterface, address *ipamv1.IpAddress) {
-	if address.Status.Address != "" {
-		for _, ref := range address.OwnerReferences {
-			if ref.Kind == "Service" {
-				serviceQueue.Add(fmt.Sprintf("%s/%s", address.Namespace, ref.Name))
+// Returns the existing Services owning the IpAddress.
+func (c *Clients) addressOwners(serviceLister corelisterv1.ServiceLister, address *ipamv1.IpAddress) ([]*corev1.Service, error) {
+	var services []*corev1.Service
+
+	if c.ServiceIndexer != nil && !retained(address) {
+		for _, uid := range addressOwnerUIDs(address) {
+			service, err := ServiceByUID(c.ServiceIndexer, uid)
+			if err != nil {
+				return nil, err
+			}
+			if service != nil {
+				services = append(services, service)
 			}
 		}
+		return services, nil
 	}
-}
 
-// If an IP address is deleted and a Service is the owner and it still exists, remove
-// the VIP annotation and wake up the service so the service can retry requesting loadbalancing.
-func IpAddressDeleted(kubernetes kubernetes.Interface, serviceLister corelisterv1.ServiceLister, address *ipamv1.IpAddress) error {
+	ownerUID := AddressOwnerUID(address)
+
 	for _, ref := range address.OwnerReferences {
 		if ref.Kind == "Service" && ref.APIVersion == "v1" {
-			service, err := serviceLister.Services(metav1.NamespaceAll).Get(ref.Name)
+			// Owner references are namespace-local, so the Service must be in the namespace of the address.
+			service, err := serviceLister.Services(address.Namespace).Get(ref.Name)
 			if err != nil {
 				if errors.IsNotFound(err) {
 					continue
 				} else {
-					return err
+					return nil, err
 				}
 			}
-			if service.Annotations[AnnNxAssignedVIP] != "" {
-				log.Debugf("ipaddress '%s-%s' was deleted; resetting service '%s-%s'", address.Namespace, address.Name, address.Namespace, service.Name)
-				newService := service.DeepCopy()
-				newService.Annotations[AnnNxAssignedVIP] = ""
-				_, err = kubernetes.CoreV1().Services(newService.Namespace).Update(newService)
-				if err != nil {
-					return err
-				}
+			uid := ownerUID
+			if uid == "" {
+				uid = ref.UID
+			}
+			if uid != "" && service.UID != uid {
+				c.serviceLogger(service).WithField(LogFieldIpAddress, address.Name).Debug("ipaddress was deleted; service has a different UID, ignoring")
+				continue
 			}
+			services = append(services, service)
 		}
 	}
 
-	return nil
-}
-
-// Simulates the behaviour of the ipam controller.
-func SimIPAM(ipamclient ipamclientset.Interface) error {
-	i := 1
-
-	addrs, err := ipamclient.IpamV1().IpAddresses(metav1.NamespaceAll).List(metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
-
-	for _, addr := range addrs.Items {
-		if addr.Status.Address == "" {
-			addr.Status.Address = fmt.Sprintf("10.0.0.%d", i)
-			i++
-			_, err := ipamclient.IpamV1().IpAddresses(addr.Namespace).Update(&addr)
-
-			log.Debugf("[simIPAM] assign: %s/%s -> %s", addr.Namespace, addr.Name, addr.Status.Address)
-
-			if err != nil {
-				return err
-			}
+	if namespace, name, ok := adoptedServiceKey(address); ok {
+		service, err := serviceLister.Services(namespace).Get(name)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && (service.UID == ownerUID || retained(address)) {
+			services = append(services, service)
 		}
 	}
 
-	return nil
+	return services, nil
+}
+
+// Returns true if the IpAddress was retained for the Service of the same name in its namespace (see
+// ReleasePolicyRetain). It belongs to that Service even if the UID label is the one of a previous Service.
+func retained(address *ipamv1.IpAddress) bool {
+	namespace, _, ok := adoptedServiceKey(address)
+	return ok && namespace == address.Namespace
 }
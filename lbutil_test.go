@@ -0,0 +1,157 @@
+package lbutil
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+func TestAddressOwners(t *testing.T) {
+	serviceA := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "web", UID: "uid-a"}}
+	serviceB := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "web", UID: "uid-b"}}
+
+	ownerRef := func(uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "v1", Kind: "Service", Name: "web", UID: uid}}
+	}
+
+	tests := []struct {
+		name    string
+		indexed bool
+		address *ipamv1.IpAddress
+		want    []string
+	}{
+		{
+			name: "owner reference in the namespace of the address",
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "web", OwnerReferences: ownerRef("uid-a"),
+			}},
+			want: []string{"a/web"},
+		},
+		{
+			name: "owner reference of a previous service with the same name",
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "web", OwnerReferences: ownerRef("uid-old"),
+			}},
+		},
+		{
+			name: "UID label overrides the owner reference",
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "web", OwnerReferences: ownerRef("uid-a"),
+				Labels: map[string]string{LabelNxServiceUID: "uid-old"},
+			}},
+		},
+		{
+			name: "adopted by the service in another namespace",
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "pool-1",
+				Labels: map[string]string{
+					LabelNxServiceUID:       "uid-b",
+					LabelNxServiceNamespace: "b",
+					LabelNxServiceName:      "web",
+				},
+			}},
+			want: []string{"b/web"},
+		},
+		{
+			name: "adopted by a previous service in another namespace",
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "pool-1",
+				Labels: map[string]string{
+					LabelNxServiceUID:       "uid-old",
+					LabelNxServiceNamespace: "b",
+					LabelNxServiceName:      "web",
+				},
+			}},
+		},
+		{
+			name: "retained for the service with the same name",
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "web",
+				Labels: map[string]string{
+					LabelNxServiceUID:       "uid-old",
+					LabelNxServiceNamespace: "a",
+					LabelNxServiceName:      "web",
+				},
+			}},
+			want: []string{"a/web"},
+		},
+		{
+			name:    "indexed: retained for the service with the same name",
+			indexed: true,
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "web",
+				Labels: map[string]string{
+					LabelNxServiceUID:       "uid-old",
+					LabelNxServiceNamespace: "a",
+					LabelNxServiceName:      "web",
+				},
+			}},
+			want: []string{"a/web"},
+		},
+		{
+			name:    "indexed: UID label of the service in another namespace",
+			indexed: true,
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "pool-1",
+				Labels: map[string]string{LabelNxServiceUID: "uid-b"},
+			}},
+			want: []string{"b/web"},
+		},
+		{
+			name:    "indexed: owner reference without UID label",
+			indexed: true,
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "web", OwnerReferences: ownerRef("uid-a"),
+			}},
+			want: []string{"a/web"},
+		},
+		{
+			name:    "indexed: owner reference of a previous service",
+			indexed: true,
+			address: &ipamv1.IpAddress{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "a", Name: "web", OwnerReferences: ownerRef("uid-old"),
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{IndexUID: ServiceUIDIndexFunc})
+			for _, service := range []*corev1.Service{serviceA, serviceB} {
+				if err := indexer.Add(service); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := &Clients{}
+			if test.indexed {
+				c.ServiceIndexer = indexer
+			}
+
+			owners, err := c.addressOwners(corelisterv1.NewServiceLister(indexer), test.address)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			var got []string
+			for _, service := range owners {
+				got = append(got, service.Namespace+"/"+service.Name)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got owners %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("got owners %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package lbutil
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+const (
+
+	// Set on IpAddress objects with a lease. The lease duration, in time.ParseDuration format.
+	AnnNxLeaseDuration = "nexinto.com/lease-duration"
+
+	// Set on IpAddress objects with a lease. The last time the lease was renewed, in RFC3339 format.
+	AnnNxLeaseRenewTime = "nexinto.com/lease-renew-time"
+)
+
+// Give the IpAddress a lease that starts now.
+func SetLease(address *ipamv1.IpAddress, duration time.Duration) {
+	if address.Annotations == nil {
+		address.Annotations = map[string]string{}
+	}
+	address.Annotations[AnnNxLeaseDuration] = duration.String()
+	address.Annotations[AnnNxLeaseRenewTime] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// Returns when the lease of the IpAddress expires. ok is false if the address has no lease.
+func LeaseExpiry(address *ipamv1.IpAddress) (expiry time.Time, ok bool, err error) {
+	if address.Annotations[AnnNxLeaseDuration] == "" {
+		return time.Time{}, false, nil
+	}
+
+	duration, err := time.ParseDuration(address.Annotations[AnnNxLeaseDuration])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid lease duration on ipaddress '%s/%s': %w", address.Namespace, address.Name, err)
+	}
+
+	renewed, err := time.Parse(time.RFC3339, address.Annotations[AnnNxLeaseRenewTime])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid lease renew time on ipaddress '%s/%s': %w", address.Namespace, address.Name, err)
+	}
+
+	return renewed.Add(duration), true, nil
+}
+
+// Returns true if the IpAddress has a lease that has expired. Addresses with invalid lease annotations are not considered expired.
+func LeaseExpired(address *ipamv1.IpAddress, now time.Time) bool {
+	expiry, ok, err := LeaseExpiry(address)
+	if err != nil {
+		log.Warn(err.Error())
+		return false
+	}
+	return ok && now.After(expiry)
+}
+
+// Renew the lease of the IpAddress. The owning controller must call this regularly, well before the lease expires.
+// Addresses without a lease are returned unchanged.
+func RenewLease(ipamclient ipamclientset.Interface, address *ipamv1.IpAddress) (*ipamv1.IpAddress, error) {
+	if address.Annotations[AnnNxLeaseDuration] == "" {
+		return address, nil
+	}
+
+	newaddress := address.DeepCopy()
+	newaddress.Annotations[AnnNxLeaseRenewTime] = time.Now().UTC().Format(time.RFC3339)
+
+	updated, err := ipamclient.IpamV1().IpAddresses(address.Namespace).Update(newaddress)
+	if err != nil {
+		return nil, fmt.Errorf("error renewing lease of ipaddress '%s/%s': %w", address.Namespace, address.Name, err)
+	}
+
+	AddressLogger(address).Debug("renewed lease")
+
+	return updated, nil
+}
+
+// Release an IpAddress whose lease has expired by deleting it, so IPAM can reclaim the address.
+func ExpireLease(ipamclient ipamclientset.Interface, address *ipamv1.IpAddress) error {
+	err := ipamclient.IpamV1().IpAddresses(address.Namespace).Delete(address.Name, &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &address.UID},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting expired ipaddress '%s/%s': %w", address.Namespace, address.Name, err)
+	}
+
+	AddressLogger(address).Info("lease has expired; released the address")
+
+	return nil
+}
+
+// Expire all IpAddress objects with expired leases every interval until stopCh is closed.
+func RunLeaseReaper(ipamclient ipamclientset.Interface, addressLister ipamlisterv1.IpAddressLister, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		addresses, err := addressLister.List(labels.Everything())
+		if err != nil {
+			log.Errorf("error listing ipaddresses: %s", err.Error())
+			return
+		}
+
+		now := time.Now()
+		for _, address := range addresses {
+			if LeaseExpired(address, now) {
+				if err := ExpireLease(ipamclient, address); err != nil {
+					log.Error(err.Error())
+				}
+			}
+		}
+	}, interval, stopCh)
+}
@@ -0,0 +1,79 @@
+package lbutil
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// Field names used in log messages.
+const (
+	LogFieldNamespace = "namespace"
+	LogFieldService   = "service"
+	LogFieldIpAddress = "ipaddress"
+	LogFieldVIP       = "vip"
+	LogFieldProvider  = "provider"
+)
+
+// Returns a logger for messages about the Service, with its namespace, name, provider and VIP as fields.
+func ServiceLogger(service *corev1.Service) *log.Entry {
+	return log.WithFields(serviceFields(service))
+}
+
+// Returns a logger for messages about the IpAddress, with its namespace, name and address as fields.
+func AddressLogger(address *ipamv1.IpAddress) *log.Entry {
+	return log.WithFields(addressFields(address))
+}
+
+func serviceFields(service *corev1.Service) log.Fields {
+	fields := log.Fields{
+		LogFieldNamespace: service.Namespace,
+		LogFieldService:   service.Name,
+	}
+	if provider := service.Annotations[AnnNxVIPActiveProvider]; provider != "" {
+		fields[LogFieldProvider] = provider
+	}
+	if vip := service.Annotations[AnnNxAssignedVIP]; vip != "" {
+		fields[LogFieldVIP] = vip
+	}
+
+	return fields
+}
+
+func addressFields(address *ipamv1.IpAddress) log.Fields {
+	fields := log.Fields{
+		LogFieldNamespace: address.Namespace,
+		LogFieldIpAddress: address.Name,
+	}
+	if address.Status.Address != "" {
+		fields[LogFieldVIP] = address.Status.Address
+	}
+
+	return fields
+}
+
+// Like ServiceLogger, using the logger set with WithLogger.
+func (c *Clients) serviceLogger(service *corev1.Service) *log.Entry {
+	if c.logger == nil {
+		return ServiceLogger(service)
+	}
+	return c.logger.WithFields(serviceFields(service))
+}
+
+// Like AddressLogger, using the logger set with WithLogger.
+func (c *Clients) addressLogger(address *ipamv1.IpAddress) *log.Entry {
+	if c.logger == nil {
+		return AddressLogger(address)
+	}
+	return c.logger.WithFields(addressFields(address))
+}
+
+// Returns true if debug messages are logged.
+func (c *Clients) debugEnabled() bool {
+	if c.logger == nil {
+		return log.IsLevelEnabled(log.DebugLevel)
+	}
+	return c.logger.Logger.IsLevelEnabled(log.DebugLevel)
+}
@@ -0,0 +1,96 @@
+// Support for the Multi-Cluster Services API (multicluster.x-k8s.io): exported Services get their VIP
+// from a pool reachable from other clusters, and the VIP is published on the derived ServiceImport.
+
+package mcs
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+var (
+	ServiceExportResource = schema.GroupVersionResource{Group: "multicluster.x-k8s.io", Version: "v1alpha1", Resource: "serviceexports"}
+	ServiceImportResource = schema.GroupVersionResource{Group: "multicluster.x-k8s.io", Version: "v1alpha1", Resource: "serviceimports"}
+)
+
+// Handles exported Services.
+type Exporter struct {
+	Dynamic dynamic.Interface
+
+	// The address pool for VIPs of exported Services.
+	Pool string
+}
+
+// Returns true if a ServiceExport exists for the Service.
+func (e *Exporter) IsExported(service *corev1.Service) (bool, error) {
+	_, err := e.Dynamic.Resource(ServiceExportResource).Namespace(service.Namespace).Get(service.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error looking up serviceexport for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+	return true, nil
+}
+
+// Call before EnsureVIP. If the Service is exported and has no pool set, returns a copy with the
+// cross-cluster pool set that must be updated by the caller. Services that already have a VIP are left alone.
+func (e *Exporter) Prepare(service *corev1.Service) (newservice *corev1.Service, needsUpdate bool, err error) {
+	if e.Pool == "" || service.Annotations[lbutil.AnnNxVIPPool] != "" || service.Annotations[lbutil.AnnNxAssignedVIP] != "" {
+		return service, false, nil
+	}
+
+	exported, err := e.IsExported(service)
+	if err != nil || !exported {
+		return service, false, err
+	}
+
+	newservice = service.DeepCopy()
+	if newservice.Annotations == nil {
+		newservice.Annotations = map[string]string{}
+	}
+	newservice.Annotations[lbutil.AnnNxVIPPool] = e.Pool
+
+	lbutil.ServiceLogger(service).Debugf("service is exported; using pool '%s'", e.Pool)
+
+	return newservice, true, nil
+}
+
+// Publish the VIP of the Service on the ServiceImport derived from it, if one exists.
+func (e *Exporter) AnnotateServiceImport(service *corev1.Service, vip string) error {
+	imports := e.Dynamic.Resource(ServiceImportResource).Namespace(service.Namespace)
+
+	si, err := imports.Get(service.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error looking up serviceimport for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	annotations := si.GetAnnotations()
+	if annotations[lbutil.AnnNxVIP] == vip {
+		return nil
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[lbutil.AnnNxVIP] = vip
+	si.SetAnnotations(annotations)
+
+	if _, err := imports.Update(si, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error annotating serviceimport for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	lbutil.ServiceLogger(service).Debug("annotated serviceimport")
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// Adapter that lets MetalLB announce VIPs allocated by k8s-ipam. Instead of programming a loadbalancer,
+// the assigned VIP is requested from MetalLB, so allocation stays centralized in k8s-ipam while MetalLB
+// performs the announcement. The MetalLB address pools must contain the addresses handed out by k8s-ipam.
+
+package metallb
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// The MetalLB annotation requesting specific addresses for a Service.
+const AnnMetalLBLoadBalancerIPs = "metallb.universe.tf/loadBalancerIPs"
+
+// Where the VIP is written for MetalLB.
+type Mode int
+
+const (
+
+	// Set spec.loadBalancerIP.
+	ModeLoadBalancerIP Mode = iota
+
+	// Set the metallb.universe.tf/loadBalancerIPs annotation.
+	ModeAnnotation
+)
+
+// Runs EnsureVIP for LoadBalancer Services and hands the assigned VIP to MetalLB.
+type Adapter struct {
+	Clients *lbutil.Clients
+
+	ControllerName    string
+	RequireAnnotation bool
+
+	Mode Mode
+}
+
+// Create an Adapter. The Clients are configured to handle LoadBalancer Services.
+func NewAdapter(clients *lbutil.Clients, controllerName string, requireAnnotation bool, mode Mode) *Adapter {
+	clients.ServiceTypes = []corev1.ServiceType{corev1.ServiceTypeLoadBalancer}
+
+	return &Adapter{
+		Clients:           clients,
+		ControllerName:    controllerName,
+		RequireAnnotation: requireAnnotation,
+		Mode:              mode,
+	}
+}
+
+// Process a Service. If needsUpdate is true, newservice must be updated by the caller.
+func (a *Adapter) Process(service *corev1.Service) (newservice *corev1.Service, needsUpdate bool, err error) {
+	result, err := a.Clients.EnsureVIP(service, a.ControllerName, a.RequireAnnotation)
+	if err != nil || !result.Ready() {
+		return result.Service, result.NeedsUpdate, err
+	}
+
+	if Requested(result.Service, a.Mode) == result.VIP {
+		return result.Service, result.NeedsUpdate, nil
+	}
+
+	newservice = result.Service
+	if !result.NeedsUpdate {
+		newservice = newservice.DeepCopy()
+	}
+	Request(newservice, a.Mode, result.VIP)
+
+	lbutil.ServiceLogger(newservice).Debug("requested vip from metallb")
+
+	return newservice, true, nil
+}
+
+// Returns the address requested from MetalLB.
+func Requested(service *corev1.Service, mode Mode) string {
+	if mode == ModeAnnotation {
+		return service.Annotations[AnnMetalLBLoadBalancerIPs]
+	}
+	return service.Spec.LoadBalancerIP
+}
+
+// Request the address from MetalLB. Modifies the Service, which must not come from a cache.
+func Request(service *corev1.Service, mode Mode, vip string) {
+	if mode == ModeAnnotation {
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		service.Annotations[AnnMetalLBLoadBalancerIPs] = vip
+		return
+	}
+	service.Spec.LoadBalancerIP = vip
+}
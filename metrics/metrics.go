@@ -0,0 +1,77 @@
+// Prometheus metrics for controllers using lbutil: workqueue depth and latency, and reconcile duration by outcome.
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/client-go/util/workqueue"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// Outcomes of a reconcile.
+const (
+	OutcomeSuccess   = "success"
+	OutcomeError     = "error"
+	OutcomePermanent = "permanent_error"
+	OutcomePanic     = "panic"
+)
+
+// Duration of reconciles by controller and outcome.
+var ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "lbutil",
+	Name:      "reconcile_duration_seconds",
+	Help:      "Duration of reconciles by controller and outcome.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+}, []string{"controller", "outcome"})
+
+// Register the metrics with the registry and install the workqueue metrics provider.
+// Must be called before the first workqueue is created; queues need a name (NewNamedRateLimitingQueue)
+// to be instrumented.
+func Register(registry prometheus.Registerer) error {
+	if err := registry.Register(ReconcileDuration); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			return err
+		}
+	}
+
+	workqueue.SetProvider(&workqueueMetricsProvider{registry: registry})
+
+	return nil
+}
+
+// Record a reconcile that started at start and returned err.
+func ObserveReconcile(controller string, start time.Time, err error) {
+	ReconcileDuration.WithLabelValues(controller, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// Wrap a worker function so the duration and outcome of each call are recorded. Use with lbutil.RunWorkers.
+func Instrument(controller string, handler lbutil.WorkerFunc) lbutil.WorkerFunc {
+	return func(key string) (err error) {
+		start := time.Now()
+		defer func() {
+			if r := recover(); r != nil {
+				ReconcileDuration.WithLabelValues(controller, OutcomePanic).Observe(time.Since(start).Seconds())
+				panic(r)
+			}
+		}()
+
+		err = handler(key)
+		ObserveReconcile(controller, start, err)
+
+		return err
+	}
+}
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case lbutil.Classify(err).Decision == lbutil.DecisionFail:
+		return OutcomePermanent
+	}
+	return OutcomeError
+}
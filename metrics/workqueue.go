@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Creates the standard workqueue metrics (the names used by Kubernetes components), labeled by queue name.
+type workqueueMetricsProvider struct {
+	registry prometheus.Registerer
+}
+
+func (p *workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return p.gauge("depth", "Current depth of the workqueue.", name)
+}
+
+func (p *workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return p.counter("adds_total", "Total number of adds handled by the workqueue.", name)
+}
+
+func (p *workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return p.histogram("queue_duration_seconds", "How long in seconds an item stays in the workqueue before being processed.", name)
+}
+
+func (p *workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return p.histogram("work_duration_seconds", "How long in seconds processing an item from the workqueue takes.", name)
+}
+
+func (p *workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.gauge("unfinished_work_seconds", "How many seconds of work has been done that is in progress.", name)
+}
+
+func (p *workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.gauge("longest_running_processor_seconds", "How many seconds the longest running processor has been running.", name)
+}
+
+func (p *workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return p.counter("retries_total", "Total number of retries handled by the workqueue.", name)
+}
+
+func (p *workqueueMetricsProvider) gauge(metric, help, name string) prometheus.Gauge {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Subsystem: "workqueue", Name: metric, Help: help}, []string{"name"})
+	return p.register(vec).(*prometheus.GaugeVec).WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) counter(metric, help, name string) prometheus.Counter {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Subsystem: "workqueue", Name: metric, Help: help}, []string{"name"})
+	return p.register(vec).(*prometheus.CounterVec).WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) histogram(metric, help, name string) prometheus.Observer {
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "workqueue",
+		Name:      metric,
+		Help:      help,
+		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+	return p.register(vec).(*prometheus.HistogramVec).WithLabelValues(name)
+}
+
+// Register the collector, or return the one already registered for the metric by another queue.
+func (p *workqueueMetricsProvider) register(c prometheus.Collector) prometheus.Collector {
+	if err := p.registry.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+	}
+	return c
+}
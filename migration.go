@@ -0,0 +1,84 @@
+package lbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Moving a Service from provider A to provider B:
+//
+//  1. The operator sets the migrate-to annotation to B on the Service.
+//  2. A sees the annotation (StateMigrating), removes its loadbalancer configuration and releases the Service:
+//     the claim and the annotation are removed, vip-provider is set to B and the migration is recorded in the
+//     vip-migration annotation (StateReleased). The IpAddress and the VIP are kept.
+//  3. B claims the Service like any other, finds the IpAddress and configures its loadbalancer with the same VIP.
+//     Once the Service is ready, B removes the vip-migration annotation.
+//
+// Every step is a single update of the Service, so a controller that crashes simply repeats its step.
+const (
+
+	// The provider the Service should be moved to. Set by the operator.
+	AnnNxVIPMigrateTo = "nexinto.com/vip-migrate-to"
+
+	// JSON document describing a migration in progress (see Migration). Maintained by EnsureVIP.
+	AnnNxVIPMigration = "nexinto.com/vip-migration"
+)
+
+// The content of the vip-migration annotation.
+type Migration struct {
+	From     string      `json:"from"`
+	To       string      `json:"to"`
+	Released metav1.Time `json:"released"`
+}
+
+// Returns the migration in progress for the Service or nil if there is none.
+func GetMigration(service *corev1.Service) (*Migration, error) {
+	if service.Annotations[AnnNxVIPMigration] == "" {
+		return nil, nil
+	}
+
+	migration := &Migration{}
+	if err := json.Unmarshal([]byte(service.Annotations[AnnNxVIPMigration]), migration); err != nil {
+		return nil, fmt.Errorf("invalid migration for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	return migration, nil
+}
+
+// Returns true if the Service is claimed by the controller and should be moved to another provider.
+func migrating(service *corev1.Service, controllerName string) bool {
+	to := service.Annotations[AnnNxVIPMigrateTo]
+	return to != "" && to != controllerName && service.Annotations[AnnNxVIPActiveProvider] == controllerName
+}
+
+// Returns a copy of the Service released by the controller for the provider named in the migrate-to annotation.
+// The loadbalancer configuration must be removed before the copy is stored.
+func ReleaseService(service *corev1.Service, controllerName string, now time.Time) *corev1.Service {
+	newservice := service.DeepCopy()
+	if newservice.Annotations == nil {
+		newservice.Annotations = map[string]string{}
+	}
+
+	to := newservice.Annotations[AnnNxVIPMigrateTo]
+
+	data, _ := json.Marshal(Migration{From: controllerName, To: to, Released: metav1.NewTime(now)})
+	newservice.Annotations[AnnNxVIPMigration] = string(data)
+	newservice.Annotations[AnnNxVIPProvider] = to
+	delete(newservice.Annotations, AnnNxVIPActiveProvider)
+	delete(newservice.Annotations, AnnNxVIPMigrateTo)
+
+	return newservice
+}
+
+// Returns a copy of the Service without the migration annotations.
+func finishMigration(service *corev1.Service) *corev1.Service {
+	newservice := service.DeepCopy()
+	delete(newservice.Annotations, AnnNxVIPMigration)
+	delete(newservice.Annotations, AnnNxVIPMigrateTo)
+
+	return newservice
+}
@@ -0,0 +1,159 @@
+package lbutil
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// The maximum length of an object name.
+const maxNameLength = 253
+
+// Returns the name of the IpAddress object requested for a Service.
+type NamingStrategy func(service *corev1.Service) string
+
+// Name IpAddress objects after their Service. This is the default.
+func NameAsService(service *corev1.Service) string {
+	return service.Name
+}
+
+// Name IpAddress objects after their Service with a hash of the Service UID appended, so they cannot collide
+// with unrelated IpAddress objects or with the address of a previous Service of the same name.
+func NameWithHash(service *corev1.Service) string {
+	return truncateName(service.Name, "-"+serviceHash(service))
+}
+
+// Name IpAddress objects using a text/template with the fields .Namespace, .Name, .UID and .Hash
+// (8 hex digits derived from the UID). Names longer than allowed are shortened and get the hash appended.
+// If the template fails or the name is not a valid DNS-1123 subdomain, the name is chosen by NameWithHash.
+func NameFromTemplate(text string) (NamingStrategy, error) {
+	tmpl, err := template.New("ipaddress-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip address name template: %w", err)
+	}
+
+	return func(service *corev1.Service) string {
+		hash := serviceHash(service)
+
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, map[string]string{
+			"Namespace": service.Namespace,
+			"Name":      service.Name,
+			"UID":       string(service.UID),
+			"Hash":      hash,
+		})
+		if err != nil || buf.Len() == 0 {
+			return NameWithHash(service)
+		}
+
+		name := buf.String()
+		if len(name) > maxNameLength {
+			name = truncateName(name, "-"+hash)
+		}
+		if len(validation.IsDNS1123Subdomain(name)) > 0 {
+			return NameWithHash(service)
+		}
+		return name
+	}, nil
+}
+
+func serviceHash(service *corev1.Service) string {
+	h := fnv.New32a()
+	h.Write([]byte(service.UID))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// Shorten name so that name+suffix fits into an object name. A '-' or '.' left at the end of the shortened
+// name is removed, so the result stays a valid name.
+func truncateName(name, suffix string) string {
+	if len(name)+len(suffix) > maxNameLength {
+		name = strings.TrimRight(name[:maxNameLength-len(suffix)], "-.")
+	}
+	return name + suffix
+}
+
+// Returns the name of the IpAddress for the Service using the naming strategy of the Clients.
+func (c *Clients) addressName(service *corev1.Service) string {
+	if c.AddressNaming == nil {
+		return NameAsService(service)
+	}
+	return c.AddressNaming(service)
+}
+
+// Returns the namespace and name of the IpAddress for the Service.
+func (c *Clients) AddressKey(service *corev1.Service) (namespace, name string) {
+	return addressKey(service, c.AddressNaming)
+}
+
+// Optionally implemented by an AddressGetter to find the IpAddress of a Service by its owner label
+// if it is not found by name, for example after the naming strategy was changed. Addresses with a role
+// (see LabelNxAddressRole) must not be returned.
+type AddressFinder interface {
+	FindIpAddress(namespace string, serviceUID types.UID) (*ipamv1.IpAddress, error)
+}
+
+func (g *listerAddressGetter) FindIpAddress(namespace string, serviceUID types.UID) (*ipamv1.IpAddress, error) {
+	selector := labels.SelectorFromSet(labels.Set{LabelNxServiceUID: string(serviceUID)})
+	addresses, err := g.lister.IpAddresses(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		if !IsReleased(address) && address.Labels[LabelNxAddressRole] == "" {
+			return address, nil
+		}
+	}
+	return nil, errors.NewNotFound(ipamv1.Resource("ipaddress"), string(serviceUID))
+}
+
+// Returns the description of the IpAddress object requested for a Service.
+type DescriptionStrategy func(service *corev1.Service) string
+
+// The default description of IpAddress objects.
+func DefaultDescription(service *corev1.Service) string {
+	return fmt.Sprintf("created for service %s", service.Name)
+}
+
+// Describe IpAddress objects using a text/template with the fields .Namespace, .Name, .UID, .Cluster (the
+// clusterName), .Provider and .Pool. If the template fails, the default description is used.
+func DescriptionFromTemplate(text, clusterName string) (DescriptionStrategy, error) {
+	tmpl, err := template.New("ipaddress-description").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip address description template: %w", err)
+	}
+
+	return func(service *corev1.Service) string {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, map[string]string{
+			"Namespace": service.Namespace,
+			"Name":      service.Name,
+			"UID":       string(service.UID),
+			"Cluster":   clusterName,
+			"Provider":  service.Annotations[AnnNxVIPActiveProvider],
+			"Pool":      service.Annotations[AnnNxVIPPool],
+		})
+		if err != nil {
+			return DefaultDescription(service)
+		}
+		return buf.String()
+	}, nil
+}
+
+// Returns the description of the IpAddress for the Service using the description strategy of the Clients.
+func (c *Clients) addressDescription(service *corev1.Service) string {
+	if c.AddressDescription == nil {
+		return DefaultDescription(service)
+	}
+	return c.AddressDescription(service)
+}
@@ -0,0 +1,162 @@
+// Outbound notifications about VIP changes: a JSON document is POSTed to HTTP endpoints, for example
+// a CMDB, firewall automation or a chat bot.
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// What happened to the VIP.
+const (
+	ActionAssigned = "assigned"
+	ActionReleased = "released"
+)
+
+// The number of notifications waiting to be sent. Further notifications are dropped.
+const QueueSize = 1000
+
+// The JSON document sent to the endpoints.
+type Notification struct {
+	Namespace string    `json:"namespace"`
+	Service   string    `json:"service"`
+	VIP       string    `json:"vip"`
+	Provider  string    `json:"provider"`
+	Action    string    `json:"action"`
+	Time      time.Time `json:"time"`
+}
+
+// Sends notifications to the endpoints in the background, so slow endpoints do not delay reconciles.
+// Failed deliveries are logged and not retried. Since hooks run again when a Service update fails,
+// endpoints may receive the same notification more than once.
+type Notifier struct {
+	Endpoints []string
+
+	// Used to send the notifications. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Added to every request, for example for authentication.
+	Headers map[string]string
+
+	queue chan Notification
+}
+
+// Create a Notifier for the endpoints. Call Run to start sending.
+func NewNotifier(endpoints ...string) *Notifier {
+	return &Notifier{
+		Endpoints: endpoints,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		queue:     make(chan Notification, QueueSize),
+	}
+}
+
+// Queue a notification. Never blocks.
+func (n *Notifier) Notify(notification Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		log.WithField(lbutil.LogFieldService, notification.Service).Warn("notification queue is full; dropping notification")
+	}
+}
+
+// Send queued notifications until stopCh is closed.
+func (n *Notifier) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case notification := <-n.queue:
+			for _, endpoint := range n.Endpoints {
+				if err := n.send(endpoint, notification); err != nil {
+					log.WithFields(log.Fields{
+						lbutil.LogFieldNamespace: notification.Namespace,
+						lbutil.LogFieldService:   notification.Service,
+					}).Warnf("error sending notification: %s", err.Error())
+				}
+			}
+		}
+	}
+}
+
+func (n *Notifier) send(endpoint string, notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+
+	return nil
+}
+
+// Returns Hooks that queue notifications for assigned and released VIPs of the provider, and then call next (which may be nil).
+func (n *Notifier) Hooks(provider string, next *lbutil.Hooks) *lbutil.Hooks {
+	if next == nil {
+		next = &lbutil.Hooks{}
+	}
+
+	return &lbutil.Hooks{
+		OnClaim: next.OnClaim,
+		OnVIPAssigned: func(service *corev1.Service, vip string) error {
+			if next.OnVIPAssigned != nil {
+				if err := next.OnVIPAssigned(service, vip); err != nil {
+					return err
+				}
+			}
+			n.Notify(notification(service, vip, provider, ActionAssigned))
+			return nil
+		},
+		OnVIPReleased: func(service *corev1.Service, vip string) error {
+			if next.OnVIPReleased != nil {
+				if err := next.OnVIPReleased(service, vip); err != nil {
+					return err
+				}
+			}
+			n.Notify(notification(service, vip, provider, ActionReleased))
+			return nil
+		},
+	}
+}
+
+func notification(service *corev1.Service, vip, provider, action string) Notification {
+	return Notification{
+		Namespace: service.Namespace,
+		Service:   service.Name,
+		VIP:       vip,
+		Provider:  provider,
+		Action:    action,
+		Time:      time.Now().UTC(),
+	}
+}
@@ -0,0 +1,94 @@
+package lbutil
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Configures a call of Ensure.
+type Option func(*options)
+
+type options struct {
+	requireAnnotation bool
+	pool              string
+	dryRun            bool
+	logger            *log.Entry
+}
+
+// Only handle Services with the req-vip annotation.
+func WithRequireAnnotation(require bool) Option {
+	return func(o *options) {
+		o.requireAnnotation = require
+	}
+}
+
+// Request addresses from the pool for Services that don't choose a pool with the vip-pool annotation.
+func WithPool(pool string) Option {
+	return func(o *options) {
+		o.pool = pool
+	}
+}
+
+// Only compute what would be done: the Result has the current State and the Actions that would be performed,
+// but nothing is created, updated or recorded and the Service is not modified.
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// Log messages about the Service with the logger instead of the standard logger.
+func WithLogger(logger *log.Entry) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Like EnsureVIP, configured with options.
+func (c *Clients) Ensure(service *corev1.Service, controllerName string, opts ...Option) (*Result, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cc := *c
+	cc.pool = o.pool
+	cc.logger = o.logger
+
+	if o.dryRun {
+		return cc.dryRun(service, controllerName, o.requireAnnotation)
+	}
+	wasOpen := c.breaker != nil && c.breaker.Open()
+	result, err := cc.ensure(service, controllerName, o.requireAnnotation)
+	c.recordCircuitOpen(service, wasOpen, err)
+	return result, err
+}
+
+func (c *Clients) dryRun(service *corev1.Service, controllerName string, requireAnnotation bool) (*Result, error) {
+	obs, err := c.Observe(service, controllerName, requireAnnotation)
+	if err != nil {
+		return &Result{State: obs.State(), Reason: err.Error()}, err
+	}
+
+	state := obs.State()
+	_, actions := Step(obs)
+
+	return &Result{
+		State:   state,
+		Service: service,
+		Reason:  obs.describe(state),
+		Actions: actions,
+	}, nil
+}
+
+// Returns the address pool for the Service.
+func (c *Clients) poolFor(service *corev1.Service) string {
+	if pool := service.Annotations[AnnNxVIPPool]; pool != "" {
+		return pool
+	}
+	if pool := c.familyPool(service); pool != "" {
+		return pool
+	}
+	return c.pool
+}
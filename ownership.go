@@ -0,0 +1,226 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+const (
+
+	// Label on IpAddress objects with the UID of the Service that requested them.
+	LabelNxServiceUID = "nexinto.com/service-uid"
+
+	// Label on IpAddress objects of a Service that do not hold its primary VIP, with their role (for example
+	// AddressRoleSecondary). They are never taken for the primary address when it is looked up by UID.
+	LabelNxAddressRole = "nexinto.com/address-role"
+
+	// Name of the IpAddress informer index that maps Service UIDs to IpAddress objects.
+	IndexServiceUID = "nexinto.com/service-uid"
+
+	// Name of the Service informer index that maps UIDs to Services.
+	IndexUID = "nexinto.com/uid"
+)
+
+// Label an IpAddress as belonging to a Service.
+func SetAddressOwnerLabel(address *ipamv1.IpAddress, service *corev1.Service) {
+	if address.Labels == nil {
+		address.Labels = map[string]string{}
+	}
+	address.Labels[LabelNxServiceUID] = string(service.UID)
+}
+
+// Returns the UID of the Service that owns the IpAddress according to its label, or "" if it is not labeled.
+func AddressOwnerUID(address *ipamv1.IpAddress) types.UID {
+	return types.UID(address.Labels[LabelNxServiceUID])
+}
+
+// Returns the UIDs of the Services owning the IpAddress: the UID from the label or, for addresses
+// created before the label was introduced, the UIDs of the Service owner references.
+func addressOwnerUIDs(address *ipamv1.IpAddress) []types.UID {
+	if uid := AddressOwnerUID(address); uid != "" {
+		return []types.UID{uid}
+	}
+
+	var uids []types.UID
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind == "Service" && ref.APIVersion == "v1" && ref.UID != "" {
+			uids = append(uids, ref.UID)
+		}
+	}
+	return uids
+}
+
+// Index function for IpAddress informers; indexes addresses by the UID of the owning Service.
+func IpAddressServiceUIDIndexFunc(obj interface{}) ([]string, error) {
+	address, ok := obj.(*ipamv1.IpAddress)
+	if !ok {
+		return nil, fmt.Errorf("expected an IpAddress, got %T", obj)
+	}
+
+	var keys []string
+	for _, uid := range addressOwnerUIDs(address) {
+		keys = append(keys, string(uid))
+	}
+	return keys, nil
+}
+
+// Register the service UID index with an IpAddress informer. Must be called before the informer is started.
+func AddIpAddressIndexers(informer cache.SharedIndexInformer) error {
+	return addMissingIndexers(informer, cache.Indexers{IndexServiceUID: IpAddressServiceUIDIndexFunc})
+}
+
+// Add the indexers that are not registered yet, so the Add*Indexers functions can be called more than once
+// for a shared informer.
+func addMissingIndexers(informer cache.SharedIndexInformer, indexers cache.Indexers) error {
+	existing := informer.GetIndexer().GetIndexers()
+
+	missing := cache.Indexers{}
+	for name, f := range indexers {
+		if _, ok := existing[name]; !ok {
+			missing[name] = f
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return informer.AddIndexers(missing)
+}
+
+// Return all IpAddress objects labeled as belonging to the Service.
+func IpAddressesForService(indexer cache.Indexer, service *corev1.Service) ([]*ipamv1.IpAddress, error) {
+	objs, err := indexer.ByIndex(IndexServiceUID, string(service.UID))
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]*ipamv1.IpAddress, 0, len(objs))
+	for _, obj := range objs {
+		addresses = append(addresses, obj.(*ipamv1.IpAddress))
+	}
+
+	return addresses, nil
+}
+
+// Index function for Service informers; indexes Services by their UID.
+func ServiceUIDIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, fmt.Errorf("expected a Service, got %T", obj)
+	}
+	return []string{string(service.UID)}, nil
+}
+
+// Return the Service with the UID from a Service informer indexer with the UID index, or nil if there is none.
+func ServiceByUID(indexer cache.Indexer, uid types.UID) (*corev1.Service, error) {
+	objs, err := indexer.ByIndex(IndexUID, string(uid))
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, nil
+	}
+	return objs[0].(*corev1.Service), nil
+}
+
+// Returns the namespace and name of the Service an IpAddress was adopted for from a warm pool in another namespace.
+// Such addresses have no owner reference, only labels. ok is false for all other addresses.
+func adoptedServiceKey(address *ipamv1.IpAddress) (namespace, name string, ok bool) {
+	if address.Labels[LabelNxCluster] != "" {
+		return "", "", false
+	}
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind == "Service" {
+			return "", "", false
+		}
+	}
+	namespace, name = address.Labels[LabelNxServiceNamespace], address.Labels[LabelNxServiceName]
+	return namespace, name, namespace != "" && name != ""
+}
+
+// Check the owner references of the IpAddress of the Service: references to a Service must carry its UID,
+// and there must be at most one controller reference, which must point to the Service.
+func ValidateOwnerReferences(address *ipamv1.IpAddress, service *corev1.Service) error {
+	controllers := 0
+
+	for _, ref := range address.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller {
+			controllers++
+			if ref.Kind != "Service" || ref.APIVersion != "v1" || ref.UID != service.UID {
+				return fmt.Errorf("ipaddress '%s/%s' is controlled by %s '%s', not by service '%s'", address.Namespace, address.Name, ref.Kind, ref.Name, service.Name)
+			}
+		}
+		if ref.Kind == "Service" && ref.APIVersion == "v1" && ref.Name == service.Name && ref.UID != service.UID {
+			return fmt.Errorf("ipaddress '%s/%s' references a previous service '%s'", address.Namespace, address.Name, service.Name)
+		}
+	}
+
+	if controllers > 1 {
+		return fmt.Errorf("ipaddress '%s/%s' has %d controller references", address.Namespace, address.Name, controllers)
+	}
+
+	return nil
+}
+
+// Returns true if the IpAddress has a controller reference to the Service.
+func IsControlledBy(address *ipamv1.IpAddress, service *corev1.Service) bool {
+	return metav1.IsControlledBy(address, service)
+}
+
+// What to do with an IpAddress whose owner reference points to a different Service of the same name,
+// typically a deleted Service whose address was not garbage collected yet.
+type AddressMismatchPolicy string
+
+const (
+
+	// Delete the IpAddress and request a new one. The default.
+	MismatchRecreate AddressMismatchPolicy = "Recreate"
+
+	// Use the IpAddress anyway, as before UIDs were checked.
+	MismatchAdopt AddressMismatchPolicy = "Adopt"
+)
+
+// Returns true if the IpAddress has owner references to Services, but none to this one. Addresses without
+// owner references (for example retained ones, see ReleasePolicyRetain) are not considered foreign.
+func ownerMismatch(address *ipamv1.IpAddress, service *corev1.Service) bool {
+	if service.UID == "" {
+		return false
+	}
+
+	mismatch := false
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind != "Service" || ref.APIVersion != "v1" {
+			continue
+		}
+		if ref.UID == service.UID {
+			return false
+		}
+		mismatch = true
+	}
+	return mismatch
+}
+
+// Delete an IpAddress of a previous Service of the same name, so a new one can be requested.
+func (c *Clients) deleteStale(service *corev1.Service, address *ipamv1.IpAddress) error {
+	deleter, ok := c.AddressCreator.(AddressDeleter)
+	if !ok {
+		return fmt.Errorf("ipaddress '%s/%s' belongs to a previous service '%s' and cannot be deleted", address.Namespace, address.Name, service.Name)
+	}
+
+	err := deleter.DeleteIpAddress(address.Namespace, address.Name, address.UID)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting ipaddress '%s/%s' of a previous service '%s': %w", address.Namespace, address.Name, service.Name, err)
+	}
+
+	c.addressLogger(address).Info("deleted ipaddress of a previous service with the same name")
+
+	return nil
+}
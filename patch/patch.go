@@ -0,0 +1,127 @@
+// Builds JSON patches (RFC 6902) for Services and other API objects.
+
+package patch
+
+import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// One operation of a JSON patch.
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Omits the value of remove operations only; the value of other operations is required even if it is
+// empty, like an annotation set to "".
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+
+	type operation Operation
+	return json.Marshal(operation(o))
+}
+
+// Collects the operations of a JSON patch. Use with types.JSONPatchType.
+type Builder struct {
+	ops []Operation
+}
+
+// Create an empty patch.
+func New() *Builder {
+	return &Builder{}
+}
+
+// Escape a map key (like an annotation name) for use in a JSON pointer (RFC 6901).
+func EscapeKey(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+// Let the patch fail unless the value at the path equals value.
+func (b *Builder) Test(path string, value interface{}) *Builder {
+	b.ops = append(b.ops, Operation{Op: "test", Path: path, Value: value})
+	return b
+}
+
+// Add or replace the value at the path.
+func (b *Builder) Add(path string, value interface{}) *Builder {
+	b.ops = append(b.ops, Operation{Op: "add", Path: path, Value: value})
+	return b
+}
+
+// Remove the value at the path. The patch fails if it does not exist.
+func (b *Builder) Remove(path string) *Builder {
+	b.ops = append(b.ops, Operation{Op: "remove", Path: path})
+	return b
+}
+
+// Let the patch fail if the object was modified since obj was read.
+func (b *Builder) TestResourceVersion(obj metav1.Object) *Builder {
+	return b.Test("/metadata/resourceVersion", obj.GetResourceVersion())
+}
+
+// Set an annotation on the object. obj is the current version of the object and is not modified;
+// it is used to create the annotations map if it does not exist yet.
+func (b *Builder) AddAnnotation(obj metav1.Object, key, value string) *Builder {
+	if obj.GetAnnotations() == nil && !b.addsAnnotations() {
+		b.Add("/metadata/annotations", map[string]string{})
+	}
+	return b.Add("/metadata/annotations/"+EscapeKey(key), value)
+}
+
+// Remove an annotation from the object. Does nothing if the current version obj does not have it.
+func (b *Builder) RemoveAnnotation(obj metav1.Object, key string) *Builder {
+	if _, ok := obj.GetAnnotations()[key]; !ok {
+		return b
+	}
+	return b.Remove("/metadata/annotations/" + EscapeKey(key))
+}
+
+// Set the loadbalancer ingress in the status of a Service. The patch must be applied to the status subresource.
+func (b *Builder) SetStatusIngress(ingress []corev1.LoadBalancerIngress) *Builder {
+	if ingress == nil {
+		ingress = []corev1.LoadBalancerIngress{}
+	}
+	return b.Add("/status/loadBalancer/ingress", ingress)
+}
+
+// Returns true if the patch has no operations, other than tests.
+func (b *Builder) Empty() bool {
+	for _, op := range b.ops {
+		if op.Op != "test" {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the operations.
+func (b *Builder) Operations() []Operation {
+	return b.ops
+}
+
+// Returns the patch.
+func (b *Builder) Build() ([]byte, error) {
+	if b.ops == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(b.ops)
+}
+
+func (b *Builder) addsAnnotations() bool {
+	for _, op := range b.ops {
+		if op.Op == "add" && op.Path == "/metadata/annotations" {
+			return true
+		}
+	}
+	return false
+}
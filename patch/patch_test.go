@@ -0,0 +1,30 @@
+package patch
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildEmptyValues(t *testing.T) {
+	obj := &metav1.ObjectMeta{
+		ResourceVersion: "1",
+		Annotations:     map[string]string{"a": "x", "nexinto.com/vip": "10.0.0.1"},
+	}
+
+	data, err := New().
+		TestResourceVersion(obj).
+		AddAnnotation(obj, "nexinto.com/vip", "").
+		RemoveAnnotation(obj, "a").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[{"op":"test","path":"/metadata/resourceVersion","value":"1"},` +
+		`{"op":"add","path":"/metadata/annotations/nexinto.com~1vip","value":""},` +
+		`{"op":"remove","path":"/metadata/annotations/a"}]`
+	if string(data) != want {
+		t.Errorf("got patch %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,48 @@
+package lbutil
+
+import (
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The paused Services an Event was recorded for, by namespace/name, so it is recorded only once per pause.
+type pauseSet struct {
+	lock     sync.Mutex
+	services map[string]string
+}
+
+var pauses = &pauseSet{services: map[string]string{}}
+
+// Record an Event when the Service is paused, and forget it when it is resumed.
+func (c *Clients) reportPause(service *corev1.Service, paused bool) {
+	key := service.Namespace + "/" + service.Name
+
+	pauses.lock.Lock()
+	defer pauses.lock.Unlock()
+
+	if !paused {
+		delete(pauses.services, key)
+		return
+	}
+
+	value := service.Annotations[AnnNxPaused]
+	if old, ok := pauses.services[key]; ok && old == value {
+		return
+	}
+	pauses.services[key] = value
+
+	c.serviceLogger(service).Info("reconciliation is paused")
+	if c.Events != nil {
+		_ = c.Events.RecordEvent(service, ReasonPaused, fmt.Sprintf("reconciliation is paused: %s", value), false)
+	}
+}
+
+// Forget the deleted Service, so a new Service with the same name gets the Event again.
+func forgetPause(service *corev1.Service) {
+	pauses.lock.Lock()
+	defer pauses.lock.Unlock()
+
+	delete(pauses.services, service.Namespace+"/"+service.Name)
+}
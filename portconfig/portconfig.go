@@ -0,0 +1,223 @@
+// Parsing of per-port loadbalancer configuration annotations on Services.
+
+package portconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+)
+
+const (
+
+	// Comma-separated list of Service ports (names or numbers) to expose. If not set, all ports are exposed.
+	AnnNxPorts = "nexinto.com/ports"
+
+	// Comma-separated list of port=protocol pairs overriding the loadbalancer protocol of a port.
+	AnnNxPortProtocols = "nexinto.com/port-protocols"
+
+	// Comma-separated list of port=protocol pairs setting the protocol spoken to the backends.
+	AnnNxBackendProtocols = "nexinto.com/backend-protocols"
+
+	// Comma-separated list of ports for which the proxy protocol is sent to the backends.
+	AnnNxProxyProtocolPorts = "nexinto.com/proxy-protocol-ports"
+
+	// The proxy protocol version sent to the backends, v1 or v2. Applies to the ports in proxy-protocol-ports if set,
+	// otherwise to all ports except UDP ports. "none" disables it, even for the ports in proxy-protocol-ports and
+	// if upstream annotations enable it.
+	AnnNxProxyProtocol = "nexinto.com/proxy-protocol"
+)
+
+// Proxy protocol versions.
+const (
+	ProxyProtocolV1 = 1
+	ProxyProtocolV2 = 2
+)
+
+// Loadbalancer protocols.
+const (
+	ProtocolTCP   = "TCP"
+	ProtocolUDP   = "UDP"
+	ProtocolHTTP  = "HTTP"
+	ProtocolHTTPS = "HTTPS"
+)
+
+// The loadbalancer configuration for one Service port.
+type PortConfig struct {
+	Name     string
+	Port     int32
+	NodePort int32
+
+	// The protocol the loadbalancer uses for the port.
+	Protocol string
+
+	// The protocol the loadbalancer uses to talk to the backends.
+	BackendProtocol string
+
+	// Send the proxy protocol header to the backends.
+	ProxyProtocol bool
+
+	// The version of the proxy protocol header, if ProxyProtocol is set.
+	ProxyProtocolVersion int
+}
+
+// Parse the port annotations of the Service. All problems are returned at once.
+func Parse(service *corev1.Service) ([]PortConfig, error) {
+	var errs []error
+
+	configs := make([]PortConfig, 0, len(service.Spec.Ports))
+	for _, p := range service.Spec.Ports {
+		protocol := string(p.Protocol)
+		if protocol == "" {
+			protocol = ProtocolTCP
+		}
+		configs = append(configs, PortConfig{
+			Name:            p.Name,
+			Port:            p.Port,
+			NodePort:        p.NodePort,
+			Protocol:        protocol,
+			BackendProtocol: protocol,
+		})
+	}
+
+	find := func(ref string) *PortConfig {
+		for i := range configs {
+			if configs[i].Name == ref || strconv.Itoa(int(configs[i].Port)) == ref {
+				return &configs[i]
+			}
+		}
+		errs = append(errs, fmt.Errorf("service has no port '%s'", sanitize.Value(ref)))
+		return nil
+	}
+
+	for _, pair := range parsePairs(service.Annotations[AnnNxPortProtocols], AnnNxPortProtocols, &errs) {
+		if c := find(pair.key); c != nil {
+			if err := validateProtocol(pair.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", AnnNxPortProtocols, err))
+				continue
+			}
+			c.Protocol = pair.value
+			if service.Annotations[AnnNxBackendProtocols] == "" {
+				c.BackendProtocol = pair.value
+			}
+		}
+	}
+
+	for _, pair := range parsePairs(service.Annotations[AnnNxBackendProtocols], AnnNxBackendProtocols, &errs) {
+		if c := find(pair.key); c != nil {
+			if err := validateProtocol(pair.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", AnnNxBackendProtocols, err))
+				continue
+			}
+			c.BackendProtocol = pair.value
+		}
+	}
+
+	version, err := ParseProxyProtocol(service.Annotations[AnnNxProxyProtocol])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", AnnNxProxyProtocol, err))
+	}
+
+	refs := parseList(service.Annotations[AnnNxProxyProtocolPorts])
+	switch {
+	case ProxyProtocolDisabled(service):
+		// Neither the ports nor the default apply.
+	case len(refs) > 0:
+		if version == 0 {
+			version = ProxyProtocolV1
+		}
+		for _, ref := range refs {
+			if c := find(ref); c != nil {
+				// Only version 2 can describe UDP connections.
+				if c.Protocol == ProtocolUDP && version != ProxyProtocolV2 {
+					errs = append(errs, fmt.Errorf("%s: proxy protocol v1 is not supported for UDP port '%s'", AnnNxProxyProtocolPorts, sanitize.Value(ref)))
+					continue
+				}
+				c.ProxyProtocol = true
+				c.ProxyProtocolVersion = version
+			}
+		}
+	case version != 0:
+		for i := range configs {
+			if configs[i].Protocol != ProtocolUDP {
+				configs[i].ProxyProtocol = true
+				configs[i].ProxyProtocolVersion = version
+			}
+		}
+	}
+
+	if service.Annotations[AnnNxPorts] != "" {
+		var exposed []PortConfig
+		for _, ref := range parseList(service.Annotations[AnnNxPorts]) {
+			if c := find(ref); c != nil {
+				exposed = append(exposed, *c)
+			}
+		}
+		configs = exposed
+	}
+
+	return configs, utilerrors.NewAggregate(errs)
+}
+
+// Split a comma-separated list, ignoring whitespace and empty elements.
+func parseList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+type pair struct {
+	key, value string
+}
+
+// Parse a comma-separated list of key=value pairs. Values are converted to upper case.
+func parsePairs(s, annotation string, errs *[]error) []pair {
+	var pairs []pair
+	for _, e := range parseList(s) {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			*errs = append(*errs, fmt.Errorf("%s: invalid entry '%s', expected port=value", annotation, sanitize.Value(e)))
+			continue
+		}
+		pairs = append(pairs, pair{key: strings.TrimSpace(kv[0]), value: strings.ToUpper(strings.TrimSpace(kv[1]))})
+	}
+	return pairs
+}
+
+// Returns true if the proxy-protocol annotation of the Service is "none". Unlike an unset annotation, this
+// disables the proxy protocol for all ports.
+func ProxyProtocolDisabled(service *corev1.Service) bool {
+	return strings.EqualFold(strings.TrimSpace(service.Annotations[AnnNxProxyProtocol]), "none")
+}
+
+// Parse the value of the proxy-protocol annotation. Returns 0 if it is empty or "none"; use
+// ProxyProtocolDisabled to tell them apart.
+func ParseProxyProtocol(s string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none":
+		return 0, nil
+	case "v1", "1":
+		return ProxyProtocolV1, nil
+	case "v2", "2":
+		return ProxyProtocolV2, nil
+	}
+	return 0, fmt.Errorf("unsupported proxy protocol version '%s'", sanitize.Value(s))
+}
+
+func validateProtocol(protocol string) error {
+	switch protocol {
+	case ProtocolTCP, ProtocolUDP, ProtocolHTTP, ProtocolHTTPS:
+		return nil
+	}
+	return fmt.Errorf("unsupported protocol '%s'", sanitize.Value(protocol))
+}
@@ -0,0 +1,45 @@
+package portconfig
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func FuzzParse(f *testing.F) {
+	f.Add("http,443", "http=https", "443=tcp", "http", "v2")
+	f.Add("", "=", "dns=udp,", " , ", "v1")
+	f.Add("9999", "http=HTTP=x", "", "dns", "none")
+
+	f.Fuzz(func(t *testing.T, ports, protocols, backendProtocols, proxyPorts, proxyProtocol string) {
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					AnnNxPorts:              ports,
+					AnnNxPortProtocols:      protocols,
+					AnnNxBackendProtocols:   backendProtocols,
+					AnnNxProxyProtocolPorts: proxyPorts,
+					AnnNxProxyProtocol:      proxyProtocol,
+				},
+			},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{
+					{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+					{Name: "https", Port: 443, NodePort: 30443},
+					{Name: "dns", Port: 53, NodePort: 30053, Protocol: corev1.ProtocolUDP},
+				},
+			},
+		}
+
+		configs, err := Parse(service)
+		if err != nil {
+			return
+		}
+		for _, c := range configs {
+			if c.Protocol == ProtocolUDP && c.ProxyProtocol && c.ProxyProtocolVersion != ProxyProtocolV2 {
+				t.Errorf("port %d: proxy protocol v%d for UDP", c.Port, c.ProxyProtocolVersion)
+			}
+		}
+	})
+}
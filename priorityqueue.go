@@ -0,0 +1,199 @@
+package lbutil
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type priority int
+
+const (
+	priorityHigh priority = iota
+	priorityLow
+)
+
+// A rate limiting work queue with two tiers: items added with Add are handed out before items added with
+// AddLowPriority, so a resync or audit of many Services never delays a change made by a user. Like the
+// client-go queues, an item is only queued once and never processed by two workers at the same time.
+// Items added again while they wait keep their place, but move to the high tier if added with Add.
+// Delayed and rate limited items are added in the tier they were last queued in when they are due, so a retry
+// of a resync does not preempt changes either.
+type PriorityQueue struct {
+	rateLimiter workqueue.RateLimiter
+
+	lock sync.Mutex
+	cond *sync.Cond
+
+	queues     [2][]interface{}
+	dirty      map[interface{}]priority
+	processing map[interface{}]priority
+	shutdown   bool
+}
+
+// Create a PriorityQueue. If rateLimiter is nil, workqueue.DefaultControllerRateLimiter is used.
+func NewPriorityQueue(rateLimiter workqueue.RateLimiter) *PriorityQueue {
+	if rateLimiter == nil {
+		rateLimiter = workqueue.DefaultControllerRateLimiter()
+	}
+	q := &PriorityQueue{
+		rateLimiter: rateLimiter,
+		dirty:       map[interface{}]priority{},
+		processing:  map[interface{}]priority{},
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// Add an item with high priority.
+func (q *PriorityQueue) Add(item interface{}) {
+	q.add(item, priorityHigh)
+}
+
+// Add an item with low priority. Implements LowPriorityQueue.
+func (q *PriorityQueue) AddLowPriority(item interface{}) {
+	q.add(item, priorityLow)
+}
+
+func (q *PriorityQueue) add(item interface{}, p priority) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.shutdown {
+		return
+	}
+
+	if current, ok := q.dirty[item]; ok {
+		if p < current {
+			q.dirty[item] = p
+			if _, ok := q.processing[item]; !ok {
+				q.remove(item, current)
+				q.queues[p] = append(q.queues[p], item)
+				q.cond.Signal()
+			}
+		}
+		return
+	}
+
+	q.dirty[item] = p
+	if _, ok := q.processing[item]; ok {
+		// Queued again by Done.
+		return
+	}
+	q.queues[p] = append(q.queues[p], item)
+	q.cond.Signal()
+}
+
+// Remove the item from the queue of the priority. Must be called with the lock held.
+func (q *PriorityQueue) remove(item interface{}, p priority) {
+	for i, e := range q.queues[p] {
+		if e == item {
+			q.queues[p] = append(q.queues[p][:i], q.queues[p][i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the number of waiting items.
+func (q *PriorityQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.queues[priorityHigh]) + len(q.queues[priorityLow])
+}
+
+// Returns the next item, high priority items first, blocking until there is one. shutdown is true if
+// the queue was shut down and is empty.
+func (q *PriorityQueue) Get() (item interface{}, shutdown bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.queues[priorityHigh]) == 0 && len(q.queues[priorityLow]) == 0 && !q.shutdown {
+		q.cond.Wait()
+	}
+
+	for _, p := range []priority{priorityHigh, priorityLow} {
+		if len(q.queues[p]) > 0 {
+			item, q.queues[p] = q.queues[p][0], q.queues[p][1:]
+			q.processing[item] = p
+			delete(q.dirty, item)
+			return item, false
+		}
+	}
+
+	return nil, true
+}
+
+// Mark the item as processed. If it was added again meanwhile, it is queued again.
+func (q *PriorityQueue) Done(item interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.processing, item)
+	if p, ok := q.dirty[item]; ok {
+		q.queues[p] = append(q.queues[p], item)
+		q.cond.Signal()
+	}
+}
+
+// Stop accepting items. Get returns the waiting items, then reports the shutdown.
+func (q *PriorityQueue) ShutDown() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.shutdown = true
+	q.cond.Broadcast()
+}
+
+func (q *PriorityQueue) ShuttingDown() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.shutdown
+}
+
+// Add the item after the duration, in the tier it is queued or being processed in; high if it is neither.
+func (q *PriorityQueue) AddAfter(item interface{}, duration time.Duration) {
+	p := q.priority(item)
+	if duration <= 0 {
+		q.add(item, p)
+		return
+	}
+	time.AfterFunc(duration, func() { q.add(item, p) })
+}
+
+// Returns the tier the item is queued or being processed in.
+func (q *PriorityQueue) priority(item interface{}) priority {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if p, ok := q.dirty[item]; ok {
+		return p
+	}
+	if p, ok := q.processing[item]; ok {
+		return p
+	}
+	return priorityHigh
+}
+
+// Add the item when the rate limiter allows it, like AddAfter.
+func (q *PriorityQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+func (q *PriorityQueue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+func (q *PriorityQueue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
+
+// Returns true if an update event is a periodic resync of the informer rather than a change, so the
+// handler can queue the object with low priority.
+func IsResync(oldObj, newObj metav1.Object) bool {
+	return oldObj.GetResourceVersion() == newObj.GetResourceVersion()
+}
@@ -0,0 +1,48 @@
+package lbutil
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Selects the labels and annotations copied from a Service to the IpAddress objects requested for it,
+// for example team, cost center or environment. Keys ending in "*" match all keys with that prefix.
+// Only applied when the IpAddress is created.
+type PropagationPolicy struct {
+	Labels      []string
+	Annotations []string
+}
+
+// Add the selected labels and annotations of the Service to the options.
+func (p *PropagationPolicy) apply(service *corev1.Service, opts *AddressOptions) {
+	opts.Labels = propagate(service.Labels, p.Labels, opts.Labels)
+	opts.Annotations = propagate(service.Annotations, p.Annotations, opts.Annotations)
+}
+
+// Copy the entries of from with keys matching the patterns to to, which is created if needed.
+func propagate(from map[string]string, patterns []string, to map[string]string) map[string]string {
+	for k, v := range from {
+		if !matchesAny(k, patterns) {
+			continue
+		}
+		if to == nil {
+			to = map[string]string{}
+		}
+		to[k] = v
+	}
+	return to
+}
+
+func matchesAny(key string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if key == p {
+			return true
+		}
+	}
+	return false
+}
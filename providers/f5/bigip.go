@@ -0,0 +1,208 @@
+package f5
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// A minimal client for the BIG-IP iControl REST API.
+type BigIP struct {
+	URL      string
+	User     string
+	Password string
+
+	// The partition objects are created in, usually "Common".
+	Partition string
+
+	HTTP *http.Client
+}
+
+type poolMember struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type pool struct {
+	Name      string       `json:"name"`
+	Partition string       `json:"partition"`
+	Monitor   string       `json:"monitor,omitempty"`
+	Members   []poolMember `json:"members"`
+}
+
+type sourceAddressTranslation struct {
+	Type string `json:"type"`
+}
+
+type virtual struct {
+	Name                     string                   `json:"name"`
+	Partition                string                   `json:"partition"`
+	Destination              string                   `json:"destination"`
+	IPProtocol               string                   `json:"ipProtocol"`
+	Pool                     string                   `json:"pool"`
+	SourceAddressTranslation sourceAddressTranslation `json:"sourceAddressTranslation"`
+}
+
+type itemList struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+}
+
+// Returned for HTTP errors from the BIG-IP.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("bigip returned %d: %s", e.StatusCode, e.Message)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*APIError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// The full path of an object, as used in references.
+func (b *BigIP) path(name string) string {
+	return fmt.Sprintf("/%s/%s", b.Partition, name)
+}
+
+// The URL of an object or collection.
+func (b *BigIP) url(collection, name string) string {
+	u := strings.TrimSuffix(b.URL, "/") + "/mgmt/tm/ltm/" + collection
+	if name != "" {
+		u += "/~" + b.Partition + "~" + name
+	}
+	return u
+}
+
+// Send the request; if out is not nil, the response is decoded into it.
+func (b *BigIP) do(method, url string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.User, b.Password)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := b.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &APIError{StatusCode: resp.StatusCode, Message: string(msg)}
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	return nil
+}
+
+// Create the object or replace it if it exists.
+func (b *BigIP) ensure(collection, name string, object interface{}) error {
+	err := b.do(http.MethodPut, b.url(collection, name), object, nil)
+	if isNotFound(err) {
+		return b.do(http.MethodPost, b.url(collection, ""), object, nil)
+	}
+	return err
+}
+
+// Delete the object; objects that do not exist are ignored.
+func (b *BigIP) remove(collection, name string) error {
+	err := b.do(http.MethodDelete, b.url(collection, name), nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// The names of the objects in the collection in the partition.
+func (b *BigIP) list(collection string) ([]string, error) {
+	var c itemList
+	url := b.url(collection, "") + "?$filter=partition+eq+" + b.Partition
+	if err := b.do(http.MethodGet, url, nil, &c); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.Items))
+	for _, item := range c.Items {
+		names = append(names, item.Name)
+	}
+	return names, nil
+}
+
+// Format address and port the way the BIG-IP expects it in destinations and pool member names:
+// address:port for IPv4 and address.port for IPv6.
+func FormatAddrPort(ap netip.AddrPort) string {
+	addr := ap.Addr().Unmap()
+	if addr.Is4() {
+		return fmt.Sprintf("%s:%d", addr, ap.Port())
+	}
+	return fmt.Sprintf("%s.%d", addr, ap.Port())
+}
+
+// Create or update a pool with the members.
+func (b *BigIP) EnsurePool(name string, members []netip.AddrPort) error {
+	p := pool{Name: name, Partition: b.Partition, Monitor: "tcp", Members: []poolMember{}}
+	for _, m := range members {
+		p.Members = append(p.Members, poolMember{Name: FormatAddrPort(m), Address: m.Addr().Unmap().String()})
+	}
+	return b.ensure("pool", name, p)
+}
+
+// Create or update a virtual server for destination forwarding to the pool.
+func (b *BigIP) EnsureVirtual(name string, destination netip.AddrPort, protocol, poolName string) error {
+	v := virtual{
+		Name:                     name,
+		Partition:                b.Partition,
+		Destination:              b.path(FormatAddrPort(destination)),
+		IPProtocol:               strings.ToLower(protocol),
+		Pool:                     b.path(poolName),
+		SourceAddressTranslation: sourceAddressTranslation{Type: "automap"},
+	}
+	return b.ensure("virtual", name, v)
+}
+
+// Delete the virtual server.
+func (b *BigIP) DeleteVirtual(name string) error {
+	return b.remove("virtual", name)
+}
+
+// Delete the pool.
+func (b *BigIP) DeletePool(name string) error {
+	return b.remove("pool", name)
+}
+
+// The names of the virtual servers in the partition.
+func (b *BigIP) Virtuals() ([]string, error) {
+	return b.list("virtual")
+}
+
+// The names of the pools in the partition.
+func (b *BigIP) Pools() ([]string, error) {
+	return b.list("pool")
+}
@@ -0,0 +1,172 @@
+// Reference provider that configures F5 BIG-IP virtual servers for Services with a VIP.
+// For every exposed Service port, a pool with the nodes as members (on the NodePort) and a
+// virtual server on VIP:port are created.
+
+package f5
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/annotations"
+	"github.com/plusserver/k8s-lbutil/portconfig"
+)
+
+// Configures a BIG-IP for Services.
+type Provider struct {
+	Clients    *lbutil.Clients
+	NodeLister corelisterv1.NodeLister
+	BigIP      *BigIP
+
+	ControllerName    string
+	RequireAnnotation bool
+
+	// Resolves the LBConfig referenced by Services. Optional; without it, only annotations are used.
+	LBConfigs *annotations.Resolver
+}
+
+// Name of the pool and virtual server for a Service port.
+func ObjectName(service *corev1.Service, port int32) string {
+	return fmt.Sprintf("%s_%s_%d", service.Namespace, service.Name, port)
+}
+
+// Returns true if name is the name of an object of the Service, for any port. Namespaces and names
+// cannot contain underscores, so the names of different Services cannot be confused.
+func isServiceObject(service *corev1.Service, name string) bool {
+	prefix := fmt.Sprintf("%s_%s_", service.Namespace, service.Name)
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	_, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 16)
+	return err == nil
+}
+
+// Process a Service: ensure it has a VIP, then configure the BIG-IP. If needsUpdate is true, newservice must
+// be updated by the caller.
+func (p *Provider) Process(service *corev1.Service) (newservice *corev1.Service, needsUpdate bool, err error) {
+	result, err := p.Clients.EnsureVIP(service, p.ControllerName, p.RequireAnnotation)
+	if err == nil && result.State == lbutil.StateReleased {
+		// The Service is migrated to another provider.
+		if err := p.Deconfigure(service); err != nil {
+			return nil, false, fmt.Errorf("error deconfiguring bigip for service '%s/%s': %w", service.Namespace, service.Name, err)
+		}
+	}
+	if err != nil || !result.Ready() {
+		return result.Service, result.NeedsUpdate, err
+	}
+
+	if err := p.Configure(result.Service, result.VIP); err != nil {
+		err = fmt.Errorf("error configuring bigip for service '%s/%s': %w", service.Namespace, service.Name, err)
+		lbutil.ServiceLogger(service).Error(err.Error())
+		_ = p.Clients.Events.RecordEvent(service, lbutil.ReasonFailed, err.Error(), true)
+		return result.Service, result.NeedsUpdate, err
+	}
+
+	return result.Service, result.NeedsUpdate, nil
+}
+
+// Create or update the pools and virtual servers for the Service.
+func (p *Provider) Configure(service *corev1.Service, vip string) error {
+	if p.LBConfigs != nil {
+		resolved, err := p.LBConfigs.Resolve(service)
+		if err != nil {
+			return err
+		}
+		service = resolved
+	}
+
+	ports, err := portconfig.Parse(service)
+	if err != nil {
+		return err
+	}
+
+	addr, err := netip.ParseAddr(vip)
+	if err != nil {
+		return fmt.Errorf("invalid vip '%s': %w", vip, err)
+	}
+
+	backends, err := lbutil.Backends(p.NodeLister)
+	if err != nil {
+		return err
+	}
+
+	want := map[string]bool{}
+	for _, port := range ports {
+		name := ObjectName(service, port.Port)
+		want[name] = true
+
+		members := make([]netip.AddrPort, 0, len(backends))
+		for _, b := range backends {
+			a, err := netip.ParseAddr(b.Address)
+			if err != nil {
+				lbutil.ServiceLogger(service).Warnf("ignoring node %s with invalid address '%s'", b.Node, b.Address)
+				continue
+			}
+			members = append(members, netip.AddrPortFrom(a, uint16(port.NodePort)))
+		}
+
+		if err := p.BigIP.EnsurePool(name, members); err != nil {
+			return err
+		}
+
+		protocol := "tcp"
+		if port.Protocol == portconfig.ProtocolUDP {
+			protocol = "udp"
+		}
+
+		if err := p.BigIP.EnsureVirtual(name, netip.AddrPortFrom(addr, uint16(port.Port)), protocol, name); err != nil {
+			return err
+		}
+	}
+
+	// Ports that were removed from the Service.
+	if err := p.deleteObjects(service, want); err != nil {
+		return err
+	}
+
+	lbutil.ServiceLogger(service).Infof("configured %d virtual servers", len(ports))
+
+	return nil
+}
+
+// Remove the virtual servers and pools of the Service. Call from the Service delete handler.
+func (p *Provider) Deconfigure(service *corev1.Service) error {
+	return p.deleteObjects(service, nil)
+}
+
+// Delete the virtual servers and pools of the Service that are not in keep. The objects are listed on the
+// BIG-IP rather than derived from the Service, which may have lost ports since they were created.
+// Virtual servers are deleted first, since a pool cannot be deleted while it is in use.
+func (p *Provider) deleteObjects(service *corev1.Service, keep map[string]bool) error {
+	virtuals, err := p.BigIP.Virtuals()
+	if err != nil {
+		return err
+	}
+	for _, name := range virtuals {
+		if isServiceObject(service, name) && !keep[name] {
+			if err := p.BigIP.DeleteVirtual(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	pools, err := p.BigIP.Pools()
+	if err != nil {
+		return err
+	}
+	for _, name := range pools {
+		if isServiceObject(service, name) && !keep[name] {
+			if err := p.BigIP.DeletePool(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package lbutil
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+
+	// Set this to choose where the VIP of the Service is published, overriding the PublishMode of the controller.
+	AnnNxPublishMode = "nexinto.com/publish-mode"
+
+	// Comma-separated VIPs written to spec.externalIPs or spec.loadBalancerIP, so they are removed again even
+	// if the VIP was reset in between. Maintained by EnsureVIP.
+	AnnNxPublishedVIPs = "nexinto.com/published-vips"
+)
+
+// Where the assigned VIP is written besides the assigned-vip annotation, for dataplanes that read it from
+// somewhere else.
+type PublishMode string
+
+const (
+
+	// Only the assigned-vip annotation. The default.
+	PublishAnnotation PublishMode = "annotation"
+
+	// Add the VIPs to spec.externalIPs. Addresses added by users are kept.
+	PublishExternalIPs PublishMode = "externalIPs"
+
+	// Set spec.loadBalancerIP, unless users set it to another address.
+	PublishLoadBalancerIP PublishMode = "loadBalancerIP"
+
+	// Set status.loadBalancer.ingress. The status must be updated separately (see Result.NeedsStatusUpdate).
+	PublishStatus PublishMode = "status"
+)
+
+// Parse a publish mode. "" is PublishAnnotation.
+func ParsePublishMode(s string) (PublishMode, error) {
+	switch mode := PublishMode(s); mode {
+	case "":
+		return PublishAnnotation, nil
+	case PublishAnnotation, PublishExternalIPs, PublishLoadBalancerIP, PublishStatus:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid publish mode '%s'", s)
+	}
+}
+
+// Returns the publish mode for the Service. An invalid annotation falls back to the mode of the controller.
+func (c *Clients) publishMode(service *corev1.Service) PublishMode {
+	if v := service.Annotations[AnnNxPublishMode]; v != "" {
+		if mode, err := ParsePublishMode(v); err == nil {
+			return mode
+		}
+		c.serviceLogger(service).Warnf("%s: invalid publish mode '%s'", AnnNxPublishMode, v)
+	}
+	if c.PublishMode == "" {
+		return PublishAnnotation
+	}
+	return c.PublishMode
+}
+
+// Returns the loadbalancer ingress for the VIPs of a ready result, or nil.
+func (r *Result) Ingress() []corev1.LoadBalancerIngress {
+	if !r.Ready() {
+		return nil
+	}
+	var ingress []corev1.LoadBalancerIngress
+	for _, vip := range []string{r.VIP, r.SecondaryVIP} {
+		if vip != "" {
+			ingress = append(ingress, corev1.LoadBalancerIngress{IP: vip})
+		}
+	}
+	return ingress
+}
+
+// Set the loadbalancer ingress in the status of the Service. Returns true if it changed. Modifies the Service,
+// which must not come from a cache.
+func SetIngress(service *corev1.Service, ingress []corev1.LoadBalancerIngress) bool {
+	current := service.Status.LoadBalancer.Ingress
+	if len(current) == len(ingress) {
+		same := true
+		for i := range ingress {
+			if current[i].IP != ingress[i].IP || current[i].Hostname != "" {
+				same = false
+			}
+		}
+		if same {
+			return false
+		}
+	}
+
+	service.Status.LoadBalancer.Ingress = ingress
+	return true
+}
+
+// Update the status of the Service. The ServiceUpdater must implement ServiceStatusUpdater.
+func (c *Clients) UpdateServiceStatus(service *corev1.Service) (*corev1.Service, error) {
+	updater, ok := c.Services.(ServiceStatusUpdater)
+	if !ok {
+		return nil, fmt.Errorf("cannot update the status of service '%s/%s': not supported", service.Namespace, service.Name)
+	}
+	return updater.UpdateServiceStatus(service)
+}
+
+// Returns the VIPs published in the spec of the Service. Services published before the annotation was
+// introduced have their assigned VIPs published.
+func publishedVIPs(service *corev1.Service) []string {
+	v, ok := service.Annotations[AnnNxPublishedVIPs]
+	if !ok {
+		return []string{service.Annotations[AnnNxAssignedVIP], service.Annotations[AnnNxAssignedSecondaryVIP]}
+	}
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// Record the VIPs published in the spec of the Service, which must not come from a cache.
+func setPublishedVIPs(service *corev1.Service, vips []string) {
+	service.Annotations[AnnNxPublishedVIPs] = strings.Join(vips, ",")
+}
+
+// Write the VIPs of the result where the publish mode of the Service asks for them. VIPs published before
+// are replaced; they are removed when the VIP is reset.
+func (c *Clients) publish(service *corev1.Service, result *Result) {
+	mode := c.publishMode(result.Service)
+	if mode == PublishAnnotation {
+		return
+	}
+
+	var vips []string
+	switch {
+	case result.Ready():
+		for _, ingress := range result.Ingress() {
+			vips = append(vips, ingress.IP)
+		}
+	case result.Service.Annotations[AnnNxAssignedVIP] != "":
+		// Waiting for something else; keep what is published.
+		return
+	}
+
+	old := publishedVIPs(result.Service)
+
+	modified := result.Service.DeepCopy()
+
+	switch mode {
+	case PublishExternalIPs:
+		var ips []string
+		for _, ip := range modified.Spec.ExternalIPs {
+			if !contains(old, ip) && !contains(vips, ip) {
+				ips = append(ips, ip)
+			}
+		}
+		ips = append(ips, vips...)
+		if equalStrings(ips, modified.Spec.ExternalIPs) && equalStrings(old, vips) {
+			return
+		}
+		modified.Spec.ExternalIPs = ips
+		setPublishedVIPs(modified, vips)
+
+	case PublishLoadBalancerIP:
+		vip := ""
+		if len(vips) > 0 {
+			vip = vips[0]
+		}
+		current := modified.Spec.LoadBalancerIP
+		if current != "" && current != vip && !contains(old, current) {
+			// Set by users.
+			return
+		}
+		var published []string
+		if vip != "" {
+			published = []string{vip}
+		}
+		if current == vip && equalStrings(old, published) {
+			return
+		}
+		modified.Spec.LoadBalancerIP = vip
+		setPublishedVIPs(modified, published)
+
+	case PublishStatus:
+		var ingress []corev1.LoadBalancerIngress
+		for _, vip := range vips {
+			ingress = append(ingress, corev1.LoadBalancerIngress{IP: vip})
+		}
+		if !SetIngress(modified, ingress) {
+			return
+		}
+		result.Service = modified
+		result.NeedsStatusUpdate = true
+		return
+	}
+
+	result.Service = modified
+	result.NeedsUpdate = true
+
+	c.serviceLogger(service).WithField("mode", mode).Debug("published VIP")
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package lbutil
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+const (
+
+	// Finalizer that keeps a deleted IpAddress (and so its address) allocated during the quarantine period.
+	FinalizerNxQuarantine = "nexinto.com/quarantine"
+
+	// The quarantine period of an IpAddress, in time.ParseDuration format.
+	AnnNxQuarantinePeriod = "nexinto.com/quarantine-period"
+)
+
+// Returned when an IpAddress cannot be created because the previous one of the Service is still being deleted.
+// EnsureVIP does not return it, but waits (see Result.RequeueAfter).
+var ErrAddressReleasing = errors.New("the previous ip address is still being released")
+
+// Quarantine the address for the period after the IpAddress is deleted, so IPAM does not hand it to another
+// Service while stale DNS or ARP entries may still point to it. Call before creating the IpAddress.
+func SetQuarantine(address *ipamv1.IpAddress, period time.Duration) {
+	if address.Annotations == nil {
+		address.Annotations = map[string]string{}
+	}
+	address.Annotations[AnnNxQuarantinePeriod] = period.String()
+
+	for _, f := range address.Finalizers {
+		if f == FinalizerNxQuarantine {
+			return
+		}
+	}
+	address.Finalizers = append(address.Finalizers, FinalizerNxQuarantine)
+}
+
+// Returns when the quarantine of a deleted IpAddress ends. ok is false if the address is not being deleted
+// or is not quarantined.
+func QuarantineEnds(address *ipamv1.IpAddress) (end time.Time, ok bool) {
+	if address.DeletionTimestamp == nil || !hasFinalizer(address, FinalizerNxQuarantine) {
+		return time.Time{}, false
+	}
+
+	period, err := time.ParseDuration(address.Annotations[AnnNxQuarantinePeriod])
+	if err != nil {
+		// Without a valid period, release immediately.
+		return address.DeletionTimestamp.Time, true
+	}
+
+	return address.DeletionTimestamp.Add(period), true
+}
+
+// Returns true if the IpAddress is being deleted (and possibly still quarantined). It must no longer be used.
+func IsReleased(address *ipamv1.IpAddress) bool {
+	return address.DeletionTimestamp != nil
+}
+
+// Returns how long to wait before checking again if the released IpAddress is gone: until its quarantine ends,
+// or, if it is not quarantined, the longer the deletion takes, the longer the wait.
+func releaseDelay(address *ipamv1.IpAddress, now time.Time) time.Duration {
+	if end, ok := QuarantineEnds(address); ok && end.After(now) {
+		return end.Sub(now) + RequeueMinDelay
+	}
+
+	delay := RequeueMinDelay
+	if address.DeletionTimestamp != nil {
+		delay = now.Sub(address.DeletionTimestamp.Time)
+	}
+	if delay < RequeueMinDelay {
+		delay = RequeueMinDelay
+	}
+	if delay > RequeueMaxDelay {
+		delay = RequeueMaxDelay
+	}
+	return delay
+}
+
+// Remove the quarantine finalizer if the quarantine of the deleted IpAddress is over, making the address reusable.
+// Returns how long to wait until the quarantine ends if it is not over yet.
+func ReleaseQuarantined(ipamclient ipamclientset.Interface, address *ipamv1.IpAddress, now time.Time) (remaining time.Duration, err error) {
+	end, ok := QuarantineEnds(address)
+	if !ok {
+		return 0, nil
+	}
+
+	if now.Before(end) {
+		return end.Sub(now), nil
+	}
+
+	newaddress := address.DeepCopy()
+	newaddress.Finalizers = nil
+	for _, f := range address.Finalizers {
+		if f != FinalizerNxQuarantine {
+			newaddress.Finalizers = append(newaddress.Finalizers, f)
+		}
+	}
+
+	if _, err := ipamclient.IpamV1().IpAddresses(address.Namespace).Update(newaddress); err != nil && !apierrors.IsNotFound(err) {
+		return 0, fmt.Errorf("error releasing quarantined ipaddress '%s/%s': %w", address.Namespace, address.Name, err)
+	}
+
+	AddressLogger(address).Info("quarantine is over; released the address")
+
+	return 0, nil
+}
+
+// Release quarantined IpAddress objects whose quarantine is over every interval until stopCh is closed.
+func RunQuarantineReleaser(ipamclient ipamclientset.Interface, addressLister ipamlisterv1.IpAddressLister, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		addresses, err := addressLister.List(labels.Everything())
+		if err != nil {
+			log.Errorf("error listing ipaddresses: %s", err.Error())
+			return
+		}
+
+		now := time.Now()
+		for _, address := range addresses {
+			if _, err := ReleaseQuarantined(ipamclient, address, now); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}, interval, stopCh)
+}
+
+func hasFinalizer(address *ipamv1.IpAddress, finalizer string) bool {
+	for _, f := range address.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
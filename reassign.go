@@ -0,0 +1,32 @@
+package lbutil
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+
+	// Set this to a new value (any token, for example a timestamp) to release the VIP of the Service and get
+	// a new one, for example if the address is blacklisted somewhere. Combine with QuarantinePeriod so IPAM
+	// does not hand out the same address again.
+	AnnNxReassign = "nexinto.com/reassign"
+
+	// The last reassign token that was handled. Maintained by EnsureVIP.
+	AnnNxReassigned = "nexinto.com/reassigned"
+)
+
+// Returns true if the reassign token of the Service was not handled yet.
+func ReassignRequested(service *corev1.Service) bool {
+	token := service.Annotations[AnnNxReassign]
+	return token != "" && token != service.Annotations[AnnNxReassigned]
+}
+
+// Mark the reassign token of the Service as handled and forget the IpAddress, so a new one is requested.
+// Modifies the Service, which must not come from a cache.
+func reassigned(service *corev1.Service) *corev1.Service {
+	service.Annotations[AnnNxReassigned] = service.Annotations[AnnNxReassign]
+	delete(service.Annotations, AnnNxIpAddressRef)
+	delete(service.Annotations, AnnNxRetiringVIPs)
+
+	return service
+}
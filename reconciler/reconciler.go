@@ -0,0 +1,150 @@
+// Adapter that runs EnsureVIP as a controller-runtime Reconciler.
+
+package reconciler
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// Reconciles Services using EnsureVIP. The manager's scheme must include the ipam types (ipamv1.AddToScheme).
+type Reconciler struct {
+
+	// Client of the manager; reads are served from its cache.
+	Client client.Client
+
+	Kube       kubernetes.Interface
+	IpamClient ipamclientset.Interface
+
+	ControllerName    string
+	RequireAnnotation bool
+
+	// Called when the VIP for a Service is ready; configure the loadbalancer here. Optional.
+	Configure func(service *corev1.Service, vip string) error
+
+	// Called when the Service is released for another provider; remove the loadbalancer configuration here. Optional.
+	Deconfigure func(service *corev1.Service) error
+}
+
+// Reconcile a single Service.
+func (r *Reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+
+	service := &corev1.Service{}
+	if err := r.Client.Get(ctx, req.NamespacedName, service); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	result, err := lbutil.EnsureVIPResult(r.Kube, r.IpamClient, NewAddressLister(r.Client), service, r.ControllerName, r.RequireAnnotation)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if result.State == lbutil.StateReleased && r.Deconfigure != nil {
+		if err := r.Deconfigure(service); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if result.NeedsUpdate {
+		if err := r.Client.Update(ctx, result.Service); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if result.NeedsStatusUpdate {
+		lbutil.SetIngress(result.Service, result.Ingress())
+		if err := r.Client.Status().Update(ctx, result.Service); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if result.Ready() && r.Configure != nil {
+		if err := r.Configure(result.Service, result.VIP); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	return reconcile.Result{RequeueAfter: result.RequeueAfter}, nil
+}
+
+// Register the Reconciler with the manager. Services are reconciled when they change or when one of their
+// IpAddress objects changes.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	log.WithField(lbutil.LogFieldProvider, r.ControllerName).Debug("setting up reconciler")
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(r.ControllerName).
+		For(&corev1.Service{}).
+		Watches(&source.Kind{Type: &ipamv1.IpAddress{}}, &handler.EnqueueRequestForOwner{
+			OwnerType:    &corev1.Service{},
+			IsController: false,
+		}).
+		Complete(r)
+}
+
+// An IpAddressLister backed by a controller-runtime client.
+type addressLister struct {
+	client    client.Reader
+	namespace string
+}
+
+// Returns an IpAddressLister that reads from a controller-runtime client, for use with EnsureVIP.
+func NewAddressLister(c client.Reader) ipamlisterv1.IpAddressLister {
+	return &addressLister{client: c}
+}
+
+func (l *addressLister) List(selector labels.Selector) ([]*ipamv1.IpAddress, error) {
+	list := &ipamv1.IpAddressList{}
+
+	opts := []client.ListOption{client.MatchingLabelsSelector{Selector: selector}}
+	if l.namespace != "" {
+		opts = append(opts, client.InNamespace(l.namespace))
+	}
+
+	if err := l.client.List(context.Background(), list, opts...); err != nil {
+		return nil, err
+	}
+
+	addresses := make([]*ipamv1.IpAddress, 0, len(list.Items))
+	for i := range list.Items {
+		addresses = append(addresses, &list.Items[i])
+	}
+
+	return addresses, nil
+}
+
+func (l *addressLister) IpAddresses(namespace string) ipamlisterv1.IpAddressNamespaceLister {
+	return &addressLister{client: l.client, namespace: namespace}
+}
+
+func (l *addressLister) Get(name string) (*ipamv1.IpAddress, error) {
+	address := &ipamv1.IpAddress{}
+	if err := l.client.Get(context.Background(), types.NamespacedName{Namespace: l.namespace, Name: name}, address); err != nil {
+		return nil, err
+	}
+	return address, nil
+}
@@ -0,0 +1,163 @@
+package lbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+const (
+
+	// Set on the ConfigMaps of the provider registry. The name of the provider.
+	LabelNxProvider = "nexinto.com/provider"
+
+	// The key of the ConfigMap data with the ProviderInfo as JSON.
+	ProviderInfoKey = "provider.json"
+
+	// Prefix of the names of the ConfigMaps of the provider registry.
+	ProviderConfigMapPrefix = "lbutil-provider-"
+)
+
+// Address families of VIPs.
+const (
+	FamilyIPv4 = addresses.IPv4
+	FamilyIPv6 = addresses.IPv6
+)
+
+// What a provider supports, as published in the registry.
+type ProviderInfo struct {
+	Name string `json:"name"`
+
+	// The address pools the provider can serve. Empty if it serves any pool.
+	Pools []string `json:"pools,omitempty"`
+
+	// The address families (FamilyIPv4, FamilyIPv6) the provider can serve. Empty if it serves any.
+	Families []string `json:"families,omitempty"`
+
+	// Free-form names of optional features, for example "proxy-protocol".
+	Features []string `json:"features,omitempty"`
+}
+
+// Publish the provider in the registry, a ConfigMap per provider in the namespace.
+func RegisterProvider(kube kubernetes.Interface, namespace string, info ProviderInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      ProviderConfigMapPrefix + info.Name,
+			Labels:    map[string]string{LabelNxProvider: info.Name},
+		},
+		Data: map[string]string{ProviderInfoKey: string(data)},
+	}
+
+	configMaps := kube.CoreV1().ConfigMaps(namespace)
+
+	_, err = configMaps.Create(cm)
+	if errors.IsAlreadyExists(err) {
+		var old *corev1.ConfigMap
+		old, err = configMaps.Get(cm.Name, metav1.GetOptions{})
+		if err == nil {
+			cm.ResourceVersion = old.ResourceVersion
+			_, err = configMaps.Update(cm)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error registering provider '%s': %w", info.Name, err)
+	}
+
+	return nil
+}
+
+// Remove the provider from the registry.
+func UnregisterProvider(kube kubernetes.Interface, namespace, name string) error {
+	err := kube.CoreV1().ConfigMaps(namespace).Delete(ProviderConfigMapPrefix+name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error unregistering provider '%s': %w", name, err)
+	}
+	return nil
+}
+
+// Reads the provider registry.
+type Registry struct {
+	ConfigMaps corelisterv1.ConfigMapLister
+	Namespace  string
+}
+
+// Returns the registered providers, sorted by name. Invalid entries are skipped.
+func (r *Registry) Providers() ([]ProviderInfo, error) {
+	configMaps, err := r.ConfigMaps.ConfigMaps(r.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing provider registry: %w", err)
+	}
+
+	var providers []ProviderInfo
+	for _, cm := range configMaps {
+		if cm.Labels[LabelNxProvider] == "" {
+			continue
+		}
+		info := ProviderInfo{}
+		if err := json.Unmarshal([]byte(cm.Data[ProviderInfoKey]), &info); err != nil || info.Name == "" {
+			continue
+		}
+		providers = append(providers, info)
+	}
+
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+
+	return providers, nil
+}
+
+// Returns the first registered provider that supports the pool and address family of the Service,
+// or "" if there is none.
+func (r *Registry) Select(service *corev1.Service) (string, error) {
+	providers, err := r.Providers()
+	if err != nil {
+		return "", err
+	}
+
+	pool := service.Annotations[AnnNxVIPPool]
+	family := serviceFamily(service)
+
+	for _, p := range providers {
+		if (pool == "" || len(p.Pools) == 0 || contains(p.Pools, pool)) &&
+			(family == "" || len(p.Families) == 0 || contains(p.Families, family)) {
+			return p.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Returns the address family the Service asks for, or "" if it does not care.
+func serviceFamily(service *corev1.Service) string {
+	if family := addresses.FamilyOf(service.Annotations[AnnNxRequestedIP]); family != "" {
+		return family
+	}
+	if service.Spec.IPFamily != nil {
+		return string(*service.Spec.IPFamily)
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
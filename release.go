@@ -0,0 +1,112 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// What happens to the IpAddress of a Service when the Service is deleted.
+type ReleasePolicy string
+
+const (
+
+	// The IpAddress is deleted with the Service. The default.
+	ReleasePolicyDelete ReleasePolicy = "Delete"
+
+	// The IpAddress is kept, so a Service created again with the same name gets the same VIP.
+	// Retained addresses have no owner reference; delete them manually when they are no longer needed.
+	// This needs a NamingStrategy that does not depend on the UID of the Service: with NameWithHash (or a
+	// template using .UID or .Hash) a Service created again requests a new address.
+	ReleasePolicyRetain ReleasePolicy = "Retain"
+)
+
+// Set this to choose the release policy of the Service, overriding the ReleasePolicy of the Clients.
+const AnnNxReleasePolicy = "nexinto.com/release-policy"
+
+// Returns the release policy for the Service.
+func (c *Clients) releasePolicy(service *corev1.Service) ReleasePolicy {
+	switch ReleasePolicy(service.Annotations[AnnNxReleasePolicy]) {
+	case ReleasePolicyDelete:
+		return ReleasePolicyDelete
+	case ReleasePolicyRetain:
+		return ReleasePolicyRetain
+	}
+	if c.ReleasePolicy == "" {
+		return ReleasePolicyDelete
+	}
+	return c.ReleasePolicy
+}
+
+// Release the VIP of a deleted Service claimed by the controller. Call from the delete handler of the Service
+// informer, so the address does not wait for the garbage collector. deconfigure removes the loadbalancer
+// configuration and is optional. The IpAddress is deleted unless the release policy is Retain.
+func (c *Clients) OnServiceDeleted(service *corev1.Service, controllerName string, deconfigure func(service *corev1.Service) error) error {
+	forgetPause(service)
+
+	if service.Annotations[AnnNxVIPActiveProvider] != controllerName {
+		return nil
+	}
+
+	if deconfigure != nil {
+		if err := deconfigure(service); err != nil {
+			return fmt.Errorf("error deconfiguring loadbalancer for service '%s/%s': %w", service.Namespace, service.Name, err)
+		}
+	}
+
+	vip := service.Annotations[AnnNxAssignedVIP]
+
+	if c.Hooks != nil && c.Hooks.OnVIPReleased != nil {
+		for _, v := range ownVIPs(service, controllerName) {
+			if err := c.Hooks.OnVIPReleased(service, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.releasePolicy(service) == ReleasePolicyRetain {
+		c.serviceLogger(service).Info("service was deleted; retaining the ip address")
+		return nil
+	}
+
+	namespace, name := c.AddressKey(service)
+	address, err := c.Addresses.GetIpAddress(namespace, name)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error looking up ipaddress object for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+	if err != nil || !ownedBy(address, service) {
+		address = nil
+	}
+
+	if err := c.deleteAddresses(service, address); err != nil {
+		return err
+	}
+
+	if vip != "" {
+		_ = c.Events.RecordEvent(service, ReasonVIPReleased, fmt.Sprintf("released VIP %s", vip), false)
+	}
+
+	return nil
+}
+
+// Returns true if the IpAddress belongs to the Service. Addresses without any owner are assumed to belong to it,
+// as are addresses retained for a Service of the same name.
+func ownedBy(address *ipamv1.IpAddress, service *corev1.Service) bool {
+	uids := addressOwnerUIDs(address)
+	if service.UID == "" || len(uids) == 0 {
+		return true
+	}
+	if retained(address) && address.Namespace == service.Namespace && address.Labels[LabelNxServiceName] == service.Name {
+		return true
+	}
+	for _, uid := range uids {
+		if uid == service.UID {
+			return true
+		}
+	}
+	return false
+}
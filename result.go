@@ -0,0 +1,370 @@
+package lbutil
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+// The outcome of EnsureVIPResult.
+type Result struct {
+
+	// The state of the Service after the actions were performed.
+	State State
+
+	// The VIP for the Service. Only set if State is StateReady.
+	VIP string
+
+	// The Service to use for further processing. If NeedsUpdate is true, this is a modified copy
+	// that must be updated by the caller. Nil if the Service is skipped or processing cannot continue yet.
+	Service *corev1.Service
+
+	// True if Service was modified and needs to be updated by the caller.
+	NeedsUpdate bool
+
+	// True if the status of Service was modified and needs to be updated by the caller with the status
+	// subresource (see PublishStatus). If the Service is updated first, set the Ingress on the updated Service
+	// (see SetIngress).
+	NeedsStatusUpdate bool
+
+	// If not zero, the caller should process the Service again after this duration. Set while waiting for IPAM,
+	// so the Service is not stuck if an IpAddress watch event is missed.
+	RequeueAfter time.Duration
+
+	// The VIP of the second address family of a dual-stack Service, if it has one (see ServiceFamilies).
+	SecondaryVIP string
+
+	// A human-readable explanation of the result.
+	Reason string
+
+	// The actions that were performed.
+	Actions []Action
+}
+
+// Bounds of the RequeueAfter hint while waiting for IPAM. Up to RequeueJitter times the delay is added, so
+// Services requested together are not all checked again at the same time.
+var (
+	RequeueMinDelay = time.Second
+	RequeueMaxDelay = 5 * time.Minute
+	RequeueJitter   = 0.2
+)
+
+// Returns how long to wait before checking the IpAddress again: the longer IPAM has not assigned
+// an address, the longer the wait. address is nil if it was just requested.
+func requeueDelay(address *ipamv1.IpAddress, now time.Time) time.Duration {
+	delay := RequeueMinDelay
+	if address != nil {
+		delay = now.Sub(address.CreationTimestamp.Time)
+	}
+
+	if delay < RequeueMinDelay {
+		delay = RequeueMinDelay
+	}
+	if delay > RequeueMaxDelay {
+		delay = RequeueMaxDelay
+	}
+	if RequeueJitter > 0 {
+		delay = wait.Jitter(delay, RequeueJitter)
+	}
+	return delay
+}
+
+// Add the Service to RequeueQueue after the RequeueAfter of the result, if both are set.
+func (c *Clients) scheduleRequeue(service *corev1.Service, result *Result) {
+	if c.RequeueQueue == nil || result.RequeueAfter <= 0 {
+		return
+	}
+	c.RequeueQueue.AddAfter(service.Namespace+"/"+service.Name, result.RequeueAfter)
+	c.serviceLogger(service).WithField("after", result.RequeueAfter).Debug("scheduled recheck")
+}
+
+// Returns true if the VIP is valid and the caller can configure the loadbalancer.
+func (r *Result) Ready() bool {
+	return r.State == StateReady
+}
+
+// Like EnsureVIP, but returns a Result.
+func EnsureVIPResult(kube kubernetes.Interface, ipamclient ipamclientset.Interface, addressLister ipamlisterv1.IpAddressLister,
+	service *corev1.Service, controllerName string, requireAnnotation bool) (*Result, error) {
+
+	return NewClients(kube, ipamclient, addressLister).EnsureVIP(service, controllerName, requireAnnotation)
+}
+
+// Like EnsureVIPResult, using the Clients.
+func (c *Clients) EnsureVIP(service *corev1.Service, controllerName string, requireAnnotation bool) (*Result, error) {
+	return c.Ensure(service, controllerName, WithRequireAnnotation(requireAnnotation))
+}
+
+func (c *Clients) ensure(service *corev1.Service, controllerName string, requireAnnotation bool) (*Result, error) {
+	service, upgraded, err := DecodeAnnotations(service)
+	if err != nil {
+		return &Result{State: StateSkipped, Reason: err.Error()}, Permanent(err)
+	}
+
+	claimed, err := c.takeover(service, controllerName)
+	if err != nil {
+		return &Result{State: StateSkipped, Reason: err.Error()}, err
+	}
+	if claimed != nil {
+		service = claimed
+	}
+
+	obs, err := c.Observe(service, controllerName, requireAnnotation)
+	if err != nil {
+		return &Result{State: obs.State(), Reason: err.Error()}, err
+	}
+
+	state := obs.State()
+
+	if state == StateUnclaimed && c.Registry != nil && service.Annotations[AnnNxVIPProvider] == "" {
+		selected, err := c.Registry.Select(service)
+		if err != nil {
+			return &Result{State: state, Reason: err.Error()}, err
+		}
+		if selected != "" && selected != controllerName {
+			return &Result{State: StateSkipped, Reason: fmt.Sprintf("the registry selects provider '%s'", selected)}, nil
+		}
+	}
+
+	c.reportPause(service, state == StatePaused)
+	if state == StatePaused {
+		c.logStep(obs, state)
+		return &Result{State: state, Reason: obs.describe(state)}, nil
+	}
+
+	next, actions := Step(obs)
+
+	// Only changes need the Lease; resyncs of Services that are done leave it alone.
+	if len(actions) > 0 && c.Locker != nil {
+		locked, err := c.Locker.TryLock(service, controllerName)
+		if err != nil {
+			return &Result{State: state, Reason: err.Error()}, err
+		}
+		if !locked {
+			return &Result{
+				State:        state,
+				Reason:       "service is locked by another replica",
+				RequeueAfter: c.Locker.leaseDuration(),
+			}, nil
+		}
+	}
+
+	c.logStep(obs, state)
+
+	result := &Result{
+		State:   next,
+		Service: service,
+		Reason:  obs.describe(state),
+		Actions: actions,
+	}
+
+	for _, action := range actions {
+		switch action {
+		case ActionClaim:
+			result.Service = ClaimService(service, controllerName)
+		case ActionRequestAddress:
+			if c.WarmPool != nil {
+				if c.adoptFromWarmPool(result) {
+					continue
+				}
+			}
+			if obs.Releasing != nil {
+				c.waitForRelease(result, state, releaseDelay(obs.Releasing, time.Now()))
+				continue
+			}
+			if obs.Stale != nil {
+				if err = c.deleteStale(service, obs.Stale); err != nil {
+					continue
+				}
+			}
+			if err = c.RequestAddress(service); errors.Is(err, ErrAddressReleasing) {
+				// The cache has not seen the deletion yet.
+				c.waitForRelease(result, state, requeueDelay(nil, time.Now()))
+				err = nil
+			}
+		case ActionStoreVIP:
+			if err = c.checkAllowed(service, obs.Address.Status.Address); err != nil {
+				result.State = state
+				continue
+			}
+			result.Service = c.StoreVIP(obs.Address.Status.Address, service)
+		case ActionResetVIP:
+			result.Service = c.StoreVIP("", service)
+			if obs.Address == nil {
+				// The referenced address is gone; request a new one under the default name.
+				delete(result.Service.Annotations, AnnNxIpAddressRef)
+			}
+		case ActionRelease:
+			result.Service = ReleaseService(service, controllerName, time.Now())
+		case ActionDeleteAddress:
+			err = c.deleteAddresses(service, obs.Address)
+		case ActionReassign:
+			if err == nil {
+				result.Service = reassigned(c.StoreVIP("", service))
+			}
+		case ActionUnclaim:
+			if err == nil {
+				result.Service = UnclaimService(service)
+			}
+		case ActionFinishMigration:
+			result.Service = finishMigration(result.Service)
+		case ActionUpdateService:
+			result.NeedsUpdate = err == nil
+		}
+	}
+
+	if result.Ready() && err == nil && c.TransferOverlap != 0 {
+		err = c.transfer(obs, result)
+	}
+
+	if result.Ready() && err == nil {
+		err = c.ensureSecondary(result)
+	}
+
+	// A conflict is not an error: it is stored as the state of the Service, so it is reported only once.
+	var conflictErr error
+	if result.Ready() && err == nil && c.ServiceIndexer != nil {
+		if err = c.CheckVIPConflict(c.ServiceIndexer, result.Service); IsVIPConflict(err) {
+			result.State = StateConflict
+			conflictErr, err = err, nil
+		}
+	}
+
+	if c.Hooks != nil && err == nil && conflictErr == nil {
+		if err = c.Hooks.run(service, result, controllerName); err != nil {
+			// The transition did not happen.
+			result.State = state
+			result.Service = service
+			result.NeedsUpdate = false
+		}
+	}
+
+	if result.State == StateRequested && err == nil && result.RequeueAfter == 0 {
+		result.RequeueAfter = requeueDelay(obs.Address, time.Now())
+	}
+
+	stateErr := err
+	if conflictErr != nil {
+		stateErr = conflictErr
+		result.Reason = stateErr.Error()
+	} else if obs.pinnedDrift() && err == nil {
+		stateErr = c.reportPinnedDrift(service, obs.Address)
+		result.Reason = stateErr.Error()
+	}
+
+	if next != StateSkipped {
+		c.updateVIPState(service, result, stateErr)
+	}
+
+	if result.Ready() {
+		result.VIP = result.Service.Annotations[AnnNxAssignedVIP]
+	}
+
+	if next != StateSkipped {
+		c.publish(service, result)
+	}
+
+	if (upgraded || claimed != nil) && next != StateSkipped && result.Service != nil {
+		// Store the annotations in the current schema, or the claim of the taken over Service.
+		result.NeedsUpdate = true
+	}
+
+	if !result.Ready() && !result.NeedsUpdate && !result.NeedsStatusUpdate {
+		result.Service = nil
+	}
+
+	if err != nil {
+		result.Reason = err.Error()
+	}
+
+	// Only report what is stored on the Service; after an update, the next reconcile reports.
+	if c.Bindings != nil && !result.NeedsUpdate {
+		if berr := c.Bindings.Report(service, result); berr != nil {
+			c.serviceLogger(service).Warnf("error reporting status: %s", berr.Error())
+		}
+	}
+
+	c.scheduleRequeue(service, result)
+
+	return result, err
+}
+
+// Keep the Service in its state until the previous IpAddress is deleted, then check again.
+func (c *Clients) waitForRelease(result *Result, state State, after time.Duration) {
+	result.State = state
+	result.Reason = ErrAddressReleasing.Error()
+	result.RequeueAfter = after
+	c.serviceLogger(result.Service).WithField("after", after).Info("waiting for the previous ip address to be released")
+}
+
+// Try to adopt an address from the warm pool. On success, the VIP is stored on the Service, which is then ready.
+func (c *Clients) adoptFromWarmPool(result *Result) bool {
+	service := result.Service
+
+	address, err := c.WarmPool.adopt(service, c.poolFor(service), c.allowed)
+	if err != nil {
+		c.serviceLogger(service).Warnf("cannot use warm pool: %s", err.Error())
+		return false
+	}
+	if address == nil {
+		return false
+	}
+
+	if address.Namespace != service.Namespace || address.Name != service.Name {
+		service = service.DeepCopy()
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		service.Annotations[AnnNxIpAddressRef] = address.Namespace + "/" + address.Name
+	}
+
+	result.Service = c.StoreVIP(address.Status.Address, service)
+	result.State = StateReady
+	result.NeedsUpdate = true
+	result.Reason = "adopted an address from the warm pool"
+	result.Actions = append(result.Actions, ActionStoreVIP, ActionUpdateService)
+
+	return true
+}
+
+// Record a Warning Event when IPAM changes the address of a pinned VIP. The message is kept as the last error
+// in the VIP state, so the Event is only recorded once.
+func (c *Clients) reportPinnedDrift(service *corev1.Service, address *ipamv1.IpAddress) error {
+	err := fmt.Errorf("IPAM changed the address to %s, keeping the pinned VIP %s", address.Status.Address, service.Annotations[AnnNxAssignedVIP])
+
+	if state, _ := GetVIPState(service); state == nil || state.LastError != err.Error() {
+		c.serviceLogger(service).Warn(err.Error())
+		_ = c.Events.RecordEvent(service, ReasonVIPPinned, err.Error(), true)
+	}
+
+	return err
+}
+
+// Store the state of the result on the Service. The Service is only copied if the state changed and
+// it was not modified yet.
+func (c *Clients) updateVIPState(service *corev1.Service, result *Result, err error) {
+	var lastError string
+	if err != nil {
+		lastError = err.Error()
+	}
+
+	if result.Service == service {
+		if _, changed := vipStateValue(service, result.State, lastError, time.Now()); !changed {
+			return
+		}
+		result.Service = service.DeepCopy()
+	}
+
+	if SetVIPState(result.Service, result.State, lastError, time.Now()) {
+		result.NeedsUpdate = true
+	}
+}
@@ -0,0 +1,119 @@
+package lbutil_test
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/fake"
+)
+
+const benchmarkController = "bench"
+
+// Stores updated Services in memory.
+type serviceStore map[string]*corev1.Service
+
+func (s serviceStore) UpdateService(service *corev1.Service) (*corev1.Service, error) {
+	s[service.Namespace+"/"+service.Name] = service
+	return service, nil
+}
+
+func benchmarkClients(b *testing.B) *lbutil.Clients {
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	b.Cleanup(func() { log.SetLevel(level) })
+
+	ipam := fake.NewIPAM()
+	if err := ipam.AddPool(fake.DefaultPool, "10.0.0.0/16", 0); err != nil {
+		b.Fatal(err)
+	}
+
+	return &lbutil.Clients{
+		Addresses:      ipam,
+		AddressCreator: ipam,
+		Services:       serviceStore{},
+		Events:         lbutil.SinkEventRecorder(fake.NewEventSink()),
+	}
+}
+
+func benchmarkService(i int, serviceType corev1.ServiceType) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      fmt.Sprintf("service-%d", i),
+			UID:       "uid",
+		},
+		Spec: corev1.ServiceSpec{Type: serviceType},
+	}
+}
+
+// Run EnsureVIP until the Service is ready and return it.
+func readyService(b *testing.B, c *lbutil.Clients, service *corev1.Service) *corev1.Service {
+	for step := 0; step < 10; step++ {
+		result, err := c.EnsureVIP(service, benchmarkController, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.NeedsUpdate {
+			service = result.Service
+			continue
+		}
+		if result.Ready() {
+			return service
+		}
+	}
+	b.Fatalf("service '%s/%s' is not ready", service.Namespace, service.Name)
+	return nil
+}
+
+func benchmarkEnsureVIP(b *testing.B, c *lbutil.Clients, services []*corev1.Service) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := c.EnsureVIP(services[i%len(services)], benchmarkController, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The resync of Services that are ready; nothing changes.
+func BenchmarkEnsureVIPReady(b *testing.B) {
+	c := benchmarkClients(b)
+
+	services := make([]*corev1.Service, 100)
+	for i := range services {
+		services[i] = readyService(b, c, benchmarkService(i, corev1.ServiceTypeNodePort))
+	}
+
+	benchmarkEnsureVIP(b, c, services)
+}
+
+// Services this controller does not handle.
+func BenchmarkEnsureVIPSkipped(b *testing.B) {
+	c := benchmarkClients(b)
+
+	services := make([]*corev1.Service, 100)
+	for i := range services {
+		services[i] = benchmarkService(i, corev1.ServiceTypeClusterIP)
+	}
+
+	benchmarkEnsureVIP(b, c, services)
+}
+
+// New Services that are claimed; the update is not stored, so every iteration claims again.
+func BenchmarkEnsureVIPClaim(b *testing.B) {
+	c := benchmarkClients(b)
+
+	services := make([]*corev1.Service, 100)
+	for i := range services {
+		services[i] = benchmarkService(i, corev1.ServiceTypeNodePort)
+	}
+
+	benchmarkEnsureVIP(b, c, services)
+}
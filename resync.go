@@ -0,0 +1,93 @@
+package lbutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+
+	corev1 "k8s.io/api/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+// Optionally implemented by a work queue to add items that yield to other items, like the initial resync.
+type LowPriorityQueue interface {
+	AddLowPriority(item interface{})
+}
+
+// Add the item to the queue with low priority if the queue implements LowPriorityQueue, otherwise like Add.
+func EnqueueLowPriority(queue workqueue.Interface, item interface{}) {
+	if low, ok := queue.(LowPriorityQueue); ok {
+		low.AddLowPriority(item)
+		return
+	}
+	queue.Add(item)
+}
+
+// The prefix of the annotations that mark a Service as handled by lbutil.
+const annotationPrefix = "nexinto.com/"
+
+// Add the key of every Service that has lbutil annotations or owns an IpAddress to the queue, once each,
+// so state left over from downtime is repaired without waiting for the next change. Keys are added with
+// low priority if the queue implements LowPriorityQueue. Call once after the caches are synced (see
+// Startup.InitialResync). Returns the number of keys added.
+func EnqueueAll(queue workqueue.Interface, serviceLister corelisterv1.ServiceLister, addressLister ipamlisterv1.IpAddressLister) (int, error) {
+	keys := map[string]bool{}
+
+	services, err := serviceLister.List(labels.Everything())
+	if err != nil {
+		return 0, fmt.Errorf("error listing services: %w", err)
+	}
+	for _, service := range services {
+		if hasLBUtilAnnotation(service) {
+			keys[service.Namespace+"/"+service.Name] = true
+		}
+	}
+
+	if addressLister != nil {
+		addresses, err := addressLister.List(labels.Everything())
+		if err != nil {
+			return 0, fmt.Errorf("error listing ipaddresses: %w", err)
+		}
+		for _, address := range addresses {
+			for _, ref := range address.OwnerReferences {
+				if ref.Kind == "Service" {
+					keys[address.Namespace+"/"+ref.Name] = true
+				}
+			}
+			if namespace, name, ok := adoptedServiceKey(address); ok {
+				keys[namespace+"/"+name] = true
+			}
+		}
+	}
+
+	// Sorted, so the order is predictable.
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		EnqueueLowPriority(queue, key)
+	}
+
+	log.Infof("queued %d services for the initial resync", len(sorted))
+
+	return len(sorted), nil
+}
+
+func hasLBUtilAnnotation(service *corev1.Service) bool {
+	for key := range service.Annotations {
+		if strings.HasPrefix(key, annotationPrefix) {
+			return true
+		}
+	}
+	return false
+}
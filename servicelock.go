@@ -0,0 +1,162 @@
+package lbutil
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Prefix of the names of the Leases created by ServiceLocker. The Lease for a Service is created in its namespace
+// and named after the prefix, the controller and the Service.
+const ServiceLeasePrefix = "lbutil-"
+
+// The LeaseDuration of a ServiceLocker if none is set.
+const DefaultServiceLeaseDuration = 15 * time.Second
+
+// Makes sure only one replica of a provider modifies a Service at a time, using a coordination.k8s.io Lease
+// per controller and Service. A replica takes the Lease when the Service needs changes and keeps it while it
+// keeps changing the Service at least once per LeaseDuration; other replicas get the Service when the Lease
+// expires. Reconciles that change nothing do not touch the Lease. Leases are owned by their Service and are
+// garbage collected with it.
+type ServiceLocker struct {
+	Kube kubernetes.Interface
+
+	// Identifies the replica, for example the Pod name.
+	Identity string
+
+	// How long the Lease is held after the last change. If zero, DefaultServiceLeaseDuration is used.
+	LeaseDuration time.Duration
+}
+
+// The name of the Lease of the controller for the Service.
+func serviceLeaseName(service *corev1.Service, controllerName string) string {
+	return ServiceLeasePrefix + controllerName + "-" + service.Name
+}
+
+// Returns the LeaseDuration, or the default if it is not set.
+func (l *ServiceLocker) leaseDuration() time.Duration {
+	if l.LeaseDuration == 0 {
+		return DefaultServiceLeaseDuration
+	}
+	return l.LeaseDuration
+}
+
+// Acquire or renew the Lease of the controller for the Service. Returns false if another replica holds it.
+func (l *ServiceLocker) TryLock(service *corev1.Service, controllerName string) (bool, error) {
+	if l.LeaseDuration < 0 || l.LeaseDuration > 0 && l.LeaseDuration < time.Second {
+		return false, fmt.Errorf("invalid lease duration %s: must be at least 1s", l.LeaseDuration)
+	}
+
+	leases := l.Kube.CoordinationV1().Leases(service.Namespace)
+	name := serviceLeaseName(service, controllerName)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(l.leaseDuration() / time.Second)
+
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: service.Namespace,
+				Name:      name,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if service.UID != "" {
+			lease.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Service",
+				Name:       service.Name,
+				UID:        service.UID,
+			}}
+		}
+		_, err = leases.Create(lease)
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error creating lease for service '%s/%s': %w", service.Namespace, service.Name, err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting lease for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+
+	if holder != l.Identity && holder != "" && !leaseExpired(lease, now.Time) {
+		return false, nil
+	}
+
+	lease = lease.DeepCopy()
+	if holder != l.Identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.HolderIdentity = &l.Identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+
+	_, err = leases.Update(lease)
+	if errors.IsConflict(err) {
+		// Another replica got there first.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating lease for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	return true, nil
+}
+
+// Give up the Lease of the controller for the Service if this replica holds it, so another replica can take
+// over at once.
+func (l *ServiceLocker) Unlock(service *corev1.Service, controllerName string) error {
+	leases := l.Kube.CoordinationV1().Leases(service.Namespace)
+	name := serviceLeaseName(service, controllerName)
+
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting lease for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.Identity {
+		return nil
+	}
+
+	rv := lease.ResourceVersion
+	err = leases.Delete(name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &rv}})
+	if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+		return fmt.Errorf("error deleting lease for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	return nil
+}
+
+// Returns true if the Lease was not renewed within its duration.
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(now)
+}
@@ -0,0 +1,349 @@
+package lbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+
+	"github.com/plusserver/k8s-lbutil/allocator"
+)
+
+// The keys of the ConfigMap data of an IPAMSimulator, with its allocations and released addresses as JSON.
+const (
+	SimIPAMAllocationsKey = "allocations"
+	SimIPAMReleasedKey    = "released"
+)
+
+// The network SimIPAM assigns addresses from if no other network is configured.
+const DefaultSimIPAMNetwork = "10.0.0.0/16"
+
+// Simulates the behaviour of the ipam controller, for tests and development clusters. Remembers which
+// address it assigned to which IpAddress (see allocator.Allocator), so an address is never handed out twice,
+// even if an update failed after the address was chosen. Addresses already assigned to IpAddress objects are never reused.
+// The address of a deleted IpAddress is released when Run notices it is gone or Deleted is called, and
+// handed out again after ReuseDelay, even to an IpAddress created again with the same name.
+//
+// The network of an IpAddress is chosen by its pool (the vip-pool annotation) in Pools, then by its namespace
+// in NamespaceNetworks, then Network. If Pools is set, IpAddresses in pools that are not configured are not
+// assigned, like with a real IPAM; otherwise the pool is ignored.
+type IPAMSimulator struct {
+	IpamClient ipamclientset.Interface
+
+	// Networks in CIDR notation. If Network is empty, DefaultSimIPAMNetwork is used.
+	Network           string
+	Pools             map[string]string
+	NamespaceNetworks map[string]string
+
+	// If set, the allocations are also stored in the ConfigMap, so they survive restarts.
+	Kube               kubernetes.Interface
+	ConfigMapNamespace string
+	ConfigMapName      string
+
+	// How long a released address is not handed out again, like the quarantine of a real IPAM.
+	ReuseDelay time.Duration
+
+	// How old an IpAddress must be before it is assigned, to simulate a slow IPAM.
+	AssignDelay time.Duration
+
+	lock      sync.Mutex
+	allocator *allocator.Allocator
+}
+
+// Create an IPAMSimulator keeping its allocations in memory.
+func NewIPAMSimulator(ipamclient ipamclientset.Interface) *IPAMSimulator {
+	return &IPAMSimulator{IpamClient: ipamclient}
+}
+
+// Simulates the behaviour of the ipam controller once, without remembering the allocations
+// (see IPAMSimulator).
+func SimIPAM(ipamclient ipamclientset.Interface) error {
+	_, err := NewIPAMSimulator(ipamclient).Run()
+	return err
+}
+
+// Assign addresses to all IpAddress objects without one, and release the addresses of IpAddress objects
+// that no longer exist. Returns how long until the first IpAddress that is younger than AssignDelay can be
+// assigned, so Run can be called again then; 0 if there is none.
+func (s *IPAMSimulator) Run() (next time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.init(); err != nil {
+		return 0, err
+	}
+
+	addrs, err := s.IpamClient.IpamV1().IpAddresses(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(addrs.Items, func(i, j int) bool {
+		return addrs.Items[i].Namespace+"/"+addrs.Items[i].Name < addrs.Items[j].Namespace+"/"+addrs.Items[j].Name
+	})
+
+	changed := false
+	defer func() {
+		if changed {
+			if serr := s.save(); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}()
+
+	exists := map[string]bool{}
+	for _, addr := range addrs.Items {
+		key := simIPAMKey(&addr)
+		exists[key] = true
+		if vip := addr.Status.Address; vip != "" && s.allocator.Owner(vip) != key && s.allocator.Contains(vip) {
+			s.allocator.Release(key)
+			if err := s.allocator.AllocateAddress(key, vip); err != nil {
+				AddressLogger(&addr).Warnf("[simIPAM] cannot record address: %s", err.Error())
+			}
+			changed = true
+		}
+	}
+
+	for vip, key := range s.allocator.Allocations() {
+		if !exists[key] {
+			s.release(key, vip)
+			changed = true
+		}
+	}
+
+	now := time.Now()
+	for _, addr := range addrs.Items {
+		if addr.Status.Address != "" {
+			continue
+		}
+		if wait := s.AssignDelay - now.Sub(addr.CreationTimestamp.Time); wait > 0 {
+			if next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+
+		key := simIPAMKey(&addr)
+		vip := s.allocator.Address(key)
+		if vip == "" {
+			network, nerr := s.network(&addr)
+			if nerr != nil {
+				AddressLogger(&addr).Warnf("[simIPAM] not assigning: %s", nerr.Error())
+				continue
+			}
+			if vip, err = s.allocator.AllocateFrom(network, key); err != nil {
+				return 0, fmt.Errorf("[simIPAM] %w", err)
+			}
+			changed = true
+		}
+
+		addr.Status.Address = vip
+		AddressLogger(&addr).Debug("[simIPAM] assign")
+
+		if _, err = s.IpamClient.IpamV1().IpAddresses(addr.Namespace).Update(&addr); err != nil {
+			return 0, err
+		}
+	}
+
+	return next, nil
+}
+
+// Release the address of the deleted IpAddress right away. Use it in the DeleteFunc of an informer.
+func (s *IPAMSimulator) Deleted(address *ipamv1.IpAddress) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.init(); err != nil {
+		return err
+	}
+
+	key := simIPAMKey(address)
+	vip := s.allocator.Address(key)
+	if vip == "" {
+		return nil
+	}
+	s.release(key, vip)
+	return s.save()
+}
+
+// Returns the allocated addresses and the keys (namespace/name/uid) of their IpAddresses.
+func (s *IPAMSimulator) Allocations() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.allocator == nil {
+		return map[string]string{}
+	}
+	return s.allocator.Allocations()
+}
+
+// Returns the address allocated to the IpAddress, or "". A recreated IpAddress with the same name has
+// no allocation until it is assigned.
+func (s *IPAMSimulator) Allocation(address *ipamv1.IpAddress) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.allocator == nil {
+		return ""
+	}
+	return s.allocator.Address(simIPAMKey(address))
+}
+
+// Returns the key of the allocation of the IpAddress. It includes the UID, so an IpAddress that is deleted and
+// created again with the same name gets a new address, like with a real IPAM.
+func simIPAMKey(address *ipamv1.IpAddress) string {
+	return address.Namespace + "/" + address.Name + "/" + string(address.UID)
+}
+
+// Returns the released addresses that are not handed out again yet, and when they were released.
+func (s *IPAMSimulator) Released() map[string]time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.allocator == nil {
+		return map[string]time.Time{}
+	}
+	return s.allocator.Released()
+}
+
+// Return the address to the free addresses. Must be called with the lock held.
+func (s *IPAMSimulator) release(key, vip string) {
+	log.WithField(LogFieldVIP, vip).WithField("address", key).Debug("[simIPAM] release")
+	s.allocator.Release(key)
+}
+
+// Returns the network to assign an address of the IpAddress from.
+func (s *IPAMSimulator) network(address *ipamv1.IpAddress) (string, error) {
+	pool := address.Annotations[AnnNxVIPPool]
+
+	switch {
+	case pool != "" && len(s.Pools) > 0:
+		cidr, ok := s.Pools[pool]
+		if !ok {
+			return "", fmt.Errorf("unknown pool '%s'", pool)
+		}
+		return cidr, nil
+	case s.NamespaceNetworks[address.Namespace] != "":
+		return s.NamespaceNetworks[address.Namespace], nil
+	default:
+		return s.defaultNetwork(), nil
+	}
+}
+
+func (s *IPAMSimulator) defaultNetwork() string {
+	if s.Network != "" {
+		return s.Network
+	}
+	return DefaultSimIPAMNetwork
+}
+
+// Create the allocator for the networks and read the allocations from the ConfigMap, if there is one.
+// Must be called with the lock held.
+func (s *IPAMSimulator) init() error {
+	if s.allocator != nil {
+		return nil
+	}
+
+	networks := []string{s.defaultNetwork()}
+	for _, m := range []map[string]string{s.Pools, s.NamespaceNetworks} {
+		for _, cidr := range m {
+			networks = append(networks, cidr)
+		}
+	}
+	a, err := allocator.New(networks...)
+	if err != nil {
+		return fmt.Errorf("[simIPAM] %w", err)
+	}
+	a.ReuseDelay = s.ReuseDelay
+
+	if s.Kube != nil && s.ConfigMapName != "" {
+		cm, err := s.Kube.CoreV1().ConfigMaps(s.ConfigMapNamespace).Get(s.ConfigMapName, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("[simIPAM] error reading allocations: %w", err)
+		}
+		if err == nil {
+			state := allocator.State{}
+			if err := unmarshalData(cm, SimIPAMAllocationsKey, &state.Allocations); err != nil {
+				return err
+			}
+			if err := unmarshalData(cm, SimIPAMReleasedKey, &state.Released); err != nil {
+				return err
+			}
+			if err := a.Restore(state); err != nil {
+				return fmt.Errorf("[simIPAM] invalid state in configmap '%s/%s': %w", s.ConfigMapNamespace, s.ConfigMapName, err)
+			}
+		}
+	}
+
+	s.allocator = a
+	return nil
+}
+
+// Write the allocations to the ConfigMap, if there is one.
+func (s *IPAMSimulator) save() error {
+	if s.Kube == nil || s.ConfigMapName == "" {
+		return nil
+	}
+
+	state := s.allocator.State()
+	allocations, err := json.Marshal(state.Allocations)
+	if err != nil {
+		return err
+	}
+	released, err := json.Marshal(state.Released)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{
+		SimIPAMAllocationsKey: string(allocations),
+		SimIPAMReleasedKey:    string(released),
+	}
+
+	configMaps := s.Kube.CoreV1().ConfigMaps(s.ConfigMapNamespace)
+
+	cm, err := configMaps.Get(s.ConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.ConfigMapNamespace, Name: s.ConfigMapName},
+			Data:       data,
+		}
+		_, err = configMaps.Create(cm)
+	} else if err == nil {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		for k, v := range data {
+			cm.Data[k] = v
+		}
+		_, err = configMaps.Update(cm)
+	}
+	if err != nil {
+		return fmt.Errorf("[simIPAM] error saving allocations: %w", err)
+	}
+
+	return nil
+}
+
+// Read JSON from the ConfigMap data with the key, if it is set.
+func unmarshalData(cm *corev1.ConfigMap, key string, v interface{}) error {
+	data := cm.Data[key]
+	if data == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		return fmt.Errorf("[simIPAM] invalid %s in configmap '%s/%s': %w", key, cm.Namespace, cm.Name, err)
+	}
+	return nil
+}
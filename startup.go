@@ -0,0 +1,139 @@
+package lbutil
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	ipaminformers "github.com/Nexinto/k8s-ipam/pkg/client/informers/externalversions"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+// How long Start waits for the caches if the Startup has no SyncTimeout.
+var DefaultSyncTimeout = 2 * time.Minute
+
+// Returned by a handler wrapped with Gate while the caches are not synced yet. The key is retried.
+var ErrNotWarmedUp = errors.New("caches are not synced yet")
+
+// Starts the informers of a controller and keeps reconciles from running before their caches are synced,
+// when listers would return incomplete results.
+type Startup struct {
+
+	// The factories to start. Either may be nil.
+	KubeInformers informers.SharedInformerFactory
+	IpamInformers ipaminformers.SharedInformerFactory
+
+	// Other informers, which must be started by the caller.
+	Synced []cache.InformerSynced
+
+	// How long to wait for the caches. If zero, DefaultSyncTimeout is used.
+	SyncTimeout time.Duration
+
+	warmedUp int32
+	resynced int32
+}
+
+// Start the informer factories and wait until all caches are synced. Returns an error if they are not synced
+// within the SyncTimeout or stopCh is closed first; the informers keep running until stopCh is closed.
+func (s *Startup) Start(stopCh <-chan struct{}) error {
+	if s.KubeInformers != nil {
+		s.KubeInformers.Start(stopCh)
+	}
+	if s.IpamInformers != nil {
+		s.IpamInformers.Start(stopCh)
+	}
+
+	timeout := s.SyncTimeout
+	if timeout == 0 {
+		timeout = DefaultSyncTimeout
+	}
+
+	// Closed on timeout or when stopCh is closed, to end the waits below.
+	waitCh := make(chan struct{})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stopCh:
+		case <-timer.C:
+		case <-done:
+			return
+		}
+		close(waitCh)
+	}()
+
+	if s.KubeInformers != nil {
+		for t, ok := range s.KubeInformers.WaitForCacheSync(waitCh) {
+			if !ok {
+				return fmt.Errorf("cache for %s not synced after %s", t, timeout)
+			}
+		}
+	}
+	if s.IpamInformers != nil {
+		for t, ok := range s.IpamInformers.WaitForCacheSync(waitCh) {
+			if !ok {
+				return fmt.Errorf("cache for %s not synced after %s", t, timeout)
+			}
+		}
+	}
+	if !cache.WaitForCacheSync(waitCh, s.Synced...) {
+		return fmt.Errorf("caches not synced after %s", timeout)
+	}
+
+	atomic.StoreInt32(&s.warmedUp, 1)
+	log.Info("caches are synced")
+
+	return nil
+}
+
+// Returns true once Start synced all caches. Use it as a readiness signal.
+func (s *Startup) WarmedUp() bool {
+	return atomic.LoadInt32(&s.warmedUp) == 1
+}
+
+// Returns ErrNotWarmedUp until the caches are synced, for readiness checks (pass it as a health.Check).
+func (s *Startup) Check() error {
+	if !s.WarmedUp() {
+		return ErrNotWarmedUp
+	}
+	return nil
+}
+
+// Wrap a handler so keys are requeued instead of processed until the caches are synced.
+func (s *Startup) Gate(handler WorkerFunc) WorkerFunc {
+	return func(key string) error {
+		if !s.WarmedUp() {
+			return ErrNotWarmedUp
+		}
+		return handler(key)
+	}
+}
+
+// Queue all Services for the initial resync (see EnqueueAll). Only the first successful call does anything;
+// the caches must be synced.
+func (s *Startup) InitialResync(queue workqueue.Interface, serviceLister corelisterv1.ServiceLister, addressLister ipamlisterv1.IpAddressLister) error {
+	if !s.WarmedUp() {
+		return ErrNotWarmedUp
+	}
+	if !atomic.CompareAndSwapInt32(&s.resynced, 0, 1) {
+		return nil
+	}
+
+	if _, err := EnqueueAll(queue, serviceLister, addressLister); err != nil {
+		// Let the caller try again.
+		atomic.StoreInt32(&s.resynced, 0)
+		return err
+	}
+	return nil
+}
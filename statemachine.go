@@ -0,0 +1,448 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// The state of a Service in the VIP assignment process.
+type State string
+
+const (
+
+	// The Service is not handled by this controller.
+	StateSkipped State = "Skipped"
+
+	// No provider has claimed the Service yet.
+	StateUnclaimed State = "Unclaimed"
+
+	// The Service is claimed by this controller, but no IpAddress exists for it.
+	StateClaimed State = "Claimed"
+
+	// An IpAddress was requested, but IPAM has not assigned an address yet.
+	StateRequested State = "Requested"
+
+	// IPAM assigned an address, but it is not yet stored on the Service.
+	StateAssigned State = "Assigned"
+
+	// The VIP stored on the Service matches the IpAddress. The loadbalancer can be configured.
+	StateReady State = "Ready"
+
+	// The VIP stored on the Service no longer matches the IpAddress, or the IpAddress has disappeared.
+	StateDrifted State = "Drifted"
+
+	// The VIP is also used by another Service that has precedence. The loadbalancer must not be configured.
+	StateConflict State = "Conflict"
+
+	// The Service is claimed by this controller and should be moved to another provider (see AnnNxVIPMigrateTo).
+	StateMigrating State = "Migrating"
+
+	// The Service was released for another provider, or no longer requests a VIP. Remove the loadbalancer
+	// configuration before updating it.
+	StateReleased State = "Released"
+
+	// The Service is claimed by this controller, but the req-vip annotation was removed while RequireAnnotation is set,
+	// or the no-vip annotation was added before it got a VIP.
+	// The VIP is reset first (StateReleased), then the IpAddress is deleted and the claim removed.
+	StateUnrequested State = "Unrequested"
+
+	// The reassign token of the Service changed. The IpAddress is deleted and the VIP reset (StateReleased),
+	// so a new address is requested.
+	StateReassigning State = "Reassigning"
+
+	// The Service has the paused annotation. Nothing is changed until it is removed.
+	StatePaused State = "Paused"
+)
+
+// An action that must be performed to move a Service to its next State.
+type Action string
+
+const (
+
+	// Set the active provider annotation on the Service.
+	ActionClaim Action = "Claim"
+
+	// Create an IpAddress object for the Service.
+	ActionRequestAddress Action = "RequestAddress"
+
+	// Store the address from the IpAddress on the Service.
+	ActionStoreVIP Action = "StoreVIP"
+
+	// Remove the stored VIP from the Service.
+	ActionResetVIP Action = "ResetVIP"
+
+	// The Service copy was modified and must be updated.
+	ActionUpdateService Action = "UpdateService"
+
+	// Release the Service for the provider it is migrated to.
+	ActionRelease Action = "Release"
+
+	// Remove the record of a completed migration from the Service.
+	ActionFinishMigration Action = "FinishMigration"
+
+	// Delete the IpAddress objects of the Service.
+	ActionDeleteAddress Action = "DeleteAddress"
+
+	// Remove the claim and all VIP annotations from the Service.
+	ActionUnclaim Action = "Unclaim"
+
+	// Reset the VIP and record the reassign token as handled.
+	ActionReassign Action = "Reassign"
+)
+
+// Everything Step needs to know about a Service.
+type Observation struct {
+	Service           *corev1.Service
+	ControllerName    string
+	RequireAnnotation bool
+
+	// The Service types that get a VIP. If empty, only NodePort Services do.
+	ServiceTypes []corev1.ServiceType
+
+	// Keep the stored VIP if IPAM changes the address (see AnnNxPinVIP).
+	PinVIPs bool
+
+	// Use an IpAddress whose owner reference points to another Service with the same name (see AddressMismatchPolicy).
+	AdoptForeign bool
+
+	// The IpAddress for the Service or nil if it does not exist (or was not looked up because the Service is not claimed).
+	Address *ipamv1.IpAddress
+
+	// An IpAddress with the name of the Service that belongs to a previous Service of the same name. Not used
+	// unless AdoptForeign is set; it is replaced when a new address is requested.
+	Stale *ipamv1.IpAddress
+
+	// The IpAddress for the Service if it is being deleted (and possibly quarantined). A new address cannot be
+	// requested until it is gone.
+	Releasing *ipamv1.IpAddress
+}
+
+// Collect the observation for a Service. The IpAddress is only looked up if the Service is claimed by this controller.
+func Observe(addresses AddressGetter, service *corev1.Service, controllerName string, requireAnnotation bool) (Observation, error) {
+	return Observation{
+		Service:           service,
+		ControllerName:    controllerName,
+		RequireAnnotation: requireAnnotation,
+	}.observe(addresses, nil)
+}
+
+// Like Observe, using the Clients and their Service types.
+func (c *Clients) Observe(service *corev1.Service, controllerName string, requireAnnotation bool) (Observation, error) {
+	return Observation{
+		Service:           service,
+		ControllerName:    controllerName,
+		RequireAnnotation: requireAnnotation,
+		ServiceTypes:      c.ServiceTypes,
+		PinVIPs:           c.PinVIPs,
+		AdoptForeign:      c.AddressMismatchPolicy == MismatchAdopt,
+	}.observe(c.Addresses, c.AddressNaming)
+}
+
+// Look up the IpAddress for the Service if needed. If naming is nil, the address is named after the Service.
+// If the address is not found by name, it is looked up by its owner label if the getter supports it.
+func (obs Observation) observe(addresses AddressGetter, naming NamingStrategy) (Observation, error) {
+	service := obs.Service
+
+	if obs.skipped() || obs.paused() || service.Annotations[AnnNxVIPActiveProvider] == "" {
+		return obs, nil
+	}
+
+	namespace, name := addressKey(service, naming)
+
+	addr, err := addresses.GetIpAddress(namespace, name)
+	if errors.IsNotFound(err) {
+		finder, ok := addresses.(AddressFinder)
+		if !ok || service.UID == "" {
+			return obs, nil
+		}
+		addr, err = finder.FindIpAddress(service.Namespace, service.UID)
+		if err == nil && isRetiring(service, addr) {
+			// A previous VIP the Service is moving away from; its current address is gone.
+			return obs, nil
+		}
+	}
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return obs, nil
+		}
+		return obs, fmt.Errorf("error looking up ipaddress object for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+	if IsReleased(addr) {
+		// The address is being deleted (and possibly quarantined); it must not be used anymore.
+		obs.Releasing = addr
+		return obs, nil
+	}
+	if !obs.AdoptForeign && ownerMismatch(addr, service) {
+		obs.Stale = addr
+		return obs, nil
+	}
+	obs.Address = addr
+
+	return obs, nil
+}
+
+// Returns true if the Service is not handled by this controller. Cheaper than skipReason.
+func (obs Observation) skipped() bool {
+	service := obs.Service
+
+	return !obs.handlesType(service.Spec.Type) ||
+		!obs.requested() && service.Annotations[AnnNxVIPActiveProvider] != obs.ControllerName ||
+		service.Annotations[AnnNxVIPProvider] != "" && service.Annotations[AnnNxVIPProvider] != obs.ControllerName ||
+		service.Annotations[AnnNxVIPActiveProvider] != "" && service.Annotations[AnnNxVIPActiveProvider] != obs.ControllerName
+}
+
+// Returns why the Service is not handled by this controller, or "" if it is.
+func (obs Observation) skipReason() string {
+	service := obs.Service
+
+	if !obs.skipped() {
+		return ""
+	}
+
+	switch {
+	case !obs.handlesType(service.Spec.Type):
+		if len(obs.ServiceTypes) == 0 {
+			return "not a NodePort"
+		}
+		return fmt.Sprintf("service type %s is not handled", service.Spec.Type)
+	case service.Annotations[AnnNxNoVIP] != "" && service.Annotations[AnnNxAssignedVIP] == "" &&
+		service.Annotations[AnnNxVIPActiveProvider] != obs.ControllerName:
+		return "service has the no-vip annotation"
+	case obs.RequireAnnotation && service.Annotations[AnnNxReqVIP] == "" && service.Annotations[AnnNxVIPActiveProvider] != obs.ControllerName:
+		return "REQUIRE_TAG is true and service does not have our annotation"
+	case service.Annotations[AnnNxVIPProvider] != "" && service.Annotations[AnnNxVIPProvider] != obs.ControllerName:
+		return fmt.Sprintf("service requests provider '%s'", service.Annotations[AnnNxVIPProvider])
+	case service.Annotations[AnnNxVIPActiveProvider] != "" && service.Annotations[AnnNxVIPActiveProvider] != obs.ControllerName:
+		return fmt.Sprintf("service is managed by provider '%s'", service.Annotations[AnnNxVIPActiveProvider])
+	}
+
+	return ""
+}
+
+// Returns true if the Service asks for a VIP: it has the req-vip annotation if that is required, and no no-vip
+// annotation unless it already has a VIP.
+func (obs Observation) requested() bool {
+	service := obs.Service
+
+	if service.Annotations[AnnNxNoVIP] != "" && service.Annotations[AnnNxAssignedVIP] == "" {
+		return false
+	}
+	return !obs.RequireAnnotation || service.Annotations[AnnNxReqVIP] != ""
+}
+
+// Returns true if IPAM changed the address of the IpAddress, but the Service keeps its pinned VIP.
+func (obs Observation) pinnedDrift() bool {
+	service := obs.Service
+
+	if !obs.PinVIPs && service.Annotations[AnnNxPinVIP] == "" {
+		return false
+	}
+	return obs.Address != nil && obs.Address.Status.Address != "" &&
+		service.Annotations[AnnNxAssignedVIP] != "" && obs.Address.Status.Address != service.Annotations[AnnNxAssignedVIP]
+}
+
+// Returns true if reconciliation of the Service is paused.
+func (obs Observation) paused() bool {
+	return obs.Service.Annotations[AnnNxPaused] != ""
+}
+
+// Returns true if Services of the type get a VIP.
+func (obs Observation) handlesType(t corev1.ServiceType) bool {
+	if len(obs.ServiceTypes) == 0 {
+		return t == corev1.ServiceTypeNodePort
+	}
+	for _, st := range obs.ServiceTypes {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the current State of the observed Service.
+func (obs Observation) State() State {
+	service := obs.Service
+
+	if obs.skipped() {
+		return StateSkipped
+	}
+
+	if obs.paused() {
+		return StatePaused
+	}
+
+	if service.Annotations[AnnNxVIPActiveProvider] == "" {
+		return StateUnclaimed
+	}
+
+	if !obs.requested() {
+		return StateUnrequested
+	}
+
+	if migrating(service, obs.ControllerName) {
+		return StateMigrating
+	}
+
+	if ReassignRequested(service) {
+		return StateReassigning
+	}
+
+	if service.Annotations[AnnNxAssignedVIP] == "" {
+		switch {
+		case obs.Address == nil:
+			return StateClaimed
+		case obs.Address.Status.Address == "":
+			return StateRequested
+		default:
+			return StateAssigned
+		}
+	}
+
+	if obs.Address == nil || obs.Address.Status.Address != service.Annotations[AnnNxAssignedVIP] {
+		if obs.pinnedDrift() {
+			return StateReady
+		}
+		return StateDrifted
+	}
+
+	return StateReady
+}
+
+// Compute the state the Service will be in after performing the returned actions.
+// Step has no side effects; the actions are carried out by the caller (see EnsureVIP).
+func Step(obs Observation) (next State, actions []Action) {
+	next, actions = step(obs)
+
+	if next == StateReady && (obs.Service.Annotations[AnnNxVIPMigration] != "" || obs.Service.Annotations[AnnNxVIPMigrateTo] != "") {
+		// Performed on the result of the other actions, right before the update.
+		if len(actions) > 0 && actions[len(actions)-1] == ActionUpdateService {
+			actions = actions[:len(actions)-1]
+		}
+		actions = append(actions, ActionFinishMigration, ActionUpdateService)
+	}
+
+	return next, actions
+}
+
+func step(obs Observation) (next State, actions []Action) {
+	switch obs.State() {
+	case StateSkipped:
+		return StateSkipped, nil
+	case StateUnclaimed:
+		return StateClaimed, []Action{ActionClaim, ActionUpdateService}
+	case StateClaimed:
+		return StateRequested, []Action{ActionRequestAddress}
+	case StateRequested:
+		return StateRequested, nil
+	case StatePaused:
+		return StatePaused, nil
+	case StateMigrating:
+		return StateReleased, []Action{ActionRelease, ActionUpdateService}
+	case StateReassigning:
+		return StateReleased, []Action{ActionDeleteAddress, ActionReassign, ActionUpdateService}
+	case StateUnrequested:
+		if obs.Service.Annotations[AnnNxAssignedVIP] != "" {
+			return StateReleased, []Action{ActionResetVIP, ActionUpdateService}
+		}
+		return StateSkipped, []Action{ActionDeleteAddress, ActionUnclaim, ActionUpdateService}
+	case StateAssigned:
+		return StateReady, []Action{ActionStoreVIP, ActionUpdateService}
+	case StateDrifted:
+		if obs.Address == nil {
+			return StateClaimed, []Action{ActionResetVIP, ActionUpdateService}
+		}
+		if obs.Address.Status.Address == "" {
+			return StateRequested, []Action{ActionResetVIP, ActionUpdateService}
+		}
+		return StateReady, []Action{ActionStoreVIP, ActionUpdateService}
+	}
+
+	return StateReady, nil
+}
+
+// Returns a copy of the Service claimed by the controller.
+func ClaimService(service *corev1.Service, controllerName string) *corev1.Service {
+	newservice := service.DeepCopy()
+	if newservice.Annotations == nil {
+		newservice.Annotations = map[string]string{}
+	}
+	newservice.Annotations[AnnNxVIPActiveProvider] = controllerName
+
+	return newservice
+}
+
+// Describe the current state of the Service.
+func (obs Observation) Describe() string {
+	return obs.describe(obs.State())
+}
+
+// Describe the state of the Service.
+func (obs Observation) describe(state State) string {
+	switch state {
+	case StateSkipped:
+		return obs.skipReason()
+	case StateUnclaimed:
+		return "service is not claimed by any provider"
+	case StateClaimed:
+		return "no ip address was requested yet"
+	case StateRequested:
+		return "waiting for IPAM to assign an address"
+	case StateAssigned:
+		return "IPAM assigned an address"
+	case StateUnrequested:
+		return "service no longer requests a VIP"
+	case StatePaused:
+		return "reconciliation is paused"
+	case StateReassigning:
+		return "a new VIP was requested with the reassign annotation"
+	case StateMigrating:
+		return fmt.Sprintf("service is migrated to provider '%s'", obs.Service.Annotations[AnnNxVIPMigrateTo])
+	case StateDrifted:
+		if obs.Address == nil {
+			return "the ip address object has disappeared"
+		}
+		return "the assigned address has changed"
+	}
+
+	return "VIP is assigned"
+}
+
+// Log what is about to happen to the Service.
+func (c *Clients) logStep(obs Observation, state State) {
+	service := obs.Service
+
+	// Everything but drift and reassignment is logged at debug level; don't build loggers for messages that are dropped.
+	if state != StateDrifted && state != StateReassigning && !c.debugEnabled() {
+		return
+	}
+
+	switch state {
+	case StateSkipped:
+		c.serviceLogger(service).Debugf("skipping: %s", obs.skipReason())
+	case StateUnclaimed:
+		c.serviceLogger(service).Debug("trying to claim the service")
+	case StateClaimed:
+		c.serviceLogger(service).Debug("no address exists")
+	case StateRequested:
+		c.addressLogger(obs.Address).Debug("ip address has no address yet")
+	case StatePaused:
+		c.serviceLogger(service).Debug("reconciliation is paused")
+	case StateReassigning:
+		c.serviceLogger(service).Info("releasing the VIP to get a new one")
+	case StateUnrequested:
+		c.serviceLogger(service).Debug("service no longer requests a VIP; releasing it")
+	case StateMigrating:
+		c.serviceLogger(service).Debugf("releasing the service for provider '%s'", service.Annotations[AnnNxVIPMigrateTo])
+	case StateDrifted:
+		if obs.Address == nil {
+			c.serviceLogger(service).Info("assigned IP address has disappeared")
+		} else {
+			c.serviceLogger(service).Infof("assigned IP address has changed to %s", obs.Address.Status.Address)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package lbutil
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/features"
+)
+
+// Returns a copy of the Service claimed by controllerName if the Takeover feature is enabled and the Service is
+// claimed by a provider that is not in the registry, which selects controllerName instead. Returns nil if the
+// Service is not taken over. Services pinned to a provider with the vip-provider annotation are never taken over.
+// Providers stay in the registry when they restart; only unregistered providers lose their Services.
+func (c *Clients) takeover(service *corev1.Service, controllerName string) (*corev1.Service, error) {
+	if c.Registry == nil || !features.Enabled(features.Takeover) {
+		return nil, nil
+	}
+
+	active := service.Annotations[AnnNxVIPActiveProvider]
+	if active == "" || active == controllerName || service.Annotations[AnnNxVIPProvider] != "" {
+		return nil, nil
+	}
+
+	providers, err := c.Registry.Providers()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range providers {
+		if p.Name == active {
+			return nil, nil
+		}
+	}
+
+	selected, err := c.Registry.Select(service)
+	if err != nil || selected != controllerName {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("provider '%s' is no longer registered; taken over by '%s'", active, controllerName)
+	c.serviceLogger(service).Warn(message)
+	_ = c.Events.RecordEvent(service, ReasonTakenOver, message, true)
+
+	return ClaimService(service, controllerName), nil
+}
@@ -0,0 +1,206 @@
+// Integration test harness: runs an API server with envtest, installs the IpAddress CRD and drives EnsureVIP
+// against it, with SimIPAM standing in for the ipam controller. Requires the envtest binaries
+// (etcd, kube-apiserver; see KUBEBUILDER_ASSETS).
+
+package testenv
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// The maximum number of steps EnsureVIP takes to get a Service ready.
+const maxSteps = 10
+
+// A running test API server with clients.
+type Harness struct {
+	Env    *envtest.Environment
+	Config *rest.Config
+
+	Kube       kubernetes.Interface
+	IpamClient ipamclientset.Interface
+
+	// Clients that read IpAddress objects directly from the API server, so no informers are needed.
+	Clients *lbutil.Clients
+
+	// Assigns addresses in RunSimIPAM.
+	SimIPAM *lbutil.IPAMSimulator
+}
+
+// Start an API server with the IpAddress CRD and the CRDs found in crdPaths (for example deploy/crds).
+func Start(crdPaths ...string) (*Harness, error) {
+	env := &envtest.Environment{
+		CRDs:              []runtime.Object{ipAddressCRD()},
+		CRDDirectoryPaths: crdPaths,
+	}
+
+	config, err := env.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error starting test environment: %w", err)
+	}
+
+	h := &Harness{Env: env, Config: config}
+
+	if h.Kube, err = kubernetes.NewForConfig(config); err != nil {
+		_ = env.Stop()
+		return nil, err
+	}
+	if h.IpamClient, err = ipamclientset.NewForConfig(config); err != nil {
+		_ = env.Stop()
+		return nil, err
+	}
+
+	h.Clients = lbutil.NewClients(h.Kube, h.IpamClient, nil)
+	h.Clients.Addresses = &apiAddressGetter{ipamclient: h.IpamClient}
+	h.SimIPAM = lbutil.NewIPAMSimulator(h.IpamClient)
+
+	return h, nil
+}
+
+// Stop the API server.
+func (h *Harness) Stop() error {
+	return h.Env.Stop()
+}
+
+// Assign addresses to all IpAddress objects without one.
+func (h *Harness) RunSimIPAM() error {
+	_, err := h.SimIPAM.Run()
+	return err
+}
+
+// Create a NodePort Service with the annotations, and its namespace if needed.
+func (h *Harness) CreateService(namespace, name string, annotations map[string]string) (*corev1.Service, error) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	if _, err := h.Kube.CoreV1().Namespaces().Create(ns); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": name},
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Port:       80,
+				TargetPort: intstr.FromInt(8080),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+
+	return h.Kube.CoreV1().Services(namespace).Create(service)
+}
+
+// Run EnsureVIP for the Service, updating it and running SimIPAM between the steps, until the VIP is ready.
+// Returns the last result and the current Service.
+func (h *Harness) EnsureVIP(namespace, name, controllerName string, opts ...lbutil.Option) (*lbutil.Result, *corev1.Service, error) {
+	var result *lbutil.Result
+
+	for step := 0; step < maxSteps; step++ {
+		service, err := h.Kube.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return result, nil, err
+		}
+
+		result, err = h.Clients.Ensure(service, controllerName, opts...)
+		if err != nil {
+			return result, service, err
+		}
+
+		if result.NeedsUpdate {
+			if service, err = h.Kube.CoreV1().Services(namespace).Update(result.Service); err != nil {
+				return result, nil, err
+			}
+		}
+
+		if result.NeedsStatusUpdate {
+			lbutil.SetIngress(service, result.Ingress())
+			if service, err = h.Kube.CoreV1().Services(namespace).UpdateStatus(service); err != nil {
+				return result, nil, err
+			}
+		}
+
+		switch result.State {
+		case lbutil.StateReady:
+			if !result.NeedsUpdate {
+				return result, service, nil
+			}
+		case lbutil.StateSkipped, lbutil.StateConflict:
+			return result, service, nil
+		case lbutil.StateRequested:
+			if err := h.RunSimIPAM(); err != nil {
+				return result, service, err
+			}
+		}
+	}
+
+	return result, nil, fmt.Errorf("service '%s/%s' is not ready after %d steps (state %s)", namespace, name, maxSteps, result.State)
+}
+
+// Reads IpAddress objects from the API server.
+type apiAddressGetter struct {
+	ipamclient ipamclientset.Interface
+}
+
+func (g *apiAddressGetter) GetIpAddress(namespace, name string) (*ipamv1.IpAddress, error) {
+	return g.ipamclient.IpamV1().IpAddresses(namespace).Get(name, metav1.GetOptions{})
+}
+
+// The IpAddress CRD of k8s-ipam.
+func ipAddressCRD() runtime.Object {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name": "ipaddresses.ipam.nexinto.com",
+		},
+		"spec": map[string]interface{}{
+			"group": "ipam.nexinto.com",
+			"scope": "Namespaced",
+			"names": map[string]interface{}{
+				"kind":     "IpAddress",
+				"listKind": "IpAddressList",
+				"plural":   "ipaddresses",
+				"singular": "ipaddress",
+			},
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":    "v1",
+					"served":  true,
+					"storage": true,
+					"schema": map[string]interface{}{
+						"openAPIV3Schema": map[string]interface{}{
+							"type":                                 "object",
+							"x-kubernetes-preserve-unknown-fields": true,
+						},
+					},
+				},
+			},
+		},
+	}}
+}
@@ -0,0 +1,48 @@
+package testenv
+
+import (
+	"os"
+	"testing"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+func TestEnsureVIP(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set; the envtest binaries are needed")
+	}
+
+	h, err := Start("../deploy/crds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := h.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	vips := map[string]bool{}
+	for _, name := range []string{"one", "two"} {
+		if _, err := h.CreateService("test", name, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		result, service, err := h.EnsureVIP("test", name, "testenv")
+		if err != nil {
+			t.Fatalf("service %s: %s", name, err.Error())
+		}
+		if result.State != lbutil.StateReady {
+			t.Fatalf("service %s is %s, want %s", name, result.State, lbutil.StateReady)
+		}
+
+		vip := service.Annotations[lbutil.AnnNxAssignedVIP]
+		if vip == "" || vip != result.VIP {
+			t.Errorf("service %s has VIP '%s', result has '%s'", name, vip, result.VIP)
+		}
+		if vips[vip] {
+			t.Errorf("VIP %s was assigned twice", vip)
+		}
+		vips[vip] = true
+	}
+}
@@ -0,0 +1,192 @@
+package lbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// JSON list of the VIPs the Service is moving away from (see RetiringVIP). Maintained by EnsureVIP
+// if TransferOverlap is set.
+const AnnNxRetiringVIPs = "nexinto.com/retiring-vips"
+
+// A previous VIP of the Service that is still published until it is retired.
+type RetiringVIP struct {
+	VIP string `json:"vip"`
+
+	// The IpAddress of the VIP, as namespace/name.
+	Address string `json:"address"`
+
+	// The UID of the IpAddress.
+	UID string `json:"uid,omitempty"`
+
+	// When the IpAddress is released.
+	RetireAt metav1.Time `json:"retireAt"`
+}
+
+// Returns the retiring VIPs of the Service. Providers should keep these configured along with the VIP until they are retired.
+func RetiringVIPs(service *corev1.Service) ([]RetiringVIP, error) {
+	var retiring []RetiringVIP
+
+	if service.Annotations[AnnNxRetiringVIPs] == "" {
+		return retiring, nil
+	}
+
+	if err := json.Unmarshal([]byte(service.Annotations[AnnNxRetiringVIPs]), &retiring); err != nil {
+		return nil, fmt.Errorf("invalid retiring VIPs for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	return retiring, nil
+}
+
+// Returns true if the IpAddress holds one of the retiring VIPs of the Service.
+func isRetiring(service *corev1.Service, address *ipamv1.IpAddress) bool {
+	retiring, _ := RetiringVIPs(service)
+	for _, r := range retiring {
+		if r.Address == address.Namespace+"/"+address.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Store the retiring VIPs on the Service, which must not come from a cache.
+func setRetiringVIPs(service *corev1.Service, retiring []RetiringVIP) {
+	if len(retiring) == 0 {
+		delete(service.Annotations, AnnNxRetiringVIPs)
+		return
+	}
+
+	data, _ := json.Marshal(retiring)
+	service.Annotations[AnnNxRetiringVIPs] = string(data)
+}
+
+// Returns the name of the IpAddress requested when the Service moves to the pool.
+func transferAddressName(name, pool string) string {
+	h := fnv.New32a()
+	h.Write([]byte(pool))
+	return truncateName(name, fmt.Sprintf("-%08x", h.Sum32()))
+}
+
+// Move a ready Service to a new VIP if its pool changed, and release retiring VIPs that are due.
+// Sets RequeueAfter for the next step.
+func (c *Clients) transfer(obs Observation, result *Result) error {
+	service := result.Service
+	now := time.Now()
+
+	if obs.Address != nil && obs.Address.Annotations[AnnNxVIPPool] != c.poolFor(service) {
+		next, err := c.transferAddress(service, now, result)
+		if err != nil || next == nil {
+			return err
+		}
+		if err := c.checkAllowed(service, next.Status.Address); err != nil {
+			return err
+		}
+
+		retiring, err := RetiringVIPs(service)
+		if err != nil {
+			return err
+		}
+		retiring = append(retiring, RetiringVIP{
+			VIP:      service.Annotations[AnnNxAssignedVIP],
+			Address:  obs.Address.Namespace + "/" + obs.Address.Name,
+			UID:      string(obs.Address.UID),
+			RetireAt: metav1.NewTime(now.Add(c.TransferOverlap)),
+		})
+
+		service = c.StoreVIP(next.Status.Address, service)
+		service.Annotations[AnnNxIpAddressRef] = next.Namespace + "/" + next.Name
+		setRetiringVIPs(service, retiring)
+
+		result.Service = service
+		result.NeedsUpdate = true
+		result.Reason = fmt.Sprintf("moved to a new VIP, retiring %s", obs.Address.Status.Address)
+		result.Actions = append(result.Actions, ActionStoreVIP, ActionUpdateService)
+	}
+
+	return c.retire(result, now)
+}
+
+// Returns the IpAddress in the new pool if it has an address, requesting it if needed.
+func (c *Clients) transferAddress(service *corev1.Service, now time.Time, result *Result) (*ipamv1.IpAddress, error) {
+	name := transferAddressName(c.addressName(service), c.poolFor(service))
+
+	next, err := c.Addresses.GetIpAddress(service.Namespace, name)
+	if errors.IsNotFound(err) {
+		if err := c.requestAddress(service, name); err != nil {
+			return nil, err
+		}
+		result.RequeueAfter = RequeueMinDelay
+		result.Reason = "requested a new VIP from the pool"
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up new ipaddress for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	if next.Status.Address == "" || IsReleased(next) {
+		result.RequeueAfter = requeueDelay(next, now)
+		result.Reason = "waiting for IPAM to assign the new VIP"
+		return nil, nil
+	}
+
+	return next, nil
+}
+
+// Release the retiring VIPs of the Service that are due.
+func (c *Clients) retire(result *Result, now time.Time) error {
+	service := result.Service
+
+	retiring, err := RetiringVIPs(service)
+	if err != nil || len(retiring) == 0 {
+		return err
+	}
+
+	deleter, ok := c.AddressCreator.(AddressDeleter)
+	if !ok {
+		return fmt.Errorf("cannot retire VIPs of service '%s/%s': addresses cannot be deleted", service.Namespace, service.Name)
+	}
+
+	var keep []RetiringVIP
+	for _, r := range retiring {
+		if r.RetireAt.After(now) {
+			keep = append(keep, r)
+			if wait := r.RetireAt.Sub(now); result.RequeueAfter == 0 || wait < result.RequeueAfter {
+				result.RequeueAfter = wait
+			}
+			continue
+		}
+
+		parts := strings.SplitN(r.Address, "/", 2)
+		if len(parts) == 2 {
+			err := deleter.DeleteIpAddress(parts[0], parts[1], types.UID(r.UID))
+			if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+				return fmt.Errorf("error retiring VIP %s of service '%s/%s': %w", r.VIP, service.Namespace, service.Name, err)
+			}
+		}
+		c.serviceLogger(service).Infof("retired VIP %s", r.VIP)
+	}
+
+	if len(keep) == len(retiring) {
+		return nil
+	}
+
+	if !result.NeedsUpdate {
+		result.Service = service.DeepCopy()
+		result.NeedsUpdate = true
+		result.Actions = append(result.Actions, ActionUpdateService)
+	}
+	setRetiringVIPs(result.Service, keep)
+
+	return nil
+}
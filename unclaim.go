@@ -0,0 +1,78 @@
+package lbutil
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+)
+
+// The annotations removed when a provider gives up a Service.
+var claimAnnotations = []string{
+	AnnNxVIPActiveProvider,
+	AnnNxAssignedVIP,
+	AnnNxAssignedSecondaryVIP,
+	AnnNxVIPState,
+	AnnNxIpAddressRef,
+	AnnNxRetiringVIPs,
+	AnnNxVIPMigration,
+}
+
+// Returns a copy of the Service without the claim and the VIP annotations maintained by EnsureVIP.
+// The VIP history is kept.
+func UnclaimService(service *corev1.Service) *corev1.Service {
+	newservice := service.DeepCopy()
+	for _, key := range claimAnnotations {
+		delete(newservice.Annotations, key)
+	}
+
+	return newservice
+}
+
+// Delete the IpAddress of the Service and those of its retiring VIPs. address may be nil.
+func (c *Clients) deleteAddresses(service *corev1.Service, address *ipamv1.IpAddress) error {
+	deleter, ok := c.AddressCreator.(AddressDeleter)
+	if !ok {
+		return fmt.Errorf("cannot release the ip address of service '%s/%s': addresses cannot be deleted", service.Namespace, service.Name)
+	}
+
+	retiring, err := RetiringVIPs(service)
+	if err != nil {
+		// Don't get stuck on a broken annotation; it is removed with the claim.
+		retiring = nil
+	}
+
+	keys := map[string]types.UID{}
+	if address != nil {
+		keys[address.Namespace+"/"+address.Name] = address.UID
+	}
+	for _, r := range retiring {
+		keys[r.Address] = types.UID(r.UID)
+	}
+
+	if len(c.FamilyPools) > 0 {
+		if err := c.deleteSecondaryAddresses(service); err != nil {
+			return err
+		}
+	}
+
+	for key, uid := range keys {
+		parts := strings.SplitN(key, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		err := deleter.DeleteIpAddress(parts[0], parts[1], uid)
+		if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+			return fmt.Errorf("error releasing ip address '%s' of service '%s/%s': %w", key, service.Namespace, service.Name, err)
+		}
+	}
+
+	c.serviceLogger(service).Info("released the ip address")
+
+	return nil
+}
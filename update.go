@@ -0,0 +1,82 @@
+package lbutil
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/features"
+	"github.com/plusserver/k8s-lbutil/patch"
+)
+
+// Store the Service of a result of EnsureVIP: update the Service if NeedsUpdate is set, then its status if
+// NeedsStatusUpdate is set (see PublishStatus). service is the version EnsureVIP was called with. If only
+// annotations changed, the PatchUpdates feature is enabled and the ServiceUpdater is a ServicePatcher, the
+// annotations are patched instead of updating the whole Service.
+// Returns the updated Service; NeedsUpdate and NeedsStatusUpdate of the result are cleared as they are stored.
+func (c *Clients) UpdateResult(service *corev1.Service, result *Result) (*corev1.Service, error) {
+	updated := result.Service
+	if updated == nil {
+		return service, nil
+	}
+
+	if result.NeedsUpdate {
+		var err error
+		if updated, err = c.updateService(service, result.Service); err != nil {
+			return nil, fmt.Errorf("error updating service '%s/%s': %w", service.Namespace, service.Name, err)
+		}
+		result.NeedsUpdate = false
+	}
+
+	if result.NeedsStatusUpdate {
+		SetIngress(updated, result.Ingress())
+		var err error
+		if updated, err = c.UpdateServiceStatus(updated); err != nil {
+			return nil, fmt.Errorf("error updating status of service '%s/%s': %w", service.Namespace, service.Name, err)
+		}
+		result.NeedsStatusUpdate = false
+	}
+
+	result.Service = updated
+
+	return updated, nil
+}
+
+// Update the Service from old to new, with a patch of the annotations if possible.
+func (c *Clients) updateService(old, newService *corev1.Service) (*corev1.Service, error) {
+	patcher, ok := c.Services.(ServicePatcher)
+	if !ok || !features.Enabled(features.PatchUpdates) || !onlyAnnotationsChanged(old, newService) {
+		return c.Services.UpdateService(newService)
+	}
+
+	b := patch.New().TestResourceVersion(old)
+	for key, value := range newService.Annotations {
+		if current, ok := old.Annotations[key]; !ok || current != value {
+			b.AddAnnotation(old, key, value)
+		}
+	}
+	for key := range old.Annotations {
+		if _, ok := newService.Annotations[key]; !ok {
+			b.RemoveAnnotation(old, key)
+		}
+	}
+	if b.Empty() {
+		return old, nil
+	}
+
+	data, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return patcher.PatchService(old.Namespace, old.Name, data)
+}
+
+// Returns true if the Services differ in their annotations only, ignoring the status.
+func onlyAnnotationsChanged(old, newService *corev1.Service) bool {
+	a, b := old.DeepCopy(), newService.DeepCopy()
+	a.Annotations, b.Annotations = nil, nil
+	a.Status, b.Status = corev1.ServiceStatus{}, corev1.ServiceStatus{}
+	return equality.Semantic.DeepEqual(a, b)
+}
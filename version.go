@@ -0,0 +1,110 @@
+package lbutil
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/plusserver/k8s-lbutil/internal/sanitize"
+)
+
+// The annotation schema version written by this version of the library.
+// Services without a version annotation use version 0, the schema from before versioning was introduced.
+const AnnotationVersion = 1
+
+// Converts the annotations of a Service from one schema version to the next. The map may be modified in place.
+type AnnotationConversion func(annotations map[string]string) error
+
+var (
+	conversionsLock sync.RWMutex
+
+	// conversions[n] converts from version n to n+1.
+	conversions = map[int]AnnotationConversion{
+		// Version 1 introduced the version annotation; the semantics of all other annotations are unchanged.
+		0: func(map[string]string) error { return nil },
+	}
+)
+
+// Register the conversion from version "from" to from+1. Only used when AnnotationVersion is raised.
+func RegisterAnnotationConversion(from int, conversion AnnotationConversion) {
+	conversionsLock.Lock()
+	defer conversionsLock.Unlock()
+
+	conversions[from] = conversion
+}
+
+// Returns the annotation schema version of the Service.
+func AnnotationVersionOf(service *corev1.Service) (int, error) {
+	v := service.Annotations[AnnNxLBConfigVersion]
+	if v == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("%s: invalid version '%s'", AnnNxLBConfigVersion, sanitize.Value(v))
+	}
+	return version, nil
+}
+
+// Convert the annotations of the Service to AnnotationVersion. If changed is true, the returned copy differs from
+// the Service and should be updated. The Service is only copied if a conversion changes its annotations; Services
+// without lbutil annotations are returned as they are. Services written by a newer version of the library are
+// rejected rather than misread.
+func DecodeAnnotations(service *corev1.Service) (decoded *corev1.Service, changed bool, err error) {
+	version, err := AnnotationVersionOf(service)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if version > AnnotationVersion {
+		return nil, false, fmt.Errorf("service '%s/%s' uses annotation version %d, but only versions up to %d are supported",
+			service.Namespace, service.Name, version, AnnotationVersion)
+	}
+
+	if version == AnnotationVersion || !hasLBUtilAnnotation(service) {
+		return service, false, nil
+	}
+
+	annotations := make(map[string]string, len(service.Annotations)+1)
+	for k, v := range service.Annotations {
+		annotations[k] = v
+	}
+
+	conversionsLock.RLock()
+	defer conversionsLock.RUnlock()
+
+	for ; version < AnnotationVersion; version++ {
+		conversion, ok := conversions[version]
+		if !ok {
+			return nil, false, fmt.Errorf("no conversion from annotation version %d", version)
+		}
+		if err := conversion(annotations); err != nil {
+			return nil, false, fmt.Errorf("error converting annotations of service '%s/%s' from version %d: %w",
+				service.Namespace, service.Name, version, err)
+		}
+	}
+
+	if equalAnnotations(annotations, service.Annotations) {
+		return service, false, nil
+	}
+
+	annotations[AnnNxLBConfigVersion] = strconv.Itoa(AnnotationVersion)
+	decoded = service.DeepCopy()
+	decoded.Annotations = annotations
+
+	return decoded, true, nil
+}
+
+func equalAnnotations(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package lbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// JSON document with the state of the Service in the VIP assignment process (see VIPState). Maintained by EnsureVIP.
+// Example: kubectl get svc -o jsonpath='{.items[*].metadata.annotations.nexinto\.com/vip-state}'
+const AnnNxVIPState = "nexinto.com/vip-state"
+
+// The content of the vip-state annotation.
+type VIPState struct {
+	Phase          State       `json:"phase"`
+	LastTransition metav1.Time `json:"lastTransition"`
+	LastError      string      `json:"lastError,omitempty"`
+	Provider       string      `json:"provider,omitempty"`
+}
+
+// Returns the state stored on the Service or nil if there is none.
+func GetVIPState(service *corev1.Service) (*VIPState, error) {
+	if service.Annotations[AnnNxVIPState] == "" {
+		return nil, nil
+	}
+
+	state := &VIPState{}
+	if err := json.Unmarshal([]byte(service.Annotations[AnnNxVIPState]), state); err != nil {
+		return nil, fmt.Errorf("invalid VIP state for service '%s/%s': %w", service.Namespace, service.Name, err)
+	}
+
+	return state, nil
+}
+
+// Store the phase, error and provider on the Service. The transition time is only updated if the phase changes.
+// Returns false and leaves the Service alone if nothing changed, so callers can avoid needless updates.
+// Modifies the Service, which must not come from a cache. An unparseable state is replaced.
+func SetVIPState(service *corev1.Service, phase State, lastError string, now time.Time) bool {
+	value, changed := vipStateValue(service, phase, lastError, now)
+	if !changed {
+		return false
+	}
+
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	service.Annotations[AnnNxVIPState] = value
+
+	return true
+}
+
+// Returns the new value of the state annotation and if it differs from the current one. Does not modify the Service.
+func vipStateValue(service *corev1.Service, phase State, lastError string, now time.Time) (string, bool) {
+	old, _ := GetVIPState(service)
+
+	provider := service.Annotations[AnnNxVIPActiveProvider]
+
+	state := VIPState{
+		Phase:          phase,
+		LastTransition: metav1.NewTime(now),
+		LastError:      lastError,
+		Provider:       provider,
+	}
+
+	if old != nil {
+		if old.Phase == phase && old.LastError == lastError && old.Provider == provider {
+			return "", false
+		}
+		if old.Phase == phase {
+			state.LastTransition = old.LastTransition
+		}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
@@ -0,0 +1,276 @@
+package lbutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamlisterv1 "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+const (
+
+	// Label on pre-requested IpAddress objects in a warm pool, with the name of the address pool they were requested from.
+	LabelNxWarmPool = "nexinto.com/warm-pool"
+
+	// The namespace/name of the IpAddress of the Service if it is not named after the Service (for example
+	// if it was adopted from a warm pool).
+	AnnNxIpAddressRef = "nexinto.com/ipaddress"
+)
+
+// The value of LabelNxWarmPool for addresses from the default address pool.
+const DefaultWarmPool = "default"
+
+// Keeps a number of pre-requested IpAddress objects per address pool, so new Services get a VIP without waiting
+// for IPAM. EnsureVIP adopts an assigned address from the pool before requesting a new one.
+// Adopted addresses in a different namespace than the Service have no owner reference and are not garbage
+// collected with the Service; set Services to let Run delete them.
+type WarmPool struct {
+
+	// The namespace of the pre-requested addresses.
+	Namespace string
+
+	// The number of unused addresses to keep per address pool.
+	Size int
+
+	IpamClient ipamclientset.Interface
+	Lister     ipamlisterv1.IpAddressLister
+
+	// If set, Run deletes adopted addresses whose Service no longer exists (see CollectGarbage).
+	Services corelisterv1.ServiceLister
+
+	lock sync.Mutex
+}
+
+// The value of LabelNxWarmPool for the address pool.
+func warmPoolName(pool string) string {
+	if pool == "" {
+		return DefaultWarmPool
+	}
+	return pool
+}
+
+// Returns the unused addresses of the warm pool for the address pool, oldest first.
+func (p *WarmPool) available(pool string) ([]*ipamv1.IpAddress, error) {
+	selector := labels.SelectorFromSet(labels.Set{LabelNxWarmPool: warmPoolName(pool)})
+	addresses, err := p.Lister.IpAddresses(p.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var unused []*ipamv1.IpAddress
+	for _, address := range addresses {
+		if !IsReleased(address) {
+			unused = append(unused, address)
+		}
+	}
+
+	sort.Slice(unused, func(i, j int) bool {
+		return unused[i].CreationTimestamp.Before(&unused[j].CreationTimestamp)
+	})
+
+	return unused, nil
+}
+
+// Request addresses until the warm pool for the address pool ("" is the default pool) has Size unused addresses.
+func (p *WarmPool) Fill(pool string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	unused, err := p.available(pool)
+	if err != nil {
+		return err
+	}
+
+	for i := len(unused); i < p.Size; i++ {
+		addr := &ipamv1.IpAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "warm-",
+				Namespace:    p.Namespace,
+				Labels:       map[string]string{LabelNxWarmPool: warmPoolName(pool)},
+			},
+			Spec: ipamv1.IpAddressSpec{
+				Description: "warm pool",
+			},
+		}
+		if pool != "" {
+			addr.Annotations = map[string]string{AnnNxVIPPool: pool}
+		}
+
+		created, err := p.IpamClient.IpamV1().IpAddresses(p.Namespace).Create(addr)
+		if err != nil {
+			return fmt.Errorf("error filling warm pool '%s': %w", warmPoolName(pool), err)
+		}
+		AddressLogger(created).Debug("requested warm pool address")
+	}
+
+	return nil
+}
+
+// Fill the warm pools for the address pools every interval until stopCh is closed. If Services is set,
+// the addresses of deleted Services are collected as well.
+func (p *WarmPool) Run(pools []string, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		for _, pool := range pools {
+			if err := p.Fill(pool); err != nil {
+				log.Error(err.Error())
+			}
+		}
+		if p.Services != nil {
+			if err := p.CollectGarbage(); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}, interval, stopCh)
+}
+
+// Delete the adopted addresses in the namespace of the warm pool whose Service no longer exists. Addresses in
+// the namespace of their Service have an owner reference and are left to the garbage collector.
+func (p *WarmPool) CollectGarbage() error {
+	addresses, err := p.Lister.IpAddresses(p.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		namespace, name, ok := adoptedServiceKey(address)
+		if !ok || namespace == address.Namespace || IsReleased(address) {
+			continue
+		}
+
+		service, err := p.Services.Services(namespace).Get(name)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil && service.UID == AddressOwnerUID(address) {
+			continue
+		}
+
+		uid := address.UID
+		err = p.IpamClient.IpamV1().IpAddresses(address.Namespace).Delete(address.Name, &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &uid},
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting ip address '%s/%s' of deleted service '%s/%s': %w",
+				address.Namespace, address.Name, namespace, name, err)
+		}
+		AddressLogger(address).Infof("deleted ip address of deleted service '%s/%s'", namespace, name)
+	}
+
+	return nil
+}
+
+// Take an assigned address from the warm pool for the address pool of the Service and label it as belonging
+// to the Service. Returns nil if no assigned address is available. Services requesting a specific address
+// never adopt.
+// The adoption is recorded on the IpAddress first: if the Service cannot be updated with the reference to the
+// address, the next call returns the same address instead of adopting another one.
+func (p *WarmPool) Adopt(service *corev1.Service) (*ipamv1.IpAddress, error) {
+	return p.adopt(service, service.Annotations[AnnNxVIPPool], nil)
+}
+
+// Like Adopt, from the warm pool for the address pool. Only addresses accept returns true for are adopted;
+// accept may be nil.
+func (p *WarmPool) adopt(service *corev1.Service, pool string, accept func(address string) bool) (*ipamv1.IpAddress, error) {
+	if service.Annotations[AnnNxRequestedIP] != "" {
+		return nil, nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	previous, err := p.adopted(service)
+	if err != nil || previous != nil {
+		return previous, err
+	}
+
+	unused, err := p.available(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range unused {
+		if address.Status.Address == "" || accept != nil && !accept(address.Status.Address) {
+			continue
+		}
+
+		adopted := address.DeepCopy()
+		delete(adopted.Labels, LabelNxWarmPool)
+		SetAddressOwnerLabel(adopted, service)
+		adopted.Labels[LabelNxServiceNamespace] = service.Namespace
+		adopted.Labels[LabelNxServiceName] = service.Name
+		adopted.Spec.Description = fmt.Sprintf("created for service %s", service.Name)
+		if adopted.Namespace == service.Namespace {
+			adopted.OwnerReferences = []metav1.OwnerReference{{
+				Name:       service.Name,
+				Kind:       "Service",
+				APIVersion: "v1",
+				UID:        service.UID,
+			}}
+		}
+
+		updated, err := p.IpamClient.IpamV1().IpAddresses(adopted.Namespace).Update(adopted)
+		if errors.IsConflict(err) || errors.IsNotFound(err) {
+			// Changed or deleted since the lister saw it; try the next one.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error adopting ip address '%s/%s' for service '%s/%s': %w",
+				address.Namespace, address.Name, service.Namespace, service.Name, err)
+		}
+
+		ServiceLogger(service).WithField(LogFieldIpAddress, address.Name).Info("adopted ip address from warm pool")
+
+		return updated, nil
+	}
+
+	return nil, nil
+}
+
+// Returns the address of the warm pool that was adopted for the Service before, or nil if there is none.
+func (p *WarmPool) adopted(service *corev1.Service) (*ipamv1.IpAddress, error) {
+	if service.UID == "" {
+		return nil, nil
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{LabelNxServiceUID: string(service.UID)})
+	addresses, err := p.Lister.IpAddresses(p.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range addresses {
+		if !IsReleased(address) && address.Labels[LabelNxWarmPool] == "" && address.Status.Address != "" {
+			return address, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns the namespace and name of the IpAddress for the Service.
+func addressKey(service *corev1.Service, naming NamingStrategy) (namespace, name string) {
+	if ref := service.Annotations[AnnNxIpAddressRef]; ref != "" {
+		if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			return parts[0], parts[1]
+		}
+	}
+	if naming == nil {
+		return service.Namespace, service.Name
+	}
+	return service.Namespace, naming(service)
+}
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/annotations"
+	"github.com/plusserver/k8s-lbutil/patch"
+)
+
+// Rejects Services with invalid lbutil annotations (see annotations.Validate). Annotations added to the
+// registry are checked without changes here.
+type AnnotationValidator struct {
+
+	// Resolves the LBConfig referenced by Services, so the settings are validated as providers see them.
+	// Optional; without it, only the annotations are validated.
+	LBConfigs *annotations.Resolver
+}
+
+// Handle AdmissionReview requests for Services.
+func (v *AnnotationValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, v.review)
+}
+
+func (v *AnnotationValidator) review(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if req.Operation != admissionv1beta1.Create && req.Operation != admissionv1beta1.Update {
+		return allowed()
+	}
+
+	service := &corev1.Service{}
+	if err := json.Unmarshal(req.Object.Raw, service); err != nil {
+		return denied(fmt.Sprintf("cannot decode service: %s", err.Error()))
+	}
+
+	for _, warning := range annotations.Deprecations(service) {
+		lbutil.ServiceLogger(service).Warn(warning)
+	}
+
+	if v.LBConfigs != nil {
+		resolved, err := v.LBConfigs.Resolve(service)
+		if err != nil {
+			// The LBConfig may be created after the Service; providers report it then.
+			lbutil.ServiceLogger(service).Warn(err.Error())
+		} else {
+			service = resolved
+		}
+	}
+
+	if err := annotations.Validate(service); err != nil {
+		lbutil.ServiceLogger(service).Infof("denied service: %s", err.Error())
+		return denied(err.Error())
+	}
+
+	return allowed()
+}
+
+// Sets the default values of the registered annotations on new Services (see annotations.Default).
+type AnnotationDefaulter struct{}
+
+// Handle AdmissionReview requests for Services.
+func (d *AnnotationDefaulter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, d.review)
+}
+
+func (d *AnnotationDefaulter) review(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if req.Operation != admissionv1beta1.Create {
+		return allowed()
+	}
+
+	service := &corev1.Service{}
+	if err := json.Unmarshal(req.Object.Raw, service); err != nil {
+		return denied(fmt.Sprintf("cannot decode service: %s", err.Error()))
+	}
+
+	defaults := annotations.Defaults(service)
+	if len(defaults) == 0 {
+		return allowed()
+	}
+
+	// Sorted, so the patch is deterministic.
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b := patch.New()
+	for _, key := range keys {
+		b.AddAnnotation(service, key, defaults[key])
+	}
+	data, err := b.Build()
+	if err != nil {
+		return denied(err.Error())
+	}
+
+	pt := admissionv1beta1.PatchTypeJSONPatch
+	response := allowed()
+	response.Patch = data
+	response.PatchType = &pt
+
+	return response
+}
@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/patch"
+)
+
+// Sets the vip-provider annotation of new Services that request a VIP to the provider selected by the registry.
+type ProviderDefaulter struct {
+	Registry *lbutil.Registry
+}
+
+// Returns the provider to set on the Service, or "" if it is left alone.
+func (d *ProviderDefaulter) Default(service *corev1.Service) (string, error) {
+	if service.Annotations[lbutil.AnnNxReqVIP] == "" || service.Annotations[lbutil.AnnNxVIPProvider] != "" {
+		return "", nil
+	}
+	return d.Registry.Select(service)
+}
+
+// Handle AdmissionReview requests for Services.
+func (d *ProviderDefaulter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, d.review)
+}
+
+func (d *ProviderDefaulter) review(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if req.Operation != admissionv1beta1.Create {
+		return allowed()
+	}
+
+	service := &corev1.Service{}
+	if err := json.Unmarshal(req.Object.Raw, service); err != nil {
+		return denied(fmt.Sprintf("cannot decode service: %s", err.Error()))
+	}
+
+	provider, err := d.Default(service)
+	if err != nil {
+		// Don't block Services because the registry is unavailable; EnsureVIP consults it again.
+		lbutil.ServiceLogger(service).Errorf("error selecting provider: %s", err.Error())
+		return allowed()
+	}
+	if provider == "" {
+		return allowed()
+	}
+
+	data, err := patch.New().AddAnnotation(service, lbutil.AnnNxVIPProvider, provider).Build()
+	if err != nil {
+		return denied(err.Error())
+	}
+
+	lbutil.ServiceLogger(service).Infof("selected provider '%s'", provider)
+
+	pt := admissionv1beta1.PatchTypeJSONPatch
+	response := allowed()
+	response.Patch = data
+	response.PatchType = &pt
+
+	return response
+}
@@ -0,0 +1,127 @@
+// Admission webhooks for objects managed by lbutil.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+	"github.com/plusserver/k8s-lbutil/addresses"
+)
+
+// Rejects the deletion of IpAddress objects while the owning Service still exists and requests a VIP,
+// unless the IpAddress has the AnnNxForceDelete annotation. Addresses of a second VIP the Service no longer
+// needs may be deleted.
+type IpAddressDeletionValidator struct {
+	ServiceLister corelisterv1.ServiceLister
+}
+
+// Decide if the IpAddress may be deleted. If not, reason explains why.
+func (v *IpAddressDeletionValidator) Validate(address *ipamv1.IpAddress) (allowed bool, reason string, err error) {
+	if address.Annotations[lbutil.AnnNxForceDelete] != "" {
+		return true, "", nil
+	}
+
+	for _, ref := range address.OwnerReferences {
+		if ref.Kind != "Service" || ref.APIVersion != "v1" {
+			continue
+		}
+
+		service, err := v.ServiceLister.Services(address.Namespace).Get(ref.Name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return false, "", err
+		}
+
+		if service.UID != ref.UID || !requestsVIP(service) || retired(service, address) || lbutil.ReassignRequested(service) ||
+			staleSecondary(service, address) {
+			continue
+		}
+
+		return false, fmt.Sprintf("ipaddress is in use by service '%s'; set the annotation %s to delete it anyway", service.Name, lbutil.AnnNxForceDelete), nil
+	}
+
+	return true, "", nil
+}
+
+// Returns true if the IpAddress is a retiring VIP of the Service that is due to be released.
+func retired(service *corev1.Service, address *ipamv1.IpAddress) bool {
+	retiring, err := lbutil.RetiringVIPs(service)
+	if err != nil {
+		return false
+	}
+
+	for _, r := range retiring {
+		if r.Address == address.Namespace+"/"+address.Name && !r.RetireAt.After(time.Now()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if the IpAddress is for the second VIP of a dual-stack Service that no longer needs it: the Service
+// is single-stack now, or its second family changed.
+func staleSecondary(service *corev1.Service, address *ipamv1.IpAddress) bool {
+	if address.Labels[lbutil.LabelNxAddressRole] != lbutil.AddressRoleSecondary {
+		return false
+	}
+
+	policy, families, err := lbutil.ServiceFamilies(service)
+	if err != nil {
+		return false
+	}
+	if policy == lbutil.IPFamilyPolicySingleStack {
+		return true
+	}
+	return address.Status.Address != "" && addresses.FamilyOf(address.Status.Address) != families[1]
+}
+
+// Returns true if the Service has requested a VIP or was assigned one.
+func requestsVIP(service *corev1.Service) bool {
+	if service.Annotations[lbutil.AnnNxAssignedVIP] != "" {
+		return true
+	}
+	return service.Annotations[lbutil.AnnNxReqVIP] != "" && service.Annotations[lbutil.AnnNxNoVIP] == ""
+}
+
+// Handle AdmissionReview requests for IpAddress objects.
+func (v *IpAddressDeletionValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, v.review)
+}
+
+func (v *IpAddressDeletionValidator) review(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if req.Operation != admissionv1beta1.Delete {
+		return allowed()
+	}
+
+	address := &ipamv1.IpAddress{}
+	if err := json.Unmarshal(req.OldObject.Raw, address); err != nil {
+		return denied(fmt.Sprintf("cannot decode ipaddress: %s", err.Error()))
+	}
+
+	ok, reason, err := v.Validate(address)
+	if err != nil {
+		lbutil.AddressLogger(address).Errorf("error validating deletion: %s", err.Error())
+		return denied(err.Error())
+	}
+	if !ok {
+		lbutil.AddressLogger(address).Infof("denied deletion: %s", reason)
+		return denied(reason)
+	}
+
+	return allowed()
+}
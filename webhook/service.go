@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"k8s.io/client-go/tools/cache"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+
+	lbutil "github.com/plusserver/k8s-lbutil"
+)
+
+// Rejects Services that request or are annotated with a VIP already used by another Service. Updates are only
+// rejected if they add such a VIP, so a Service that already conflicts can still be changed, for example to
+// remove the VIP.
+type ServiceVIPValidator struct {
+
+	// A Service informer indexer with the VIP index (see lbutil.AddServiceIndexers).
+	ServiceIndexer cache.Indexer
+}
+
+// Decide if the Service may be created (old is nil) or updated. If not, reason explains why.
+func (v *ServiceVIPValidator) Validate(old, service *corev1.Service) (allowed bool, reason string, err error) {
+	unchanged := map[string]bool{}
+	if old != nil {
+		for _, vip := range lbutil.ServiceVIPs(old) {
+			unchanged[vip] = true
+		}
+	}
+
+	conflicts, err := lbutil.FindVIPConflicts(v.ServiceIndexer, service)
+	if err != nil {
+		return false, "", err
+	}
+
+	vips := lbutil.ServiceVIPs(service)
+	for _, other := range conflicts {
+		for _, vip := range lbutil.ServiceVIPs(other) {
+			if contains(vips, vip) && !unchanged[vip] {
+				return false, fmt.Sprintf("the VIP %s is already used by service '%s/%s'", vip, other.Namespace, other.Name), nil
+			}
+		}
+	}
+
+	return true, "", nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle AdmissionReview requests for Services.
+func (v *ServiceVIPValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, v.review)
+}
+
+func (v *ServiceVIPValidator) review(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if req.Operation != admissionv1beta1.Create && req.Operation != admissionv1beta1.Update {
+		return allowed()
+	}
+
+	service := &corev1.Service{}
+	if err := json.Unmarshal(req.Object.Raw, service); err != nil {
+		return denied(fmt.Sprintf("cannot decode service: %s", err.Error()))
+	}
+
+	var old *corev1.Service
+	if req.Operation == admissionv1beta1.Update && len(req.OldObject.Raw) > 0 {
+		old = &corev1.Service{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return denied(fmt.Sprintf("cannot decode old service: %s", err.Error()))
+		}
+	}
+
+	ok, reason, err := v.Validate(old, service)
+	if err != nil {
+		lbutil.ServiceLogger(service).Errorf("error validating service: %s", err.Error())
+		return denied(err.Error())
+	}
+	if !ok {
+		lbutil.ServiceLogger(service).Infof("denied service: %s", reason)
+		return denied(reason)
+	}
+
+	return allowed()
+}
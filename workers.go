@@ -0,0 +1,153 @@
+package lbutil
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Processes one key from the queue.
+type WorkerFunc func(key string) error
+
+// Returned by a WorkerFunc for errors that retrying will not fix. The key is not requeued.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Mark an error as permanent.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// Returns true if the error or one it wraps is a PermanentError.
+func IsPermanent(err error) bool {
+	var p *PermanentError
+	return errors.As(err, &p)
+}
+
+// Like (*Clients).RunWorkers, but panics are only logged.
+func RunWorkers(queue workqueue.RateLimitingInterface, n int, handler WorkerFunc, stopCh <-chan struct{}) {
+	(&Clients{}).RunWorkers(queue, n, handler, stopCh)
+}
+
+// Start n workers that process keys from the queue with the handler and block until stopCh is closed.
+// Keys are forgotten if the handler succeeds, and requeued or given up on depending on the error (see Classify).
+// On shutdown, the queue is shut down and the workers finish the keys already queued before RunWorkers returns.
+// A panic in the handler is recovered and recorded as a Warning Event for the Service with the key.
+// The key is locked in ServiceLocks while it is processed.
+func (c *Clients) RunWorkers(queue workqueue.RateLimitingInterface, n int, handler WorkerFunc, stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem(queue, handler) {
+			}
+		}()
+	}
+
+	<-stopCh
+	log.Info("shutting down workers")
+	queue.ShutDown()
+	wg.Wait()
+}
+
+// Process one key. Returns false if the queue was shut down and is empty.
+func (c *Clients) processNextItem(queue workqueue.RateLimitingInterface, handler WorkerFunc) bool {
+	item, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(item)
+
+	key, ok := item.(string)
+	if !ok {
+		log.Errorf("unexpected item of type %T in queue", item)
+		queue.Forget(item)
+		return true
+	}
+
+	err := c.safeHandle(handler, key)
+
+	classification := Classify(err)
+
+	switch classification.Decision {
+	case DecisionNone:
+		queue.Forget(item)
+	case DecisionFail:
+		log.WithField("key", key).Errorf("giving up: %s", err.Error())
+		queue.Forget(item)
+	case DecisionRetry:
+		// Still rate limited, so a conflict with a stale cache does not turn into a busy loop.
+		log.WithField("key", key).Debugf("retrying: %s", err.Error())
+		c.requeue(queue, item, classification.Class)
+	default:
+		log.WithField("key", key).Warnf("retrying: %s", err.Error())
+		if classification.After > 0 {
+			queue.AddAfter(item, classification.After)
+		} else {
+			c.requeue(queue, item, classification.Class)
+		}
+	}
+
+	return true
+}
+
+// Add the item rate limited, with the delay for the error class if Backoff is set.
+func (c *Clients) requeue(queue workqueue.RateLimitingInterface, item interface{}, class ErrorClass) {
+	if c.Backoff == nil {
+		queue.AddRateLimited(item)
+		return
+	}
+	queue.AddAfter(item, c.Backoff.WhenClass(item, class))
+}
+
+// Call the handler and turn a panic into an error.
+func (c *Clients) safeHandle(handler WorkerFunc, key string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic processing '%s': %v", key, r)
+			log.WithField("key", key).Errorf("%s\n%s", err.Error(), debug.Stack())
+			c.reportPanic(key, err)
+		}
+	}()
+
+	return ServiceLocks.Do(key, func() error {
+		return handler(key)
+	})
+}
+
+func (c *Clients) reportPanic(key string, err error) {
+	if c.Events == nil {
+		return
+	}
+
+	namespace, name, kerr := cache.SplitMetaNamespaceKey(key)
+	if kerr != nil {
+		return
+	}
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	_ = c.Events.RecordEvent(service, ReasonFailed, err.Error(), true)
+}